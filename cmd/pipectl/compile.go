@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/frontend"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/compiler"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/envsubst"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/include"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/matrix"
+)
+
+var compileCommand = cli.Command{
+	Name:   "compile",
+	Usage:  "compile the yaml file",
+	Action: compileAction,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "in",
+			Value: "pipeline.yml",
+		},
+		cli.StringFlag{
+			Name:  "out",
+			Value: "pipeline.json",
+		},
+		cli.StringFlag{
+			Name:  "prefix",
+			Value: compiler.DefaultPrefix,
+		},
+		cli.StringFlag{
+			Name:  "workspace-base",
+			Value: compiler.DefaultWorkspaceBase,
+		},
+		cli.StringFlag{
+			Name:  "workspace-path",
+			Value: compiler.DefaultWorkspacePath,
+		},
+		cli.StringFlag{
+			Name:  "clone-image",
+			Usage: "image of the default clone step",
+			Value: compiler.DefaultCloneImage,
+		},
+		cli.StringFlag{
+			Name:  "cache-image",
+			Usage: "image of the cache restore and save steps",
+			Value: compiler.DefaultCacheImage,
+		},
+		cli.BoolFlag{
+			Name:   "strict",
+			Usage:  "fail on ${VAR} references to unset variables",
+			EnvVar: "CI_ENVSUBST_STRICT",
+		},
+		cli.StringSliceFlag{
+			Name: "volumes",
+		},
+		cli.StringSliceFlag{
+			Name: "network",
+		},
+		cli.StringSliceFlag{
+			Name: "privileged",
+			Value: &cli.StringSlice{
+				"plugins/docker",
+				"plugins/gcr",
+				"plugins/ecr",
+			},
+		},
+
+		//
+		// netrc parameters
+		//
+		cli.StringFlag{
+			Name:   "netrc-username",
+			EnvVar: "CI_NETRC_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "netrc-password",
+			EnvVar: "CI_NETRC_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:   "netrc-machine",
+			EnvVar: "CI_NETRC_MACHINE",
+		},
+
+		//
+		// metadata parameters
+		//
+		cli.StringFlag{
+			Name:   "system-name",
+			EnvVar: "CI_SYSTEM_NAME",
+			Value:  "pipec",
+		},
+		cli.StringFlag{
+			Name:   "system-link",
+			EnvVar: "CI_SYSTEM_LINK",
+			Value:  "https://github.com/cncd/pipec",
+		},
+		cli.StringFlag{
+			Name:   "system-arch",
+			EnvVar: "CI_SYSTEM_ARCH",
+			Value:  "linux/amd64",
+		},
+		cli.StringFlag{
+			Name:   "repo-name",
+			EnvVar: "CI_REPO_NAME",
+		},
+		cli.StringFlag{
+			Name:   "repo-link",
+			EnvVar: "CI_REPO_LINK",
+		},
+		cli.StringFlag{
+			Name:   "repo-remote-url",
+			EnvVar: "CI_REPO_REMOTE",
+		},
+		cli.BoolFlag{
+			Name:   "repo-private",
+			EnvVar: "CI_REPO_PRIVATE",
+		},
+		cli.IntFlag{
+			Name:   "build-number",
+			EnvVar: "CI_BUILD_NUMBER",
+		},
+		cli.Int64Flag{
+			Name:   "build-created",
+			EnvVar: "CI_BUILD_CREATED",
+		},
+		cli.Int64Flag{
+			Name:   "build-started",
+			EnvVar: "CI_BUILD_STARTED",
+		},
+		cli.StringFlag{
+			Name:   "build-event",
+			EnvVar: "CI_BUILD_EVENT",
+		},
+		cli.StringFlag{
+			Name:   "build-link",
+			EnvVar: "CI_BUILD_LINK",
+		},
+		cli.StringFlag{
+			Name:   "build-target",
+			EnvVar: "CI_BUILD_TARGET",
+		},
+		cli.StringFlag{
+			Name:   "commit-sha",
+			EnvVar: "CI_COMMIT_SHA",
+		},
+		cli.StringFlag{
+			Name:   "commit-ref",
+			EnvVar: "CI_COMMIT_REF",
+		},
+		cli.StringFlag{
+			Name:   "commit-refspec",
+			EnvVar: "CI_COMMIT_REFSPEC",
+		},
+		cli.StringFlag{
+			Name:   "commit-branch",
+			EnvVar: "CI_COMMIT_BRANCH",
+		},
+		cli.StringFlag{
+			Name:   "commit-message",
+			EnvVar: "CI_COMMIT_MESSAGE",
+		},
+		cli.StringFlag{
+			Name:   "commit-author-name",
+			EnvVar: "CI_COMMIT_AUTHOR_NAME",
+		},
+		cli.StringFlag{
+			Name:   "commit-author-email",
+			EnvVar: "CI_COMMIT_AUTHOR_EMAIL",
+		},
+	},
+}
+
+func compileAction(c *cli.Context) (err error) {
+	file := c.Args().First()
+	if file == "" {
+		file = c.String("in")
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	data, err = include.New(
+		include.WithFiles(filepath.Dir(file)),
+	).Resolve(file, data)
+	if err != nil {
+		return err
+	}
+
+	metadata := metadataFromContext(c)
+	jobs, err := matrix.Expand(data, func(data []byte, axis matrix.Axis) (*backend.Config, error) {
+		metadata := metadata
+		metadata.Job.Matrix = axis
+
+		data, err := substitute(data, metadata.Environ(), axis, c.Bool("strict"))
+		if err != nil {
+			return nil, err
+		}
+		conf, err := yaml.ParseBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		return compiler.New(
+			compiler.WithPrefix(c.String("prefix")),
+			compiler.WithWorkspace(
+				c.String("workspace-base"),
+				c.String("workspace-path"),
+			),
+			compiler.WithCloneImage(c.String("clone-image")),
+			compiler.WithCacheImage(c.String("cache-image")),
+			compiler.WithVolumes(c.StringSlice("volumes")...),
+			compiler.WithNetworks(c.StringSlice("network")...),
+			compiler.WithEscalated(c.StringSlice("privileged")...),
+			compiler.WithNetrc(
+				c.String("netrc-username"),
+				c.String("netrc-password"),
+				c.String("netrc-machine"),
+			),
+			compiler.WithMetadata(metadata),
+		).Compile(conf), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// a pipeline without a matrix is written as a single configuration,
+	// which keeps the output compatible with older runtimes.
+	var out []byte
+	if len(jobs) == 1 && jobs[0].Axis == nil {
+		out, err = json.MarshalIndent(jobs[0].Config, "", "  ")
+	} else {
+		out, err = json.MarshalIndent(jobs, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.String("out") == "-" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return ioutil.WriteFile(c.String("out"), out, 0644)
+}
+
+// substitute evaluates the ${VAR} expressions in the configuration using
+// the matrix axis, the build metadata and the process environment, in
+// that order of precedence.
+func substitute(data []byte, environ map[string]string, axis matrix.Axis, strict bool) ([]byte, error) {
+	out, err := envsubst.EvalEnviron(string(data), strict, axis, environ)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// metadataFromContext returns the build metadata from the command
+// line flags and the CI_* environment variables.
+func metadataFromContext(c *cli.Context) frontend.Metadata {
+	return frontend.Metadata{
+		Repo: frontend.Repo{
+			Name:    c.String("repo-name"),
+			Link:    c.String("repo-link"),
+			Remote:  c.String("repo-remote-url"),
+			Private: c.Bool("repo-private"),
+		},
+		Curr: frontend.Build{
+			Number:  c.Int("build-number"),
+			Created: c.Int64("build-created"),
+			Started: c.Int64("build-started"),
+			Event:   c.String("build-event"),
+			Link:    c.String("build-link"),
+			Target:  c.String("build-target"),
+			Commit: frontend.Commit{
+				Sha:     c.String("commit-sha"),
+				Ref:     c.String("commit-ref"),
+				Refspec: c.String("commit-refspec"),
+				Branch:  c.String("commit-branch"),
+				Message: c.String("commit-message"),
+				Author: frontend.Author{
+					Name:  c.String("commit-author-name"),
+					Email: c.String("commit-author-email"),
+				},
+			},
+		},
+		Sys: frontend.System{
+			Name: c.String("system-name"),
+			Link: c.String("system-link"),
+			Arch: c.String("system-arch"),
+		},
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/marjoram/pipeline/pipeline"
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/backend/docker"
+	k8s "github.com/marjoram/pipeline/pipeline/backend/kubernetes"
+	"github.com/marjoram/pipeline/pipeline/backend/local"
+	"github.com/marjoram/pipeline/pipeline/cache"
+	"github.com/marjoram/pipeline/pipeline/interrupt"
+	"github.com/marjoram/pipeline/pipeline/multipart"
+)
+
+var executeCommand = cli.Command{
+	Name:   "exec",
+	Usage:  "execute the compiled file",
+	Action: executeAction,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "in",
+			Usage: "compiled file, - for stdin, or an http(s) url",
+			Value: "pipeline.json",
+		},
+		cli.StringSliceFlag{
+			Name:  "step",
+			Usage: "run only the named steps, and the services",
+		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			EnvVar: "CI_TIMEOUT",
+			Value:  time.Hour,
+		},
+		cli.StringFlag{
+			Name:   "engine",
+			Usage:  "execution engine, one of docker, kubernetes or local",
+			EnvVar: "CI_ENGINE",
+			Value:  "docker",
+		},
+		cli.BoolFlag{
+			Name:   "kubernetes",
+			Usage:  "use the kubernetes engine, same as --engine=kubernetes",
+			EnvVar: "CI_KUBERNETES",
+		},
+		cli.StringFlag{
+			Name:   "kubernetes-namespace",
+			EnvVar: "CI_KUBERNETES_NAMESPACE",
+			Value:  "default",
+		},
+		cli.StringFlag{
+			Name:   "kubernetes-endpoint",
+			EnvVar: "CI_KUBERNETES_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "kubernetes-token",
+			EnvVar: "CI_KUBERNETES_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "cache",
+			Usage:  "cache store, a directory or an s3://bucket/prefix url",
+			EnvVar: "CI_CACHE",
+		},
+	},
+}
+
+func readConfig(filename string) ([]byte, error) {
+	switch {
+	case filename == "":
+		return nil, errors.New("Filename not specified")
+	case filename == "-":
+		return ioutil.ReadAll(os.Stdin)
+	case strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://"):
+		return retrieve(filename)
+	default:
+		return ioutil.ReadFile(filename)
+	}
+}
+
+func retrieve(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot retrieve %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func executeAction(c *cli.Context) (err error) {
+	path := c.Args().First()
+	if path == "" {
+		path = c.String("in")
+	}
+
+	data, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	jobs, err := readJobs(data)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Duration("timeout"))
+	defer cancel()
+	ctx = interrupt.WithContext(ctx)
+
+	var store cache.Store
+	if rawurl := c.String("cache"); rawurl != "" {
+		store, err = cache.Open(rawurl)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = runJobs(jobs, func(config *backend.Config) error {
+		if steps := c.StringSlice("step"); len(steps) != 0 {
+			config = pipeline.FilterSteps(config, steps)
+		}
+
+		engine, err := newEngine(c)
+		if err != nil {
+			return err
+		}
+		defer engine.Close()
+
+		return pipeline.New(config,
+			pipeline.WithContext(ctx),
+			pipeline.WithLogger(defaultLogger),
+			pipeline.WithTracer(defaultTracer),
+			pipeline.WithEngine(engine),
+			pipeline.WithCache(store),
+		).Run()
+	})
+	return exitError(err)
+}
+
+// newEngine returns the execution engine selected by the command
+// line flags.
+func newEngine(c *cli.Context) (backend.Engine, error) {
+	name := c.String("engine")
+	if c.Bool("kubernetes") {
+		name = "kubernetes"
+	}
+
+	switch name {
+	case "docker":
+		return docker.NewEnv()
+	case "kubernetes":
+		config, err := kubernetesConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return k8s.New(client, c.String("kubernetes-namespace")), nil
+	case "local":
+		return local.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q", name)
+	}
+}
+
+// kubernetesConfig returns the kubernetes client configuration for the
+// endpoint and token flags, falling back to the default kubeconfig
+// loading rules.
+func kubernetesConfig(c *cli.Context) (*rest.Config, error) {
+	if endpoint := c.String("kubernetes-endpoint"); endpoint != "" {
+		return &rest.Config{
+			Host:        endpoint,
+			BearerToken: c.String("kubernetes-token"),
+		}, nil
+	}
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
+	return kubeConfig.ClientConfig()
+}
+
+// exitError returns an error that exits the command with the exit code
+// of the failed step.
+func exitError(err error) error {
+	switch t := err.(type) {
+	case nil:
+		return nil
+	case *pipeline.ExitError:
+		return cli.NewExitError(err.Error(), t.Code)
+	case *pipeline.OomError:
+		return cli.NewExitError(err.Error(), t.Code)
+	default:
+		return cli.NewExitError(err.Error(), 1)
+	}
+}
+
+var defaultLogger = pipeline.LogFunc(func(proc *backend.Step, rc multipart.Reader) error {
+	part, err := rc.NextPart()
+	if err != nil {
+		return err
+	}
+	io.Copy(os.Stderr, part)
+	return nil
+})
+
+var defaultTracer = pipeline.TraceFunc(func(state *pipeline.State) error {
+	if state.Process.Skipped {
+		fmt.Printf("proc %q skipped\n", state.Pipeline.Step.Name)
+	} else if state.Process.Exited {
+		fmt.Printf("proc %q exited with status %d\n", state.Pipeline.Step.Name, state.Process.ExitCode)
+	} else {
+		fmt.Printf("proc %q started\n", state.Pipeline.Step.Name)
+		if state.Pipeline.Step.Environment == nil {
+			state.Pipeline.Step.Environment = map[string]string{}
+		}
+		state.Pipeline.Step.Environment["CI_BUILD_STATUS"] = "success"
+		state.Pipeline.Step.Environment["CI_BUILD_FINISHED"] = strconv.FormatInt(time.Now().Unix(), 10)
+		if state.Pipeline.Error != nil {
+			state.Pipeline.Step.Environment["CI_BUILD_STATUS"] = "failure"
+		}
+	}
+	return nil
+})
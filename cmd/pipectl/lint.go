@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/linter"
+)
+
+var lintCommand = cli.Command{
+	Name:   "lint",
+	Usage:  "lint the yaml file",
+	Action: lintAction,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "in",
+			Value: "pipeline.yml",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "output format, text or json",
+			Value: "text",
+		},
+		cli.BoolFlag{
+			Name:   "trusted",
+			Usage:  "allow privileged steps and host volumes",
+			EnvVar: "CI_REPO_TRUSTED",
+		},
+	},
+}
+
+func lintAction(c *cli.Context) error {
+	file := c.Args().First()
+	if file == "" {
+		file = c.String("in")
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	diagnostics := linter.New(
+		linter.WithTrusted(c.Bool("trusted")),
+	).Lint(data)
+
+	switch c.String("format") {
+	case "json":
+		// always write a list, so an empty result is not null.
+		if diagnostics == nil {
+			diagnostics = []*linter.Diagnostic{}
+		}
+		out, err := json.MarshalIndent(diagnostics, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(out))
+	case "text":
+		for _, d := range diagnostics {
+			fmt.Fprintf(os.Stdout, "%s:%s\n", file, d)
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", c.String("format"))
+	}
+
+	// exit with a non-zero status without an error message, which
+	// would otherwise be mixed with the json output.
+	if linter.HasErrors(diagnostics) {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/marjoram/pipeline/pipeline"
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/matrix"
+)
+
+// readJobs parses the compiled intermediate representation, which is
+// either a single pipeline configuration or a list of matrix jobs. The
+// configuration of each job is validated by the pipeline parser.
+func readJobs(data []byte) ([]*matrix.Job, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		conf, err := pipeline.ParseBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*matrix.Job{{Number: 1, Config: conf}}, nil
+	}
+
+	var raw []struct {
+		Number int             `json:"number"`
+		Axis   matrix.Axis     `json:"axis"`
+		Config json.RawMessage `json:"config"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	var jobs []*matrix.Job
+	for _, job := range raw {
+		conf, err := pipeline.ParseBytes(job.Config)
+		if err != nil {
+			return nil, fmt.Errorf("job %d: %s", job.Number, err)
+		}
+		jobs = append(jobs, &matrix.Job{
+			Number: job.Number,
+			Axis:   job.Axis,
+			Config: conf,
+		})
+	}
+	return jobs, nil
+}
+
+// runJobs runs each matrix job as a separate pipeline and reports its
+// result. Every job is run even if a previous job failed, and the
+// error of the first failed job is returned.
+func runJobs(jobs []*matrix.Job, run func(*backend.Config) error) error {
+	var failed error
+	for _, job := range jobs {
+		if len(jobs) > 1 {
+			fmt.Printf("job %d/%d started: %s\n", job.Number, len(jobs), job.Axis)
+		}
+		err := run(job.Config)
+		if len(jobs) > 1 {
+			if err != nil {
+				fmt.Printf("job %d/%d failed: %s\n", job.Number, len(jobs), err)
+			} else {
+				fmt.Printf("job %d/%d passed\n", job.Number, len(jobs))
+			}
+		}
+		if err != nil && failed == nil {
+			failed = err
+		}
+	}
+	return failed
+}
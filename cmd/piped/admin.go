@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/marjoram/pipeline/pipeline/rpc"
+)
+
+// newAdminHandler returns the handler of the admin api. It serves the
+// liveness of the agent on /healthz, its readiness on /readyz, the
+// prometheus metrics on /metrics, and drains the agent on POST /drain.
+func newAdminHandler(client *rpc.Client, drain *drain) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	// the agent is ready while it reaches the rpc server and accepts
+	// pipelines.
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-drain.Draining():
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		default:
+		}
+		if !client.Connected() {
+			http.Error(w, "rpc server unreachable", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok\n")
+	})
+	mux.Handle("/metrics", metricsHandler)
+	mux.Handle("/drain", drain)
+	return mux
+}
@@ -20,4 +20,4 @@
 Package piped creates an Pipeline CRD controller that executes pipeline definition steps
 in isolated pods. Equivilant to the drone-agent package in drone/drone.
 */
-package piped
+package main
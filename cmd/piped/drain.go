@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// drain stops the agent gracefully. Once started, the workers stop
+// requesting pipelines, and the running pipelines are cancelled when the
+// grace period expires.
+type drain struct {
+	grace    time.Duration
+	once     sync.Once
+	draining chan struct{}
+	expired  chan struct{}
+}
+
+func newDrain(grace time.Duration) *drain {
+	return &drain{
+		grace:    grace,
+		draining: make(chan struct{}),
+		expired:  make(chan struct{}),
+	}
+}
+
+// Start starts draining the agent. It is safe to call more than once.
+func (d *drain) Start() {
+	d.once.Do(func() {
+		log.Printf("pipeline: draining, running pipelines are cancelled in %s", d.grace)
+		close(d.draining)
+		time.AfterFunc(d.grace, func() {
+			close(d.expired)
+		})
+	})
+}
+
+// Draining returns a channel closed when the drain starts.
+func (d *drain) Draining() <-chan struct{} {
+	return d.draining
+}
+
+// Expired returns a channel closed when the grace period of the running
+// pipelines expires.
+func (d *drain) Expired() <-chan struct{} {
+	return d.expired
+}
+
+// ServeHTTP starts draining the agent on POST /drain.
+func (d *drain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	d.Start()
+	w.WriteHeader(http.StatusAccepted)
+}
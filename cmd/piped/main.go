@@ -3,19 +3,23 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"math"
+	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/marjoram/pipeline/pipeline"
 	"github.com/marjoram/pipeline/pipeline/backend"
 	"github.com/marjoram/pipeline/pipeline/backend/docker"
+	"github.com/marjoram/pipeline/pipeline/cache"
 	"github.com/marjoram/pipeline/pipeline/interrupt"
 	"github.com/marjoram/pipeline/pipeline/multipart"
 	"github.com/marjoram/pipeline/pipeline/rpc"
@@ -39,7 +43,8 @@ func main() {
 		cli.StringFlag{
 			Name:   "endpoint",
 			EnvVar: "PIPED_ENDPOINT,PIPED_SERVER",
-			Value:  "ws://localhost:9999",
+			Usage:  "rpc server address, host:port or a grpc:// or grpcs:// url",
+			Value:  "localhost:9000",
 		},
 		cli.StringFlag{
 			Name:   "token",
@@ -60,14 +65,52 @@ func main() {
 			EnvVar: "PIPED_PLATFORM",
 			Value:  "linux/amd64",
 		},
+		cli.StringSliceFlag{
+			Name:   "label",
+			EnvVar: "PIPED_LABELS",
+			Usage:  "agent label matched by the pipeline selectors, as key=value",
+		},
 		cli.Int64Flag{
 			Name:   "upload-limit",
 			EnvVar: "PIPED_UPLOAD_LIMIT",
 			Value:  math.MaxInt32,
 		},
+		cli.IntFlag{
+			Name:   "max-procs",
+			EnvVar: "PIPED_MAX_PROCS",
+			Value:  1,
+		},
+		cli.IntFlag{
+			Name:   "max-steps",
+			EnvVar: "PIPED_MAX_STEPS",
+			Usage:  "maximum number of steps running across all pipelines",
+		},
+		cli.IntFlag{
+			Name:   "max-pipeline-steps",
+			EnvVar: "PIPED_MAX_PIPELINE_STEPS",
+			Usage:  "maximum number of steps running in a single pipeline",
+		},
+		cli.StringFlag{
+			Name:   "cache",
+			EnvVar: "PIPED_CACHE",
+			Usage:  "cache store, a directory or an s3://bucket/prefix url",
+		},
+		cli.DurationFlag{
+			Name:   "grace-period",
+			EnvVar: "PIPED_GRACE_PERIOD",
+			Usage:  "time the running pipelines may finish when draining, below the termination grace period of the pod",
+			Value:  20 * time.Second,
+		},
+		cli.StringFlag{
+			Name:   "admin-addr",
+			EnvVar: "PIPED_ADMIN_ADDR",
+			Usage:  "admin api address of /healthz, /readyz, /metrics and POST /drain",
+			Value:  "127.0.0.1:3000",
+		},
 	}
 	app.Commands = []cli.Command{
 		onceCommand,
+		serverCommand,
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -87,6 +130,13 @@ func start(c *cli.Context) error {
 			"platform": c.String("platform"),
 		},
 	}
+	for _, label := range c.StringSlice("label") {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid label %q, want key=value", label)
+		}
+		filter.Labels[kv[0]] = kv[1]
+	}
 
 	client, err := rpc.NewClient(
 		endpoint.String(),
@@ -105,36 +155,62 @@ func start(c *cli.Context) error {
 	}
 	defer client.Close()
 
-	sigterm := abool.New()
-	ctx := context.Background()
+	// the agent drains on a termination signal or on POST /drain, and
+	// the pending requests for the next pipeline are cancelled.
+	drain := newDrain(c.Duration("grace-period"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	ctx = interrupt.WithContextFunc(ctx, func() {
-		println("ctrl+c received, terminating process")
-		sigterm.Set()
+		println("ctrl+c received, draining")
+		drain.Start()
 	})
-	/*
-		for {
-			if sigterm.IsSet() {
-				return nil
-			}
-			if err := run(ctx, client, filter); err != nil {
-				return err
+	go func() {
+		<-drain.Draining()
+		cancel()
+	}()
+
+	if addr := c.String("admin-addr"); addr != "" {
+		go func() {
+			if err := http.ListenAndServe(addr, newAdminHandler(client, drain)); err != nil {
+				log.Printf("pipeline: admin api stopped: %s", err)
 			}
+		}()
+	}
+
+	// the step limiter is shared by all workers to bound the
+	// number of steps running on this node.
+	var limiter pipeline.Limiter
+	if n := c.Int("max-steps"); n > 0 {
+		limiter = pipeline.NewLimiter(n)
+	}
+	maxProcs := c.Int("max-pipeline-steps")
+
+	var store cache.Store
+	if rawurl := c.String("cache"); rawurl != "" {
+		store, err = cache.Open(rawurl)
+		if err != nil {
+			return err
 		}
-	*/
+	}
 
 	var wg sync.WaitGroup
 	parallel := c.Int("max-procs")
 	wg.Add(parallel)
 
 	for i := 0; i < parallel; i++ {
+		slot := i
 		go func() {
 			defer wg.Done()
 			for {
-				if sigterm.IsSet() {
-					return nil
+				select {
+				case <-drain.Draining():
+					return
+				default:
 				}
-				if err := run(ctx, client, filter); err != nil {
-					log.Error().Err(err).Msg("pipeline done with error")
+				if err := run(ctx, client, filter, limiter, maxProcs, store, drain.Expired(), slot); err != nil {
+					if ctx.Err() == nil {
+						log.Printf("pipeline: done with error: %s", err)
+					}
 					return
 				}
 			}
@@ -145,10 +221,13 @@ func start(c *cli.Context) error {
 	return nil
 }
 
-func run(ctx context.Context, client rpc.Peer, filter rpc.Filter) error {
+// run runs the next pipeline in the max-procs slot. The pipeline is
+// cancelled when the kill channel is closed.
+func run(ctx context.Context, client rpc.Peer, filter rpc.Filter, limiter pipeline.Limiter, maxProcs int, store cache.Store, kill <-chan struct{}, slot int) error {
 	log.Println("pipeline: request next execution")
 
 	// get the next job from the queue
+	polled := time.Now()
 	work, err := client.Next(ctx, filter)
 	if err != nil {
 		return err
@@ -157,6 +236,11 @@ func run(ctx context.Context, client rpc.Peer, filter rpc.Filter) error {
 		return nil
 	}
 	log.Printf("pipeline: received next execution: %s", work.ID)
+	queueWait.Observe(time.Since(polled).Seconds())
+
+	active := workersActive.WithLabelValues(strconv.Itoa(slot))
+	active.Set(1)
+	defer active.Set(0)
 	if os.Getenv("SUICIDE_MODE") != "" {
 		os.Exit(1)
 	}
@@ -187,6 +271,16 @@ func run(ctx context.Context, client rpc.Peer, filter rpc.Filter) error {
 		}
 	}()
 
+	go func() {
+		select {
+		case <-kill:
+			cancelled.SetTo(true)
+			log.Printf("pipeline: grace period expired, cancelling: %s", work.ID)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	go func() {
 		for {
 			select {
@@ -200,6 +294,7 @@ func run(ctx context.Context, client rpc.Peer, filter rpc.Filter) error {
 		}
 	}()
 
+	jobsStarted.Inc()
 	state := rpc.State{}
 	state.Started = time.Now().Unix()
 	err = client.Init(context.Background(), work.ID, state)
@@ -224,7 +319,10 @@ func run(ctx context.Context, client rpc.Peer, filter rpc.Filter) error {
 
 		limitedPart := io.LimitReader(part, maxLogsUpload)
 		logstream := rpc.NewLineWriter(client, work.ID, proc.Alias, secrets...)
-		io.Copy(logstream, limitedPart)
+		// the lines are streamed as they are copied, so the raw step
+		// log bytes are counted rather than the size of the log file.
+		n, _ := io.Copy(logstream, limitedPart)
+		logBytesUploaded.Add(float64(n))
 
 		file := &rpc.File{}
 		file.Mime = "application/json+logs"
@@ -267,10 +365,32 @@ func run(ctx context.Context, client rpc.Peer, filter rpc.Filter) error {
 		return nil
 	})
 
+	// the start of the running steps, by alias, to observe their
+	// duration once they exit.
+	var stepsMu sync.Mutex
+	steps := map[string]time.Time{}
+
 	defaultTracer := pipeline.TraceFunc(func(state *pipeline.State) error {
+		stepsMu.Lock()
+		switch {
+		case state.Process.Exited:
+			if started, ok := steps[state.Pipeline.Step.Alias]; ok {
+				status := "success"
+				if state.Process.ExitCode != 0 {
+					status = "failure"
+				}
+				stepDuration.WithLabelValues(status).Observe(time.Since(started).Seconds())
+			}
+			delete(steps, state.Pipeline.Step.Alias)
+		case !state.Process.Skipped:
+			steps[state.Pipeline.Step.Alias] = time.Now()
+		}
+		stepsMu.Unlock()
+
 		procState := rpc.State{
 			Proc:     state.Pipeline.Step.Alias,
 			Exited:   state.Process.Exited,
+			Skipped:  state.Process.Skipped,
 			ExitCode: state.Process.ExitCode,
 			Started:  time.Now().Unix(), // TODO do not do this
 			Finished: time.Now().Unix(),
@@ -280,7 +400,7 @@ func run(ctx context.Context, client rpc.Peer, filter rpc.Filter) error {
 				log.Printf("Pipeine: error updating pipeline step status: %s: %s: %s", work.ID, procState.Proc, uerr)
 			}
 		}()
-		if state.Process.Exited {
+		if state.Process.Exited || state.Process.Skipped {
 			return nil
 		}
 		if state.Pipeline.Step.Environment == nil {
@@ -306,6 +426,9 @@ func run(ctx context.Context, client rpc.Peer, filter rpc.Filter) error {
 		pipeline.WithLogger(defaultLogger),
 		pipeline.WithTracer(defaultTracer),
 		pipeline.WithEngine(engine),
+		pipeline.WithMaxProcs(maxProcs),
+		pipeline.WithLimiter(limiter),
+		pipeline.WithCache(store),
 	).Run()
 
 	state.Finished = time.Now().Unix()
@@ -324,6 +447,7 @@ func run(ctx context.Context, client rpc.Peer, filter rpc.Filter) error {
 			state.ExitCode = 1
 		}
 	}
+	status := jobStatus(err, cancelled.IsSet())
 
 	log.Printf("pipeline: execution complete: %s", work.ID)
 
@@ -333,6 +457,18 @@ func run(ctx context.Context, client rpc.Peer, filter rpc.Filter) error {
 	if err != nil {
 		log.Printf("Pipeine: error signaling pipeline done: %s: %s", work.ID, err)
 	}
+	jobsCompleted.WithLabelValues(status).Inc()
 
 	return nil
 }
+
+// jobStatus returns the metrics status of the pipeline result.
+func jobStatus(err error, cancelled bool) string {
+	switch {
+	case err == nil:
+		return jobSucceeded
+	case cancelled || err == pipeline.ErrCancel:
+		return jobCancelled
+	}
+	return jobFailed
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Job statuses of the completed pipelines.
+const (
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+var (
+	jobsStarted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "piped",
+		Name:      "jobs_started_total",
+		Help:      "Number of pipelines started.",
+	})
+	jobsCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "piped",
+		Name:      "jobs_completed_total",
+		Help:      "Number of pipelines completed, by status.",
+	}, []string{"status"})
+	stepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "piped",
+		Name:      "step_duration_seconds",
+		Help:      "Duration of the exited pipeline steps, by status.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"status"})
+	queueWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "piped",
+		Name:      "queue_wait_seconds",
+		Help:      "Time a worker waited on the queue for the pipeline it received.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+	logBytesUploaded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "piped",
+		Name:      "log_bytes_uploaded_total",
+		Help:      "Number of bytes read from the step log streams and uploaded.",
+	})
+	workersActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "piped",
+		Name:      "worker_active",
+		Help:      "Whether the worker of the max-procs slot is running a pipeline.",
+	}, []string{"slot"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		jobsStarted,
+		jobsCompleted,
+		stepDuration,
+		queueWait,
+		logBytesUploaded,
+		workersActive,
+	)
+	// report the statuses before the first pipeline completes.
+	for _, status := range []string{jobSucceeded, jobFailed, jobCancelled} {
+		jobsCompleted.WithLabelValues(status)
+	}
+}
+
+// metricsHandler serves the metrics in the prometheus text format.
+var metricsHandler = promhttp.Handler()
@@ -1,17 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"io/ioutil"
+	"log"
 	"math"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/compiler"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/envsubst"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/include"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/matrix"
 	"github.com/marjoram/pipeline/pipeline/interrupt"
 	"github.com/marjoram/pipeline/pipeline/rpc"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/urfave/cli"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 var onceCommand = cli.Command{
@@ -23,7 +38,8 @@ var onceCommand = cli.Command{
 		cli.StringFlag{
 			Name:   "endpoint",
 			EnvVar: "PIPED_ENDPOINT,PIPED_SERVER",
-			Value:  "ws://localhost:9999",
+			Usage:  "rpc server address, host:port or a grpc:// or grpcs:// url",
+			Value:  "localhost:9000",
 		},
 		cli.StringFlag{
 			Name:   "token",
@@ -48,6 +64,27 @@ var onceCommand = cli.Command{
 			Name:   "json",
 			EnvVar: "PIPED_JSON",
 		},
+		cli.StringFlag{
+			Name:   "yaml",
+			EnvVar: "PIPED_YAML",
+			Usage:  "pipeline yaml file, compiled instead of the json pipeline",
+		},
+		cli.BoolFlag{
+			Name:   "strict",
+			EnvVar: "PIPED_ENVSUBST_STRICT",
+			Usage:  "fail on ${VAR} references to unset variables in the yaml file",
+		},
+		cli.StringFlag{
+			Name:   "kubernetes-namespace",
+			EnvVar: "PIPED_KUBERNETES_NAMESPACE",
+			Usage:  "namespace of the configmap:// includes of the yaml file",
+			Value:  "default",
+		},
+		cli.IntFlag{
+			Name:   "max-pipeline-steps",
+			EnvVar: "PIPED_MAX_PIPELINE_STEPS",
+			Usage:  "maximum number of steps running in a single pipeline",
+		},
 	},
 }
 
@@ -81,17 +118,128 @@ func once(c *cli.Context) error {
 		println("ctrl+c received, terminating process")
 	})
 
-	return run(ctx, &onceClient{client, c.String("json")}, rpc.NoFilter)
+	var work []*rpc.Pipeline
+	if file := c.String("yaml"); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		data, err = newResolver(c, file).Resolve(file, data)
+		if err != nil {
+			return err
+		}
+		work, err = compileWork(data, c.Bool("strict"))
+		if err != nil {
+			return err
+		}
+	} else {
+		work, err = readWork([]byte(c.String("json")))
+		if err != nil {
+			return err
+		}
+	}
+
+	// each matrix job is run and reported as a separate pipeline.
+	once := &onceClient{Client: client, work: work}
+	for range work {
+		if err := run(ctx, once, rpc.NoFilter, nil, c.Int("max-pipeline-steps"), nil, nil, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readWork parses a single pipeline, or a list of compiled matrix jobs
+// that are returned as one pipeline each.
+func readWork(in []byte) ([]*rpc.Pipeline, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(in), []byte("[")) {
+		out := new(rpc.Pipeline)
+		err := json.Unmarshal(in, out)
+		return []*rpc.Pipeline{out}, err
+	}
+
+	var jobs []*matrix.Job
+	if err := json.Unmarshal(in, &jobs); err != nil {
+		return nil, err
+	}
+	return toWork(jobs), nil
+}
+
+// newResolver returns a resolver for the includes of the yaml file.
+// Includes from configmaps are only resolved when piped runs in a
+// kubernetes cluster.
+func newResolver(c *cli.Context, file string) *include.Resolver {
+	opts := []include.Option{
+		include.WithFiles(filepath.Dir(file)),
+	}
+	if config, err := rest.InClusterConfig(); err == nil {
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			log.Printf("pipeline: cannot create kubernetes client for includes: %s", err)
+		} else {
+			opts = append(opts, include.WithConfigMaps(
+				include.ConfigMapLoader(client, c.String("kubernetes-namespace")),
+			))
+		}
+	}
+	return include.New(opts...)
+}
+
+// compileWork compiles the pipeline yaml to one pipeline per matrix job.
+// The ${VAR} expressions are evaluated against the matrix axis and the
+// process environment, and the CI_* variables of the process are added
+// to every step.
+func compileWork(data []byte, strict bool) ([]*rpc.Pipeline, error) {
+	environ := map[string]string{}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[0], "CI_") {
+			environ[parts[0]] = parts[1]
+		}
+	}
+
+	jobs, err := matrix.Expand(data, func(data []byte, axis matrix.Axis) (*backend.Config, error) {
+		out, err := envsubst.EvalEnviron(string(data), strict, axis)
+		if err != nil {
+			return nil, err
+		}
+		conf, err := yaml.ParseString(out)
+		if err != nil {
+			return nil, err
+		}
+		return compiler.New(compiler.WithEnviron(environ)).Compile(conf), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toWork(jobs), nil
+}
+
+// toWork returns a pipeline for each matrix job.
+func toWork(jobs []*matrix.Job) []*rpc.Pipeline {
+	var work []*rpc.Pipeline
+	for _, job := range jobs {
+		if job.Axis != nil {
+			log.Printf("pipeline: matrix job %d: %s", job.Number, job.Axis)
+		}
+		work = append(work, &rpc.Pipeline{
+			ID:     strconv.Itoa(job.Number),
+			Config: job.Config,
+		})
+	}
+	return work
 }
 
 type onceClient struct {
 	*rpc.Client
-	json string
+	work []*rpc.Pipeline
 }
 
 func (c *onceClient) Next(ctx context.Context, filter rpc.Filter) (*rpc.Pipeline, error) {
-	in := []byte(c.json)
-	out := new(rpc.Pipeline)
-	err := json.Unmarshal(in, out)
-	return out, err
+	if len(c.work) == 0 {
+		return nil, nil
+	}
+	next := c.work[0]
+	c.work = c.work[1:]
+	return next, nil
 }
@@ -1,118 +1,194 @@
 package main
 
 import (
-		"context"
-		"crypto/tls"
-		"errors"
-		"net"
-		"net/http"
-		"net/url"
-		"os"
-		"strings"
-		"sync/errgroup"
-		
-		"google.golang.org/grpc"
-		"google.golang.org/grpc/keepalive"
-		"google.golang.org/grpc/metadata"
-
-		"golang.org/x/crypto/acme/autocert"
-		"github.com/eapache/channels"
-		"github.com/cncd/logging"
-		"github.com/marjoram/pipelinne/pipeline/rpc/proto"
-		"github.com/marjoram/pubsub"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/marjoram/pipeline/pipeline/queue"
+	"github.com/marjoram/pipeline/pipeline/rpc"
 )
 
-func main() {
-		ctx := context.Background()
-		// SharedBuffers satisfy the Buffer interface, and also permits multiple SimpleChannel
-		// instances to share a single buffer, while maintaining individual queues
-		buf := channels.NewSharedBuffer(3)
-		// Pipe connects the input channel to the output channel so they
-		// behave as if they were a single channel
-		channels.Pipe(ch1, ch2)
-		// ch1 takes the input
-		go func() {
-			for i := 0; i < 20; i++ {
-				ch1.In() <- i
-			}
-			ch1.Close()
-		}()
-	
-		// ch2 displays the output
-		// for _ = range ch2.Out() { /* */ }
-		buf.Close()
-	
-		var g errgroup.Group
-		
-		// start grpc server
-		g.Go(func() error {
-				lis, err := net.Listen("tcp", ":9000")
-				if err != nil {
-						return err
-				}
-				grpc := grpc.NewServer()
-				piped := new(pipeline.Server)
-				proto.RegisterServer(grpc, piped)
-				
-				err = grpc.Serve(lis)
-				if err != nil {
-						return err
-				}
-				return nil
-		})
-		
-		address, err := c.String("host")
+var serverCommand = cli.Command{
+	Name:   "server",
+	Usage:  "start the rpc server and the queue of the agents",
+	Action: server,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "addr",
+			EnvVar: "PIPED_SERVER_ADDR",
+			Usage:  "rpc server address",
+			Value:  ":9000",
+		},
+		cli.StringFlag{
+			Name:   "http-addr",
+			EnvVar: "PIPED_SERVER_HTTP_ADDR",
+			Usage:  "queue api address",
+			Value:  ":8000",
+		},
+		cli.StringFlag{
+			Name:   "token",
+			EnvVar: "PIPED_TOKEN,PIPED_SECRET",
+		},
+		cli.StringFlag{
+			Name:   "queue",
+			EnvVar: "PIPED_QUEUE",
+			Usage:  "bolt database file of the queue, kept in memory if empty",
+		},
+		cli.DurationFlag{
+			Name:   "lease",
+			EnvVar: "PIPED_LEASE",
+			Usage:  "time a pipeline is leased to an agent without extending it",
+			Value:  queue.DefaultLease,
+		},
+	},
+}
+
+func server(c *cli.Context) error {
+	store := queue.NewMemoryStore()
+	if path := c.String("queue"); path != "" {
+		var err error
+		store, err = queue.NewBoltStore(path)
 		if err != nil {
-				return err
+			return err
+		}
+	}
+	q, err := queue.New(store, queue.WithLease(c.Duration("lease")))
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	peer := queue.NewPeer(q, logReporter{})
+
+	lis, err := net.Listen("tcp", c.String("addr"))
+	if err != nil {
+		return err
+	}
+	var g errgroup.Group
+	g.Go(func() error {
+		return rpc.NewServer(peer, rpc.WithServerToken(c.String("token"))).Serve(lis)
+	})
+	g.Go(func() error {
+		return http.ListenAndServe(c.String("http-addr"), &queueHandler{queue: q, peer: peer})
+	})
+	return g.Wait()
+}
+
+// pushRequest is a pipeline pushed to the queue, with its scheduling
+// constraints.
+type pushRequest struct {
+	rpc.Pipeline
+	Labels       map[string]string `json:"labels"`
+	Selector     string            `json:"selector"`
+	Dependencies []string          `json:"dependencies"`
+	RunOn        []string          `json:"run_on"`
+}
+
+// unschedulable is a queued pipeline no connected agent can run.
+type unschedulable struct {
+	ID       string            `json:"id"`
+	Status   string            `json:"status"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Selector string            `json:"selector,omitempty"`
+	Created  time.Time         `json:"created"`
+}
+
+// queueHandler serves the queue api. Pipelines are pushed with POST
+// /queue, cancelled with DELETE /queue/<id>, and GET /queue returns the
+// number of queued pipelines. GET /queue/unschedulable lists the
+// pipelines no connected agent can run.
+type queueHandler struct {
+	queue *queue.Queue
+	peer  *queue.Peer
+}
+
+func (h *queueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	switch {
+	case r.URL.Path == "/queue" && r.Method == "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.queue.Info(ctx))
+
+	case r.URL.Path == "/queue" && r.Method == "POST":
+		req := new(pushRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		task := &queue.Task{
+			Labels:       req.Labels,
+			Selector:     req.Selector,
+			Dependencies: req.Dependencies,
+			RunOn:        req.RunOn,
+		}
+		if err := h.peer.Push(ctx, &req.Pipeline, task); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case r.URL.Path == "/queue/unschedulable" && r.Method == "GET":
+		pipelines := []unschedulable{}
+		for _, task := range h.queue.Unschedulable(ctx) {
+			pipelines = append(pipelines, unschedulable{
+				ID:       task.ID,
+				Status:   task.Status,
+				Labels:   task.Labels,
+				Selector: task.Selector,
+				Created:  task.Created,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pipelines)
+
+	case strings.HasPrefix(r.URL.Path, "/queue/") && r.Method == "DELETE":
+		err := h.queue.Cancel(ctx, strings.TrimPrefix(r.URL.Path, "/queue/"))
+		if err == queue.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
 		}
-		
-		// tmp dir for letsencrypt
-		dir := cacheDir()
-		os.MkdirAll(dir, 0700)
-		
-		// manager enables lets encrypt
-		manager := &autocert.Manager{
-				Prompt:		autocert.AcceptTOS,
-				HostPolicy:	autocert.HostWhitelist(address.Host),
-				Cache:		autocert.DirCache(dir),
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		
-		// and now we serve
-		g.Go(func() error {
-				return http.ListenAndServe(":http", manager.HTTPHandler(http.HandlerFunc(redirect)))
-		})
-		g.Go(func() error {
-				server := &http.Server{
-						Addr:		":https",
-						Handler:	handler,
-						TLSConfig:	&tls.Config{
-								GetCertificate:	manager.GetCertificate,
-								NextProtos:		[]string{"http/2"},
-						},
-				}
-				return serve.ListenAndServeTLS("", "")
-		})
-		
-		return g.Wait()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.NotFound(w, r)
+	}
 }
 
-func redirect(w http.ResponseWriter, req *http.Request) {
-	var serverHost string = s.Host
-	serverHost = strings.TrimPrefix(serverHost, "http://")
-	serverHost = strings.TrimPrefix(serverHost, "https://")
-	req.URL.Scheme = "https"
-	req.URL.Host = serverHost
+// logReporter logs the progress of the pipelines.
+type logReporter struct{}
 
-	w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+func (logReporter) Init(c context.Context, id string, state rpc.State) error {
+	log.Printf("pipeline: started: %s", id)
+	return nil
+}
 
-	http.Redirect(w, req, req.URL.String(), http.StatusMovedPermanently)
+func (logReporter) Done(c context.Context, id string, state rpc.State) error {
+	log.Printf("pipeline: done: %s: exit code %d", id, state.ExitCode)
+	return nil
 }
 
-func cacheDir() string {
-	const base = "golang-autocert"
-	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
-		return filepath.Join(xdg, base)
+func (logReporter) Update(c context.Context, id string, state rpc.State) error {
+	if state.Exited {
+		log.Printf("pipeline: step exited: %s: %s: exit code %d", id, state.Proc, state.ExitCode)
 	}
-	return filepath.Join(os.Getenv("HOME"), ".cache", base)
+	return nil
+}
+
+func (logReporter) Upload(c context.Context, id string, file *rpc.File) error {
+	return nil
+}
+
+func (logReporter) Log(c context.Context, id string, line *rpc.Line) error {
+	return nil
 }
-		
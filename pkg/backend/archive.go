@@ -0,0 +1,162 @@
+package backend
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveDir returns a tar archive of the paths, relative to the
+// directory. Paths that do not exist are skipped. Engines with access to
+// the step working directory on the host use it to implement Archiver.
+func ArchiveDir(dir string, paths []string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := archiveDir(tw, dir, paths)
+		if cerr := tw.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func archiveDir(tw *tar.Writer, dir string, paths []string) error {
+	for _, path := range paths {
+		root, err := joinDir(dir, path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(root); os.IsNotExist(err) {
+			continue
+		}
+		err = filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, file)
+			if err != nil {
+				return err
+			}
+			return archiveFile(tw, file, filepath.ToSlash(rel), info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func archiveFile(tw *tar.Writer, file, name string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(file); err != nil {
+			return err
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ExtractDir extracts the tar archive to the directory. Entries outside
+// of the directory are rejected.
+func ExtractDir(dir string, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path, err := joinDir(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractFile(path, mode, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// links may not point outside of the directory, which
+			// would allow later entries to be written outside of it.
+			target := header.Linkname
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(header.Name), target)
+			}
+			if _, err := joinDir(dir, target); err != nil {
+				return fmt.Errorf("link %s is outside of the working directory", header.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			os.Remove(path)
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		if header.Typeflag != tar.TypeSymlink {
+			os.Chtimes(path, header.ModTime, header.ModTime)
+		}
+	}
+}
+
+func extractFile(path string, mode os.FileMode, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	os.Remove(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// joinDir joins the relative path to the directory, and returns an error
+// if the path is outside of the directory.
+func joinDir(dir, path string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(path))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s is outside of the working directory", path)
+	}
+	return filepath.Join(dir, clean), nil
+}
@@ -1,18 +1,21 @@
 package backend
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 // Engine defines a container orchestration backend and is used
 // to create and manage container resources.
 type Engine interface {
 	// Setup the pipeline environment.
 	Setup(context.Context, *Config) error
-	// Build the docker image for said deployment
-	Build(context.Context, ...interface{}) (*BuildResult, error)
 	// Start the pipeline step.
 	Exec(context.Context, *Step) error
 	// Kill the pipeline step.
 	Kill(context.Context, *Step) error
+	// Remove the pipeline step.
+	Remove(context.Context, *Step) error
 	// Wait for the pipeline step to complete and returns
 	// the completion results.
 	Wait(context.Context, *Step) (*State, error)
@@ -23,3 +26,21 @@ type Engine interface {
 	// Close the engine
 	Close() error
 }
+
+// Prober is implemented by engines that can probe the readiness of a
+// detached pipeline step.
+type Prober interface {
+	// Probe runs the step health check once, and returns an error
+	// if the step is not ready.
+	Probe(context.Context, *Step) error
+}
+
+// Archiver is implemented by engines that can copy files in and out of
+// the working directory of a pipeline step.
+type Archiver interface {
+	// Archive returns a tar archive of the paths, relative to the step
+	// working directory. Paths that do not exist are skipped.
+	Archive(context.Context, *Step, []string) (io.ReadCloser, error)
+	// Extract the tar archive to the step working directory.
+	Extract(context.Context, *Step, io.Reader) error
+}
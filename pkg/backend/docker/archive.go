@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"path"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+// Archive returns a tar archive of the paths in the step working
+// directory. The paths are copied from a step container that is created
+// but never started, and removed once the archive is read.
+func (e *engine) Archive(ctx context.Context, proc *backend.Step, paths []string) (io.ReadCloser, error) {
+	if err := e.create(ctx, proc); err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := e.archive(ctx, tw, proc, paths)
+		if cerr := tw.Close(); err == nil {
+			err = cerr
+		}
+		e.client.ContainerRemove(context.Background(), proc.Name, removeOpts)
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+func (e *engine) archive(ctx context.Context, tw *tar.Writer, proc *backend.Step, paths []string) error {
+	for _, p := range paths {
+		src := path.Join(proc.WorkingDir, p)
+		if _, err := e.client.ContainerStatPath(ctx, proc.Name, src); err != nil {
+			if client.IsErrConnectionFailed(err) {
+				return err
+			}
+			continue
+		}
+		rc, _, err := e.client.CopyFromContainer(ctx, proc.Name, src)
+		if err != nil {
+			return err
+		}
+		// the archive entries are relative to the parent directory
+		// of the copied path.
+		err = rename(tw, tar.NewReader(rc), path.Dir(path.Clean(p)))
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extract the tar archive to the step working directory. The archive is
+// copied to a step container that is created but never started.
+func (e *engine) Extract(ctx context.Context, proc *backend.Step, r io.Reader) error {
+	if err := e.create(ctx, proc); err != nil {
+		return err
+	}
+	defer e.client.ContainerRemove(context.Background(), proc.Name, removeOpts)
+	return e.client.CopyToContainer(ctx, proc.Name, proc.WorkingDir, r, types.CopyToContainerOptions{})
+}
+
+// rename copies the tar entries to the writer, prefixed with the
+// directory.
+func rename(tw *tar.Writer, tr *tar.Reader, dir string) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if dir != "." {
+			header.Name = path.Join(dir, header.Name)
+			if header.Typeflag == tar.TypeDir {
+				header.Name += "/"
+			}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -2,8 +2,10 @@ package docker
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/network"
@@ -11,27 +13,16 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/marjoram/pipeline/pipeline/backend"
-	"github.com/GoogleCloudPlatform/skaffold/pkg/skaffold/docker"
-	"github.com/GoogleCloudPlatform/skaffold/pkg/skaffold/constants"
-	"github.com/GoogleCloudPlatform/skaffold/pkg/skaffold/build/tag"
-	"github.com/GoogleCloudPlatform/skaffold/pkg/skaffold/util"
-	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
 type engine struct {
-	client 		*client.Client
-	
-	api		docker.DockerAPIClient
-	kubeContext 	string
+	client *client.Client
 }
 
 // New returns a new Docker Engine using the given client.
-func New(cli *client.Client, kubeContext string) backend.Engine {
+func New(cli *client.Client) backend.Engine {
 	return &engine{
 		client: cli,
-		api:	api,
-		kubeContext: kubeContext == constants.DefaultMinikubeContext || kubeContext == DefaultDockerForDesktopContext,
 	}
 }
 
@@ -45,53 +36,9 @@ func NewEnv() (backend.Engine, error) {
 	return New(cli), nil
 }
 
-func (e *engine) Build(ctx context.Context, e.api) (*BuildResult, error) {
-	err := docker.RunBuild(ctx, e.api, &docker.BuildOptions{
-		ImageName:	initialTag,
-		Dockerfile:	artifact.DockerfilePath,
-		ContextDir:	artifact.Workspace,
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "running build")
-	}
-	digest, err := docker.Digest(ctx, l.api, initialTag)
-	if err != nil {
-		return nil, errors.Wrap(err, "running build and tag")
-	}
-	if digest == "" {
-			return nil, fmt.Errorf("digest not found")
-	}
-	tag, err := tagger.GenerateFullyQualifedImageName(".", &tag.TagOptions{
-		ImageName:	artifact.ImageName,
-		Digest:		digest,
-	})
-	if err != nil {
-			return nil, errors.Wrap(err, "generating tag")
-		}
-		if err := l.api.ImageTag(ctx, fmt.Sprintf("%s:latest", initialTag), tag); err != nil {
-			return nil, errors.Wrap(err, "tagging image")
-		}
-		if _, err := io.WriteString(out, fmt.Sprintf("Successfully tagged %s\n", tag)); err != nil {
-			return nil, errors.Wrap(err, "writing tag status")
-		}
-		if !*l.LocalBuild.SkipPush {
-			if err := docker.RunPush(ctx, l.api, tag, out); err != nil {
-				return nil, errors.Wrap(err, "running push")
-			}
-		}
-		res.Builds = append(res.Builds, Build{
-			ImageName: artifact.ImageName,
-			Tag:       tag,
-			Artifact:  artifact,
-		})
-	}
-
-	return res, nil
-}
-
-func (e *engine) Setup(conf *backend.Config) error {
+func (e *engine) Setup(ctx context.Context, conf *backend.Config) error {
 	for _, vol := range conf.Volumes {
-		_, err := e.client.VolumeCreate(noContext, volume.VolumesCreateBody{
+		_, err := e.client.VolumeCreate(ctx, volume.VolumesCreateBody{
 			Name:       vol.Name,
 			Driver:     vol.Driver,
 			DriverOpts: vol.DriverOpts,
@@ -102,7 +49,7 @@ func (e *engine) Setup(conf *backend.Config) error {
 		}
 	}
 	for _, network := range conf.Networks {
-		_, err := e.client.NetworkCreate(noContext, network.Name, types.NetworkCreate{
+		_, err := e.client.NetworkCreate(ctx, network.Name, types.NetworkCreate{
 			Driver:  network.Driver,
 			Options: network.DriverOpts,
 			// Labels:  defaultLabels,
@@ -114,9 +61,37 @@ func (e *engine) Setup(conf *backend.Config) error {
 	return nil
 }
 
-func (e *engine) Exec(proc *backend.Step) error {
-	ctx := context.Background()
+func (e *engine) Exec(ctx context.Context, proc *backend.Step) error {
+	if err := e.create(ctx, proc); err != nil {
+		return err
+	}
+
+	if len(proc.NetworkMode) == 0 {
+		for _, net := range proc.Networks {
+			err := e.client.NetworkConnect(ctx, net.Name, proc.Name, &network.EndpointSettings{
+				Aliases: net.Aliases,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// if proc.Network != "host" { // or bridge, overlay, none, internal, container:<name> ....
+	// 	err = e.client.NetworkConnect(ctx, proc.Network, proc.Name, &network.EndpointSettings{
+	// 		Aliases: proc.NetworkAliases,
+	// 	})
+	// 	if err != nil {
+	// 		return err
+	// 	}
+	// }
+
+	return e.client.ContainerStart(ctx, proc.Name, startOpts)
+}
 
+// create creates the step container, pulling the image if it does not
+// exist or if the step requests it.
+func (e *engine) create(ctx context.Context, proc *backend.Step) error {
 	config := toConfig(proc)
 	hostConfig := toHostConfig(proc)
 
@@ -153,44 +128,24 @@ func (e *engine) Exec(proc *backend.Step) error {
 
 		_, err = e.client.ContainerCreate(ctx, config, hostConfig, nil, proc.Name)
 	}
-	if err != nil {
-		return err
-	}
-
-	if len(proc.NetworkMode) == 0 {
-		for _, net := range proc.Networks {
-			err = e.client.NetworkConnect(ctx, net.Name, proc.Name, &network.EndpointSettings{
-				Aliases: net.Aliases,
-			})
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	// if proc.Network != "host" { // or bridge, overlay, none, internal, container:<name> ....
-	// 	err = e.client.NetworkConnect(ctx, proc.Network, proc.Name, &network.EndpointSettings{
-	// 		Aliases: proc.NetworkAliases,
-	// 	})
-	// 	if err != nil {
-	// 		return err
-	// 	}
-	// }
+	return err
+}
 
-	return e.client.ContainerStart(ctx, proc.Name, startOpts)
+func (e *engine) Kill(ctx context.Context, proc *backend.Step) error {
+	return e.client.ContainerKill(ctx, proc.Name, "9")
 }
 
-func (e *engine) Kill(proc *backend.Step) error {
-	return e.client.ContainerKill(noContext, proc.Name, "9")
+func (e *engine) Remove(ctx context.Context, proc *backend.Step) error {
+	return e.client.ContainerRemove(ctx, proc.Name, removeOpts)
 }
 
-func (e *engine) Wait(proc *backend.Step) (*backend.State, error) {
-	_, err := e.client.ContainerWait(noContext, proc.Name)
+func (e *engine) Wait(ctx context.Context, proc *backend.Step) (*backend.State, error) {
+	_, err := e.client.ContainerWait(ctx, proc.Name)
 	if err != nil {
-		// todo
+		return nil, err
 	}
 
-	info, err := e.client.ContainerInspect(noContext, proc.Name)
+	info, err := e.client.ContainerInspect(ctx, proc.Name)
 	if err != nil {
 		return nil, err
 	}
@@ -205,8 +160,72 @@ func (e *engine) Wait(proc *backend.Step) (*backend.State, error) {
 	}, nil
 }
 
-func (e *engine) Tail(proc *backend.Step) (io.ReadCloser, error) {
-	logs, err := e.client.ContainerLogs(noContext, proc.Name, logsOpts)
+// Probe runs the step health check once. Exec probes run in the step
+// container, while tcp and http probes connect to the container address
+// on the step network, which must be reachable from the engine host.
+func (e *engine) Probe(ctx context.Context, proc *backend.Step) error {
+	check := proc.HealthCheck
+	if len(check.Exec) != 0 {
+		return e.probeExec(ctx, proc.Name, check.Exec)
+	}
+
+	info, err := e.client.ContainerInspect(ctx, proc.Name)
+	if err != nil {
+		return err
+	}
+	if !info.State.Running {
+		return fmt.Errorf("container %s is not running", proc.Name)
+	}
+	host := "localhost"
+	if proc.NetworkMode != "host" && info.NetworkSettings != nil {
+		host = ""
+		for _, conn := range proc.Networks {
+			if endpoint, ok := info.NetworkSettings.Networks[conn.Name]; ok && endpoint.IPAddress != "" {
+				host = endpoint.IPAddress
+				break
+			}
+		}
+		if host == "" {
+			host = info.NetworkSettings.IPAddress
+		}
+	}
+	if host == "" {
+		return fmt.Errorf("container %s does not have an address", proc.Name)
+	}
+	return backend.ProbeHost(ctx, check, host)
+}
+
+// probeExec runs the command in the container, and waits for it to
+// exit with code 0.
+func (e *engine) probeExec(ctx context.Context, name string, cmd []string) error {
+	exec, err := e.client.ContainerExecCreate(ctx, name, types.ExecConfig{Cmd: cmd})
+	if err != nil {
+		return err
+	}
+	if err := e.client.ContainerExecStart(ctx, exec.ID, types.ExecStartCheck{Detach: true}); err != nil {
+		return err
+	}
+	for {
+		info, err := e.client.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return err
+		}
+		if !info.Running {
+			if info.ExitCode != 0 {
+				return fmt.Errorf("health check exited with code %d", info.ExitCode)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(execPollInterval):
+		}
+	}
+}
+
+func (e *engine) Tail(ctx context.Context, proc *backend.Step) (io.ReadCloser, error) {
+	logs, err := e.client.ContainerLogs(ctx, proc.Name, logsOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -221,18 +240,18 @@ func (e *engine) Tail(proc *backend.Step) (io.ReadCloser, error) {
 	return rc, nil
 }
 
-func (e *engine) Destroy(conf *backend.Config) error {
+func (e *engine) Destroy(ctx context.Context, conf *backend.Config) error {
 	for _, stage := range conf.Stages {
 		for _, step := range stage.Steps {
-			e.client.ContainerKill(noContext, step.Name, "9")
-			e.client.ContainerRemove(noContext, step.Name, removeOpts)
+			e.client.ContainerKill(ctx, step.Name, "9")
+			e.client.ContainerRemove(ctx, step.Name, removeOpts)
 		}
 	}
 	for _, volume := range conf.Volumes {
-		e.client.VolumeRemove(noContext, volume.Name, true)
+		e.client.VolumeRemove(ctx, volume.Name, true)
 	}
 	for _, network := range conf.Networks {
-		e.client.NetworkRemove(noContext, network.Name)
+		e.client.NetworkRemove(ctx, network.Name)
 	}
 	return nil
 }
@@ -241,9 +260,10 @@ func (e *engine) Close() error {
 	return e.client.Close()
 }
 
-var (
-	noContext = context.Background()
+// interval between exec probe status checks.
+const execPollInterval = 100 * time.Millisecond
 
+var (
 	startOpts = types.ContainerStartOptions{}
 
 	removeOpts = types.ContainerRemoveOptions{
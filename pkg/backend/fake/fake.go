@@ -0,0 +1,359 @@
+// Package fake provides a scriptable, in-memory backend.Engine for
+// testing the pipeline runtime without a container runtime.
+package fake
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+// Exit code reported for a killed step.
+const killedExitCode = 137
+
+// Step scripts the behaviour of a single attempt of a pipeline step.
+type Step struct {
+	// Exit code reported by Wait
+	ExitCode int
+	// OOM kill reported by Wait
+	OOMKilled bool
+	// Log output returned by Tail
+	Logs string
+	// Time the step runs before it exits
+	Delay time.Duration
+	// Time Exec blocks before the step starts, such as a slow image
+	// pull
+	ExecDelay time.Duration
+	// Error returned by Exec
+	ExecErr error
+	// Error returned by Remove
+	RemoveErr error
+	// Error returned by Wait
+	WaitErr error
+	// Number of failing probes before the step is ready, or -1 if
+	// the step is never ready
+	Unready int
+}
+
+// Call records a call to the engine.
+type Call struct {
+	// Engine method name
+	Method string
+	// Step name, empty for pipeline level calls
+	Step string
+}
+
+// String returns the call in method:step format.
+func (c Call) String() string {
+	if c.Step == "" {
+		return c.Method
+	}
+	return c.Method + ":" + c.Step
+}
+
+// Engine is a scriptable backend.Engine.
+type Engine struct {
+	// Error returned by Setup
+	SetupErr error
+	// Workspace files archived and extracted by cache steps, indexed
+	// by path
+	Files map[string]string
+
+	mu      sync.Mutex
+	scripts map[string][]Step
+	attempt map[string]int
+	procs   map[string]*proc
+	calls   []Call
+}
+
+type proc struct {
+	script Step
+	probes int
+	timer  *time.Timer
+	done   chan struct{}
+	once   sync.Once
+	killed bool
+}
+
+func (p *proc) exit(killed bool) {
+	p.once.Do(func() {
+		p.killed = killed
+		close(p.done)
+	})
+}
+
+// New returns a new fake Engine. Steps that are not scripted exit
+// immediately with exit code 0.
+func New() *Engine {
+	return &Engine{
+		scripts: map[string][]Step{},
+		attempt: map[string]int{},
+		procs:   map[string]*proc{},
+		Files:   map[string]string{},
+	}
+}
+
+// Script configures the behaviour of the named step. Each script is
+// used for one attempt of the step, and the last script is repeated
+// for any further attempts.
+func (e *Engine) Script(name string, steps ...Step) *Engine {
+	e.mu.Lock()
+	e.scripts[name] = steps
+	e.mu.Unlock()
+	return e
+}
+
+// Calls returns the engine calls in the order they were made.
+func (e *Engine) Calls() []Call {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Call(nil), e.calls...)
+}
+
+// Called returns the names of the steps passed to the method, in the
+// order the calls were made.
+func (e *Engine) Called(method string) []string {
+	var names []string
+	for _, call := range e.Calls() {
+		if call.Method == method {
+			names = append(names, call.Step)
+		}
+	}
+	return names
+}
+
+func (e *Engine) record(method, step string) {
+	e.calls = append(e.calls, Call{Method: method, Step: step})
+}
+
+// Setup the pipeline environment.
+func (e *Engine) Setup(ctx context.Context, conf *backend.Config) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("setup", "")
+	return e.SetupErr
+}
+
+// Exec starts the pipeline step.
+func (e *Engine) Exec(ctx context.Context, step *backend.Step) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("exec", step.Name)
+
+	script := e.next(step.Name)
+	if script.ExecDelay != 0 {
+		e.mu.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-time.After(script.ExecDelay):
+		}
+		e.mu.Lock()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if script.ExecErr != nil {
+		return script.ExecErr
+	}
+	if _, ok := e.procs[step.Name]; ok {
+		return fmt.Errorf("fake: step %s already exists", step.Name)
+	}
+	p := &proc{
+		script: script,
+		done:   make(chan struct{}),
+	}
+	p.timer = time.AfterFunc(script.Delay, func() {
+		p.exit(false)
+	})
+	e.procs[step.Name] = p
+	return nil
+}
+
+// Kill the pipeline step.
+func (e *Engine) Kill(ctx context.Context, step *backend.Step) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("kill", step.Name)
+
+	if p, ok := e.procs[step.Name]; ok {
+		p.timer.Stop()
+		p.exit(true)
+	}
+	return nil
+}
+
+// Remove the pipeline step.
+func (e *Engine) Remove(ctx context.Context, step *backend.Step) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("remove", step.Name)
+
+	if p, ok := e.procs[step.Name]; ok && p.script.RemoveErr != nil {
+		return p.script.RemoveErr
+	}
+	delete(e.procs, step.Name)
+	return nil
+}
+
+// Wait for the pipeline step to complete and returns
+// the completion results.
+func (e *Engine) Wait(ctx context.Context, step *backend.Step) (*backend.State, error) {
+	e.mu.Lock()
+	e.record("wait", step.Name)
+	p, ok := e.procs[step.Name]
+	e.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("fake: step %s does not exist", step.Name)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.done:
+	}
+
+	if p.script.WaitErr != nil {
+		return nil, p.script.WaitErr
+	}
+	if p.killed {
+		return &backend.State{
+			Exited:   true,
+			ExitCode: killedExitCode,
+		}, nil
+	}
+	return &backend.State{
+		Exited:    true,
+		ExitCode:  p.script.ExitCode,
+		OOMKilled: p.script.OOMKilled,
+	}, nil
+}
+
+// Probe fails until the step has been probed the scripted number of
+// times.
+func (e *Engine) Probe(ctx context.Context, step *backend.Step) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("probe", step.Name)
+
+	p, ok := e.procs[step.Name]
+	if !ok {
+		return fmt.Errorf("fake: step %s does not exist", step.Name)
+	}
+	p.probes++
+	if p.script.Unready < 0 || p.probes <= p.script.Unready {
+		return fmt.Errorf("fake: step %s is not ready", step.Name)
+	}
+	return nil
+}
+
+// Archive returns a tar archive of the workspace files in the paths.
+func (e *Engine) Archive(ctx context.Context, step *backend.Step, paths []string) (io.ReadCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("archive", step.Name)
+
+	var names []string
+	for name := range e.Files {
+		for _, path := range paths {
+			if name == path || strings.HasPrefix(name, path+"/") {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, name := range names {
+		data := e.Files[name]
+		tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(data)),
+		})
+		io.WriteString(tw, data)
+	}
+	tw.Close()
+	return ioutil.NopCloser(buf), nil
+}
+
+// Extract adds the files of the tar archive to the workspace files.
+func (e *Engine) Extract(ctx context.Context, step *backend.Step, r io.Reader) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("extract", step.Name)
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		e.Files[header.Name] = string(data)
+	}
+}
+
+// Tail the pipeline step logs.
+func (e *Engine) Tail(ctx context.Context, step *backend.Step) (io.ReadCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("tail", step.Name)
+
+	p, ok := e.procs[step.Name]
+	if !ok {
+		return nil, fmt.Errorf("fake: step %s does not exist", step.Name)
+	}
+	return ioutil.NopCloser(strings.NewReader(p.script.Logs)), nil
+}
+
+// Destroy the pipeline environment.
+func (e *Engine) Destroy(ctx context.Context, conf *backend.Config) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record("destroy", "")
+
+	for name, p := range e.procs {
+		p.timer.Stop()
+		p.exit(true)
+		delete(e.procs, name)
+	}
+	return nil
+}
+
+// Close the engine.
+func (e *Engine) Close() error {
+	return nil
+}
+
+// next returns the script for the next attempt of the named step.
+func (e *Engine) next(name string) Step {
+	scripts := e.scripts[name]
+	if len(scripts) == 0 {
+		return Step{}
+	}
+	i := e.attempt[name]
+	e.attempt[name]++
+	if i >= len(scripts) {
+		i = len(scripts) - 1
+	}
+	return scripts[i]
+}
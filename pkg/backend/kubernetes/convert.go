@@ -0,0 +1,355 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// label applied to every resource created for a build.
+	buildLabel = "pipeline.cncd.io/build"
+	// label applied to the pod of a step.
+	stepLabel = "pipeline.cncd.io/step"
+	// annotation listing the network aliases of a service pod.
+	aliasesAnnotation = "pipeline.cncd.io/aliases"
+
+	// name of the step container in the pod.
+	stepContainer = "step"
+
+	// default size of a shared volume claim.
+	defaultVolumeSize = "1Gi"
+)
+
+// returns a pod for the pipeline step.
+func toPod(id string, proc *backend.Step) *v1.Pod {
+	name := toName(id, proc.Name)
+
+	container := v1.Container{
+		Name:            stepContainer,
+		Image:           proc.Image,
+		ImagePullPolicy: v1.PullIfNotPresent,
+		WorkingDir:      proc.WorkingDir,
+		Command:         proc.Entrypoint,
+		Args:            proc.Command,
+		Env:             toEnv(proc.Environment),
+		Resources:       toResources(proc),
+		ReadinessProbe:  toProbe(proc.HealthCheck),
+	}
+	if proc.Pull {
+		container.ImagePullPolicy = v1.PullAlways
+	}
+	if proc.Privileged {
+		container.SecurityContext = &v1.SecurityContext{
+			Privileged: &proc.Privileged,
+		}
+	}
+
+	spec := v1.PodSpec{
+		RestartPolicy: v1.RestartPolicyNever,
+		HostAliases:   toHostAliases(proc.ExtraHosts),
+	}
+	if hasAuth(proc) {
+		spec.ImagePullSecrets = []v1.LocalObjectReference{
+			{Name: name},
+		}
+	}
+	if len(proc.DNS) != 0 || len(proc.DNSSearch) != 0 {
+		spec.DNSConfig = &v1.PodDNSConfig{
+			Nameservers: proc.DNS,
+			Searches:    proc.DNSSearch,
+		}
+	}
+	for i, path := range proc.Volumes {
+		volume, mount, ok := toVolume(id, i, path)
+		if !ok {
+			continue
+		}
+		spec.Volumes = append(spec.Volumes, volume)
+		container.VolumeMounts = append(container.VolumeMounts, mount)
+	}
+	for i, path := range proc.Tmpfs {
+		volume, mount := toTmpfs(i, path)
+		spec.Volumes = append(spec.Volumes, volume)
+		container.VolumeMounts = append(container.VolumeMounts, mount)
+	}
+	spec.Containers = []v1.Container{container}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: toLabels(id, proc),
+		},
+		Spec: spec,
+	}
+	if aliases := toAliases(proc); len(aliases) != 0 {
+		pod.Annotations = map[string]string{
+			aliasesAnnotation: strings.Join(aliases, ","),
+		}
+	}
+	return pod
+}
+
+// helper function that returns the network aliases of a service step.
+func toAliases(proc *backend.Step) []string {
+	if !proc.Detached {
+		return nil
+	}
+	var aliases []string
+	seen := map[string]bool{}
+	for _, conn := range proc.Networks {
+		for _, alias := range conn.Aliases {
+			if !seen[alias] {
+				seen[alias] = true
+				aliases = append(aliases, alias)
+			}
+		}
+	}
+	return aliases
+}
+
+// returns the container readiness probe for the step health check.
+func toProbe(check *backend.HealthCheck) *v1.Probe {
+	if check == nil {
+		return nil
+	}
+	probe := &v1.Probe{
+		PeriodSeconds: toSeconds(check.Interval),
+	}
+	switch {
+	case check.TCP != 0:
+		probe.TCPSocket = &v1.TCPSocketAction{
+			Port: intstr.FromInt(check.TCP),
+		}
+	case len(check.Exec) != 0:
+		probe.Exec = &v1.ExecAction{
+			Command: check.Exec,
+		}
+	case check.HTTP != nil:
+		probe.HTTPGet = &v1.HTTPGetAction{
+			Port: intstr.FromInt(check.HTTP.Port),
+			Path: check.HTTP.Path,
+		}
+	}
+	return probe
+}
+
+// helper function that converts a duration to whole seconds, rounding
+// up. A zero duration uses the kubernetes default.
+func toSeconds(d time.Duration) int32 {
+	if d <= 0 {
+		return 0
+	}
+	return int32((d + time.Second - 1) / time.Second)
+}
+
+// returns a persistent volume claim for the pipeline volume. Claims
+// default to the ReadWriteOnce access mode, which requires the step pods
+// sharing the volume to run on a single node. The access_mode driver
+// option sets another mode, such as ReadWriteMany.
+func toClaim(id string, vol *backend.Volume) *v1.PersistentVolumeClaim {
+	size := vol.DriverOpts["size"]
+	if size == "" {
+		size = defaultVolumeSize
+	}
+	mode := v1.ReadWriteOnce
+	if m := vol.DriverOpts["access_mode"]; m != "" {
+		mode = v1.PersistentVolumeAccessMode(m)
+	}
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: toName(id, vol.Name),
+			Labels: map[string]string{
+				buildLabel: id,
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{mode},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: resource.MustParse(size),
+				},
+			},
+		},
+	}
+	if class, ok := vol.DriverOpts["storage_class"]; ok {
+		claim.Spec.StorageClassName = &class
+	}
+	return claim
+}
+
+// returns a registry secret holding the pipeline step credentials.
+func toSecret(id string, proc *backend.Step) *v1.Secret {
+	auth := proc.AuthConfig
+	config := map[string]interface{}{
+		"auths": map[string]interface{}{
+			toRegistry(proc.Image): map[string]string{
+				"username": auth.Username,
+				"password": auth.Password,
+				"email":    auth.Email,
+				"auth":     base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password)),
+			},
+		},
+	}
+	data, _ := json.Marshal(config)
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: toName(id, proc.Name),
+			Labels: map[string]string{
+				buildLabel: id,
+			},
+		},
+		Type: v1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			v1.DockerConfigJsonKey: data,
+		},
+	}
+}
+
+// helper function that converts a resource name to a valid dns label,
+// prefixed with the build identifier.
+func toName(id, name string) string {
+	if id != "" {
+		name = id + "-" + name
+	}
+	name = strings.ToLower(name)
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '-'
+		}
+	}, name)
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.Trim(name, "-")
+}
+
+// helper function that returns the pod labels for the pipeline step.
+func toLabels(id string, proc *backend.Step) map[string]string {
+	labels := map[string]string{}
+	for k, v := range proc.Labels {
+		labels[k] = v
+	}
+	labels[buildLabel] = id
+	labels[stepLabel] = toName(id, proc.Name)
+	return labels
+}
+
+// helper function that converts a key value map of environment variables
+// to a sorted slice of container environment variables.
+func toEnv(env map[string]string) []v1.EnvVar {
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var vars []v1.EnvVar
+	for _, k := range keys {
+		vars = append(vars, v1.EnvVar{Name: k, Value: env[k]})
+	}
+	return vars
+}
+
+// helper function that converts the pipeline step limits to container
+// resource limits. The cpu quota is expressed in microseconds per 100ms
+// period, which converts to millicores by dividing by 100.
+func toResources(proc *backend.Step) v1.ResourceRequirements {
+	limits := v1.ResourceList{}
+	if proc.MemLimit != 0 {
+		limits[v1.ResourceMemory] = *resource.NewQuantity(proc.MemLimit, resource.BinarySI)
+	}
+	if proc.CPUQuota != 0 {
+		limits[v1.ResourceCPU] = *resource.NewMilliQuantity(proc.CPUQuota/100, resource.DecimalSI)
+	}
+	if len(limits) == 0 {
+		return v1.ResourceRequirements{}
+	}
+	return v1.ResourceRequirements{Limits: limits}
+}
+
+// helper function that converts a volume path in source:target format to
+// a pod volume and mount. A source starting with a slash is mounted from
+// the host, otherwise it references a pipeline volume claim.
+func toVolume(id string, index int, path string) (v1.Volume, v1.VolumeMount, bool) {
+	parts := strings.Split(path, ":")
+	if len(parts) < 2 {
+		return v1.Volume{}, v1.VolumeMount{}, false
+	}
+	volume := v1.Volume{}
+	mount := v1.VolumeMount{
+		MountPath: parts[1],
+		ReadOnly:  len(parts) > 2 && parts[2] == "ro",
+	}
+	if strings.HasPrefix(parts[0], "/") {
+		volume.Name = "host-" + strconv.Itoa(index)
+		volume.HostPath = &v1.HostPathVolumeSource{Path: parts[0]}
+	} else {
+		volume.Name = toName("", parts[0])
+		volume.PersistentVolumeClaim = &v1.PersistentVolumeClaimVolumeSource{
+			ClaimName: toName(id, parts[0]),
+		}
+	}
+	mount.Name = volume.Name
+	return volume, mount, true
+}
+
+// helper function that converts a tmpfs path in path:options format to
+// a memory backed pod volume and mount.
+func toTmpfs(index int, path string) (v1.Volume, v1.VolumeMount) {
+	parts := strings.SplitN(path, ":", 2)
+	name := "tmpfs-" + strconv.Itoa(index)
+	volume := v1.Volume{
+		Name: name,
+		VolumeSource: v1.VolumeSource{
+			EmptyDir: &v1.EmptyDirVolumeSource{
+				Medium: v1.StorageMediumMemory,
+			},
+		},
+	}
+	mount := v1.VolumeMount{
+		Name:      name,
+		MountPath: parts[0],
+	}
+	return volume, mount
+}
+
+// helper function that converts a slice of extra hosts in host:ip format
+// to pod host aliases.
+func toHostAliases(hosts []string) []v1.HostAlias {
+	var aliases []v1.HostAlias
+	for _, host := range hosts {
+		parts := strings.SplitN(host, ":", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		aliases = append(aliases, v1.HostAlias{
+			IP:        parts[1],
+			Hostnames: []string{parts[0]},
+		})
+	}
+	return aliases
+}
+
+// helper function that returns the registry hostname of the image,
+// defaulting to docker hub.
+func toRegistry(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && strings.ContainsAny(parts[0], ".:") {
+		return parts[0]
+	}
+	return "https://index.docker.io/v1/"
+}
@@ -1,93 +1,278 @@
 package kubernetes
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net/http"
-	"net/http/httputil"
-	"os"
+	"sort"
+	"strings"
 	"time"
 
-	"path"
-
-	. "github.com/bannzaicloud/banzai-types/components"
 	"github.com/marjoram/pipeline/pipeline/backend"
-	log "github.com/sirupsen/logrus"
-	validator "gopkg.in/go-playground/validator.v8"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
 )
 
+// interval between pod status checks. It is a variable so that tests
+// can shorten it.
+var pollInterval = time.Second
+
+// time a service pod may take to be assigned an ip before the step
+// fails. It is a variable so that tests can shorten it.
+var scheduleTimeout = 5 * time.Minute
+
+// exit code reported for a step killed before its container exited.
+const killedExitCode = 137
+
+// stream opens the log stream of a request. It is a variable so that
+// tests can replace it, since the fake clientset does not serve logs.
+var stream = func(r *restclient.Request) (io.ReadCloser, error) {
+	return r.Stream()
+}
+
 type engine struct {
-	client *client.Client
+	client    kubernetes.Interface
+	namespace string
+	id        string
 }
 
-// New returns a new Kubernetes Engine.
-func New(k8sCli *client.Client) backend.Engine {
+// New returns a new Kubernetes Engine using the given client. Pipeline
+// steps run as pods in the given namespace, labelled and named after a
+// unique build identifier.
+func New(client kubernetes.Interface, namespace string) backend.Engine {
 	return &engine{
-		client: k8sCli,
+		client:    client,
+		namespace: namespace,
+		id:        newID(),
 	}
 }
 
 // Setup the pipeline environment.
-func (e *engine) Setup(*backend.Config) error {
-	// POST /api/v1/namespaces
+func (e *engine) Setup(ctx context.Context, conf *backend.Config) error {
+	for _, vol := range conf.Volumes {
+		_, err := e.client.CoreV1().PersistentVolumeClaims(e.namespace).Create(toClaim(e.id, vol))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // Start the pipeline step.
-func (e *engine) Exec(*backend.Step) error {
-	ctx := context.Background()
-	// Start agent with a Step as the cmd
-	return e.client.CreatePod(ctx, agent.Name, *backend.Step)
+func (e *engine) Exec(ctx context.Context, proc *backend.Step) error {
+	if hasAuth(proc) {
+		// the secret of a retried step already exists.
+		_, err := e.client.CoreV1().Secrets(e.namespace).Create(toSecret(e.id, proc))
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+
+	// services are reached through their network aliases, which
+	// resolve to the pods of the running services of the build.
+	hosts, err := e.hostAliases()
+	if err != nil {
+		return err
+	}
+	pod := toPod(e.id, proc)
+	pod.Spec.HostAliases = append(pod.Spec.HostAliases, hosts...)
+
+	pods := e.client.CoreV1().Pods(e.namespace)
+	if _, err := pods.Create(pod); err != nil {
+		return err
+	}
+	if len(toAliases(proc)) == 0 {
+		return nil
+	}
+
+	// the aliases of the service resolve once its pod ip is assigned.
+	// A pod that cannot be scheduled or started fails the step rather
+	// than blocking it until the pipeline times out.
+	waitCtx, cancel := context.WithTimeout(ctx, scheduleTimeout)
+	defer cancel()
+	err = wait.PollImmediateUntil(pollInterval, func() (bool, error) {
+		pod, err := pods.Get(pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if err := unscheduled(pod); err != nil {
+			return false, err
+		}
+		return pod.Status.PodIP != "", nil
+	}, waitCtx.Done())
+	if err == wait.ErrWaitTimeout {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("pod %s was not assigned an ip within %s", pod.Name, scheduleTimeout)
+	}
+	return err
+}
+
+// Kill the pipeline step. The pod is kept until the step is removed,
+// so that Wait returns the final state of the killed container.
+func (e *engine) Kill(ctx context.Context, proc *backend.Step) error {
+	pods := e.client.CoreV1().Pods(e.namespace)
+	pod, err := pods.Get(toName(e.id, proc.Name), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if isComplete(pod) {
+		return nil
+	}
+	// the kubelet kills the containers of a pod exceeding its active
+	// deadline.
+	_, err = pods.Patch(pod.Name, types.StrategicMergePatchType, []byte(`{"spec":{"activeDeadlineSeconds":1}}`))
+	return err
+}
+
+// Remove the pipeline step.
+func (e *engine) Remove(ctx context.Context, proc *backend.Step) error {
+	name := toName(e.id, proc.Name)
+	err := e.client.CoreV1().Pods(e.namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil {
+		return err
+	}
+	if hasAuth(proc) {
+		err := e.client.CoreV1().Secrets(e.namespace).Delete(name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return wait.PollImmediateUntil(pollInterval, func() (bool, error) {
+		_, err := e.client.CoreV1().Pods(e.namespace).Get(name, metav1.GetOptions{})
+		return err != nil, nil
+	}, ctx.Done())
 }
 
 // Wait for the pipeline step to complete and returns
 // the completion results.
-func (e *engine) Wait(*backend.Step) (*backend.State, error) {
-	ctx := context.Background()
-	// Start agent and sleep
-	agent, err := e.client.CreateAndWaitPod()
-	if err != nil {
-		return nil, err
+func (e *engine) Wait(ctx context.Context, proc *backend.Step) (*backend.State, error) {
+	var state *backend.State
+	err := wait.PollImmediateUntil(pollInterval, func() (bool, error) {
+		pod, err := e.client.CoreV1().Pods(e.namespace).Get(toName(e.id, proc.Name), metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		terminated := containerState(pod).Terminated
+		if terminated == nil {
+			// the pod was killed before its container started.
+			if isComplete(pod) {
+				state = &backend.State{
+					Exited:   true,
+					ExitCode: killedExitCode,
+				}
+				return true, nil
+			}
+			return false, nil
+		}
+		state = &backend.State{
+			Exited:    true,
+			ExitCode:  int(terminated.ExitCode),
+			OOMKilled: terminated.Reason == "OOMKilled",
+		}
+		return true, nil
+	}, ctx.Done())
+	if err == wait.ErrWaitTimeout {
+		return nil, ctx.Err()
 	}
-	
-	info, err := e.client.PodInspect(ctx, agent.Name)
+	return state, err
+}
+
+// Probe returns an error if the step container is not ready. The
+// health check runs as the container readiness probe.
+func (e *engine) Probe(ctx context.Context, proc *backend.Step) error {
+	pod, err := e.client.CoreV1().Pods(e.namespace).Get(toName(e.id, proc.Name), metav1.GetOptions{})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if info.State.Running {
-		// todo
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != stepContainer {
+			continue
+		}
+		switch {
+		case status.State.Terminated != nil:
+			return fmt.Errorf("container exited with code %d", status.State.Terminated.ExitCode)
+		case status.Ready:
+			return nil
+		}
 	}
-	
-	return &backend.State{
-		Exited:    true,
-		ExitCode:  info.State.ExitCode,
-		OOMKilled: info.State.OOMKilled,
-	}, nil
+	return fmt.Errorf("pod %s is not ready", pod.Name)
 }
 
 // Tail the pipeline step logs.
-func (e *engine) Tail(*backend.Step) (io.ReadCloser, error) {
-	// GET /api/v1/namespaces/{namespace}/pods/{name}/log
-	return nil, nil
+func (e *engine) Tail(ctx context.Context, proc *backend.Step) (io.ReadCloser, error) {
+	name := toName(e.id, proc.Name)
+
+	// logs cannot be requested until the container is started.
+	err := wait.PollImmediateUntil(pollInterval, func() (bool, error) {
+		pod, err := e.client.CoreV1().Pods(e.namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		state := containerState(pod)
+		if w := state.Waiting; w != nil && (w.Reason == "ErrImagePull" || w.Reason == "ImagePullBackOff") {
+			return false, fmt.Errorf("%s: %s", w.Reason, w.Message)
+		}
+		return state.Running != nil || state.Terminated != nil, nil
+	}, ctx.Done())
+	if err == wait.ErrWaitTimeout {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, err
+	}
+
+	req := e.client.CoreV1().Pods(e.namespace).GetLogs(name, &v1.PodLogOptions{
+		Container: stepContainer,
+		Follow:    true,
+	})
+	rc, err := stream(req.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return rc, nil
 }
 
 // Destroy the pipeline environment.
-func (e *engine) Destroy(*backend.Config) error {
-	for _, stage := range conf.Stages {
-		for _, step := range stage.Steps {
-			e.client.ContainerKill(noContext, step.Name, "9")
-			e.client.ContainerRemove(noContext, step.Name, removeOpts)
-		}
+func (e *engine) Destroy(ctx context.Context, conf *backend.Config) error {
+	opts := metav1.ListOptions{
+		LabelSelector: buildLabel + "=" + e.id,
+	}
+	core := e.client.CoreV1()
+
+	var grace int64
+	pods, err := core.Pods(e.namespace).List(opts)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		core.Pods(e.namespace).Delete(pod.Name, &metav1.DeleteOptions{
+			GracePeriodSeconds: &grace,
+		})
 	}
-	for _, volume := range conf.Volumes {
-		e.client.VolumeRemove(noContext, volume.Name, true)
+
+	secrets, err := core.Secrets(e.namespace).List(opts)
+	if err != nil {
+		return err
 	}
-	for _, network := range conf.Networks {
-		e.client.NetworkRemove(noContext, network.Name)
+	for _, secret := range secrets.Items {
+		core.Secrets(e.namespace).Delete(secret.Name, &metav1.DeleteOptions{})
+	}
+
+	claims, err := core.PersistentVolumeClaims(e.namespace).List(opts)
+	if err != nil {
+		return err
+	}
+	for _, claim := range claims.Items {
+		core.PersistentVolumeClaims(e.namespace).Delete(claim.Name, &metav1.DeleteOptions{})
 	}
 	return nil
 }
@@ -95,3 +280,71 @@ func (e *engine) Destroy(*backend.Config) error {
 func (e *engine) Close() error {
 	return nil
 }
+
+// hostAliases returns the host aliases resolving the network aliases of
+// the running services of the build to their pod ip.
+func (e *engine) hostAliases() ([]v1.HostAlias, error) {
+	pods, err := e.client.CoreV1().Pods(e.namespace).List(metav1.ListOptions{
+		LabelSelector: buildLabel + "=" + e.id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var hosts []v1.HostAlias
+	for _, pod := range pods.Items {
+		aliases := pod.Annotations[aliasesAnnotation]
+		if aliases == "" || pod.Status.PodIP == "" || isComplete(&pod) {
+			continue
+		}
+		hosts = append(hosts, v1.HostAlias{
+			IP:        pod.Status.PodIP,
+			Hostnames: strings.Split(aliases, ","),
+		})
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].IP < hosts[j].IP
+	})
+	return hosts, nil
+}
+
+// helper function that returns true if the pod containers exited.
+func isComplete(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+// helper function that returns an error if the pod failed, or cannot be
+// scheduled on a node of the cluster.
+func unscheduled(pod *v1.Pod) error {
+	if pod.Status.Phase == v1.PodFailed {
+		return fmt.Errorf("pod %s failed: %s", pod.Name, pod.Status.Message)
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodScheduled && cond.Status == v1.ConditionFalse && cond.Reason == v1.PodReasonUnschedulable {
+			return fmt.Errorf("pod %s is unschedulable: %s", pod.Name, cond.Message)
+		}
+	}
+	return nil
+}
+
+// helper function that returns true if the step pulls its image with
+// registry credentials.
+func hasAuth(proc *backend.Step) bool {
+	return proc.AuthConfig.Username != "" && proc.AuthConfig.Password != ""
+}
+
+// helper function that returns the state of the step container.
+func containerState(pod *v1.Pod) v1.ContainerState {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == stepContainer {
+			return status.State
+		}
+	}
+	return v1.ContainerState{}
+}
+
+// helper function that returns a random build identifier.
+func newID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
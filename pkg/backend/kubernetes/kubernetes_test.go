@@ -0,0 +1,480 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marjoram/pipeline/pipeline"
+	"github.com/marjoram/pipeline/pipeline/backend"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var testConfig = &backend.Config{
+	Volumes: []*backend.Volume{
+		{Name: "pipeline_default", Driver: "local"},
+	},
+	Stages: []*backend.Stage{
+		{
+			Name: "pipeline_services",
+			Steps: []*backend.Step{
+				{
+					Name:     "pipeline_services_0",
+					Image:    "redis:3.0",
+					Detached: true,
+					Networks: []backend.Conn{
+						{Name: "pipeline_default", Aliases: []string{"redis"}},
+					},
+				},
+			},
+		},
+		{
+			Name: "pipeline_stage_0",
+			Steps: []*backend.Step{
+				{
+					Name:        "pipeline_step_0",
+					Image:       "golang:1.7",
+					WorkingDir:  "/go/src/github.com/drone/envsubst",
+					Environment: map[string]string{"CI": "pipec"},
+					Entrypoint:  []string{"/bin/sh", "-c"},
+					Command:     []string{"echo $CI_SCRIPT | base64 -d | /bin/sh -e"},
+					Volumes:     []string{"pipeline_default:/go"},
+					AuthConfig:  backend.Auth{Username: "gordon", Password: "password"},
+				},
+			},
+		},
+	},
+}
+
+// newTestEngine returns an engine of a fake clientset that assigns an ip
+// to the created pods.
+func newTestEngine() (*engine, *fake.Clientset) {
+	var n int
+	return newStatusEngine(func(status *v1.PodStatus) {
+		n++
+		status.PodIP = fmt.Sprintf("10.0.0.%d", n)
+	})
+}
+
+// newStatusEngine returns an engine of a fake clientset that sets the
+// status of the created pods. The reactor adds the pods to the tracker
+// itself, since not every version of the fake clientset keeps the status
+// set on a created object.
+func newStatusEngine(setStatus func(*v1.PodStatus)) (*engine, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create := action.(k8stesting.CreateAction)
+		pod := create.GetObject().(*v1.Pod).DeepCopy()
+		setStatus(&pod.Status)
+		if err := client.Tracker().Create(create.GetResource(), pod, create.GetNamespace()); err != nil {
+			return true, nil, err
+		}
+		return true, pod, nil
+	})
+	return &engine{client: client, namespace: "default", id: "abc123"}, client
+}
+
+func TestSetup(t *testing.T) {
+	e, client := newTestEngine()
+	if err := e.Setup(context.Background(), testConfig); err != nil {
+		t.Fatal(err)
+	}
+	claim, err := client.CoreV1().PersistentVolumeClaims("default").Get("abc123-pipeline-default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := claim.Labels[buildLabel], "abc123"; got != want {
+		t.Errorf("Want build label %q, got %q", want, got)
+	}
+}
+
+func TestExec(t *testing.T) {
+	e, client := newTestEngine()
+	step := testConfig.Stages[1].Steps[0]
+	if err := e.Exec(context.Background(), step); err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := client.CoreV1().Pods("default").Get("abc123-pipeline-step-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := pod.Spec.Containers[0]
+	if got, want := container.Image, "golang:1.7"; got != want {
+		t.Errorf("Want image %q, got %q", want, got)
+	}
+	if got, want := container.WorkingDir, step.WorkingDir; got != want {
+		t.Errorf("Want working dir %q, got %q", want, got)
+	}
+	if got, want := strings.Join(container.Command, " "), "/bin/sh -c"; got != want {
+		t.Errorf("Want command %q, got %q", want, got)
+	}
+	if len(container.Env) != 1 || container.Env[0].Name != "CI" {
+		t.Errorf("Want environment CI=pipec, got %v", container.Env)
+	}
+	if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].MountPath != "/go" {
+		t.Errorf("Want volume mounted at /go, got %v", container.VolumeMounts)
+	}
+	if got, want := pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName, "abc123-pipeline-default"; got != want {
+		t.Errorf("Want volume claim %q, got %q", want, got)
+	}
+	if got, want := pod.Spec.RestartPolicy, v1.RestartPolicyNever; got != want {
+		t.Errorf("Want restart policy %q, got %q", want, got)
+	}
+	if _, err := client.CoreV1().Secrets("default").Get("abc123-pipeline-step-0", metav1.GetOptions{}); err != nil {
+		t.Errorf("Want registry secret created, got %s", err)
+	}
+}
+
+func TestExecService(t *testing.T) {
+	e, client := newTestEngine()
+	ctx := context.Background()
+	if err := e.Exec(ctx, testConfig.Stages[0].Steps[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Exec(ctx, testConfig.Stages[1].Steps[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the network alias resolves to the service pod of the build,
+	// without a service shared by the builds of the namespace.
+	pod, err := client.CoreV1().Pods("default").Get("abc123-pipeline-step-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []v1.HostAlias{{IP: "10.0.0.1", Hostnames: []string{"redis"}}}
+	if got := pod.Spec.HostAliases; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want host aliases %v, got %v", want, got)
+	}
+	if services, _ := client.CoreV1().Services("default").List(metav1.ListOptions{}); len(services.Items) != 0 {
+		t.Errorf("Want no services created, got %d", len(services.Items))
+	}
+}
+
+func TestExecServiceUnscheduled(t *testing.T) {
+	defer func(interval, timeout time.Duration) {
+		pollInterval, scheduleTimeout = interval, timeout
+	}(pollInterval, scheduleTimeout)
+	pollInterval = 10 * time.Millisecond
+	scheduleTimeout = 100 * time.Millisecond
+
+	tests := []struct {
+		status v1.PodStatus
+		err    string
+	}{
+		{
+			status: v1.PodStatus{
+				Phase: v1.PodPending,
+				Conditions: []v1.PodCondition{{
+					Type:    v1.PodScheduled,
+					Status:  v1.ConditionFalse,
+					Reason:  v1.PodReasonUnschedulable,
+					Message: "0/3 nodes are available: 3 Insufficient cpu.",
+				}},
+			},
+			err: "pod abc123-pipeline-services-0 is unschedulable: 0/3 nodes are available: 3 Insufficient cpu.",
+		},
+		{
+			status: v1.PodStatus{Phase: v1.PodFailed, Message: "Pod was rejected"},
+			err:    "pod abc123-pipeline-services-0 failed: Pod was rejected",
+		},
+		{
+			status: v1.PodStatus{Phase: v1.PodPending},
+			err:    "pod abc123-pipeline-services-0 was not assigned an ip within 100ms",
+		},
+	}
+	for _, test := range tests {
+		e, _ := newStatusEngine(func(status *v1.PodStatus) {
+			*status = test.status
+		})
+		err := e.Exec(context.Background(), testConfig.Stages[0].Steps[0])
+		if err == nil || err.Error() != test.err {
+			t.Errorf("Want error %q, got %v", test.err, err)
+		}
+	}
+}
+
+func TestKill(t *testing.T) {
+	e, client := newTestEngine()
+	ctx := context.Background()
+	step := testConfig.Stages[1].Steps[0]
+	if err := e.Exec(ctx, step); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Kill(ctx, step); err != nil {
+		t.Fatal(err)
+	}
+	pods := client.CoreV1().Pods("default")
+	pod, err := pods.Get("abc123-pipeline-step-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Want killed pod kept until removed, got %s", err)
+	}
+	if d := pod.Spec.ActiveDeadlineSeconds; d == nil || *d != 1 {
+		t.Errorf("Want pod active deadline expired, got %v", d)
+	}
+
+	// the step is started again after it is removed.
+	if err := e.Remove(ctx, step); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CoreV1().Secrets("default").Get("abc123-pipeline-step-0", metav1.GetOptions{}); err == nil {
+		t.Errorf("Want registry secret removed with the step")
+	}
+	if err := e.Exec(ctx, step); err != nil {
+		t.Errorf("Want removed step started again, got %s", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	defer func(d time.Duration) {
+		pollInterval = d
+	}(pollInterval)
+	pollInterval = 10 * time.Millisecond
+
+	e, client := newTestEngine()
+	done := make(chan struct{})
+	defer close(done)
+	go kubelet(client, done)
+
+	spec := &backend.Config{
+		Stages: []*backend.Stage{{
+			Name: "pipeline_stage_0",
+			Steps: []*backend.Step{{
+				Name:      "pipeline_step_0",
+				Image:     "golang:1.7",
+				OnSuccess: true,
+				Timeout:   50 * time.Millisecond,
+			}},
+		}},
+	}
+	err := pipeline.New(spec, pipeline.WithEngine(e)).Run()
+	if xerr, ok := err.(*pipeline.TimeoutError); !ok || xerr.Timeout != 50*time.Millisecond {
+		t.Errorf("Want timeout error, got %v", err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	e, client := newTestEngine()
+	step := &backend.Step{
+		Name:     "pipeline_services_0",
+		Image:    "postgres",
+		Detached: true,
+		HealthCheck: &backend.HealthCheck{
+			Exec:     []string{"pg_isready"},
+			Interval: 1500 * time.Millisecond,
+		},
+	}
+	if err := e.Exec(context.Background(), step); err != nil {
+		t.Fatal(err)
+	}
+
+	pods := client.CoreV1().Pods("default")
+	pod, err := pods.Get("abc123-pipeline-services-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := pod.Spec.Containers[0].ReadinessProbe
+	if probe == nil || probe.Exec == nil || probe.Exec.Command[0] != "pg_isready" {
+		t.Fatalf("Want exec readiness probe, got %v", probe)
+	}
+	if got, want := probe.PeriodSeconds, int32(2); got != want {
+		t.Errorf("Want probe period %d, got %d", want, got)
+	}
+
+	if err := e.Probe(context.Background(), step); err == nil {
+		t.Errorf("Want probe error before the container is ready")
+	}
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{
+		{Name: stepContainer, Ready: true, State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+	}
+	if _, err := pods.UpdateStatus(pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Probe(context.Background(), step); err != nil {
+		t.Errorf("Want container ready, got %s", err)
+	}
+}
+
+func TestToProbe(t *testing.T) {
+	if probe := toProbe(&backend.HealthCheck{TCP: 6379}); probe.TCPSocket == nil || probe.TCPSocket.Port.IntValue() != 6379 {
+		t.Errorf("Want tcp probe on port 6379, got %v", probe)
+	}
+	probe := toProbe(&backend.HealthCheck{HTTP: &backend.HTTPGet{Port: 8080, Path: "/health"}})
+	if probe.HTTPGet == nil || probe.HTTPGet.Port.IntValue() != 8080 || probe.HTTPGet.Path != "/health" {
+		t.Errorf("Want http probe on port 8080, got %v", probe)
+	}
+	if probe := toProbe(nil); probe != nil {
+		t.Errorf("Want no probe without a health check, got %v", probe)
+	}
+}
+
+func TestWait(t *testing.T) {
+	tests := []struct {
+		reason    string
+		code      int32
+		oomKilled bool
+	}{
+		{reason: "Completed", code: 0},
+		{reason: "Error", code: 1},
+		{reason: "OOMKilled", code: 137, oomKilled: true},
+	}
+	for _, test := range tests {
+		e, client := newTestEngine()
+		step := testConfig.Stages[1].Steps[0]
+		if err := e.Exec(context.Background(), step); err != nil {
+			t.Fatal(err)
+		}
+		setState(t, client, "abc123-pipeline-step-0", v1.ContainerState{
+			Terminated: &v1.ContainerStateTerminated{
+				ExitCode: test.code,
+				Reason:   test.reason,
+			},
+		})
+
+		state, err := e.Wait(context.Background(), step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !state.Exited {
+			t.Errorf("Want exited state for %s", test.reason)
+		}
+		if got, want := state.ExitCode, int(test.code); got != want {
+			t.Errorf("Want exit code %d, got %d", want, got)
+		}
+		if got, want := state.OOMKilled, test.oomKilled; got != want {
+			t.Errorf("Want oom killed %v, got %v", want, got)
+		}
+	}
+}
+
+func TestWaitCancel(t *testing.T) {
+	e, _ := newTestEngine()
+	step := testConfig.Stages[1].Steps[0]
+	if err := e.Exec(context.Background(), step); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.Wait(ctx, step); err != context.Canceled {
+		t.Errorf("Want context cancelled error, got %v", err)
+	}
+}
+
+func TestTail(t *testing.T) {
+	defer func(s func(*restclient.Request) (io.ReadCloser, error)) {
+		stream = s
+	}(stream)
+	stream = func(*restclient.Request) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("hello world\n")), nil
+	}
+
+	e, client := newTestEngine()
+	step := testConfig.Stages[1].Steps[0]
+	if err := e.Exec(context.Background(), step); err != nil {
+		t.Fatal(err)
+	}
+	setState(t, client, "abc123-pipeline-step-0", v1.ContainerState{
+		Running: &v1.ContainerStateRunning{},
+	})
+
+	rc, err := e.Tail(context.Background(), step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	out, _ := ioutil.ReadAll(rc)
+	if got, want := string(out), "hello world\n"; got != want {
+		t.Errorf("Want logs %q, got %q", want, got)
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	e, client := newTestEngine()
+	ctx := context.Background()
+	if err := e.Setup(ctx, testConfig); err != nil {
+		t.Fatal(err)
+	}
+	for _, stage := range testConfig.Stages {
+		for _, step := range stage.Steps {
+			if err := e.Exec(ctx, step); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := e.Destroy(ctx, testConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	core := client.CoreV1()
+	if pods, _ := core.Pods("default").List(metav1.ListOptions{}); len(pods.Items) != 0 {
+		t.Errorf("Want pods removed, got %d", len(pods.Items))
+	}
+	if secrets, _ := core.Secrets("default").List(metav1.ListOptions{}); len(secrets.Items) != 0 {
+		t.Errorf("Want secrets removed, got %d", len(secrets.Items))
+	}
+	if claims, _ := core.PersistentVolumeClaims("default").List(metav1.ListOptions{}); len(claims.Items) != 0 {
+		t.Errorf("Want volume claims removed, got %d", len(claims.Items))
+	}
+}
+
+func TestToName(t *testing.T) {
+	if got, want := toName("abc123", "pipeline_Step.0"), "abc123-pipeline-step-0"; got != want {
+		t.Errorf("Want name %q, got %q", want, got)
+	}
+}
+
+// helper function that sets the step container state of the pod.
+func setState(t *testing.T, client *fake.Clientset, name string, state v1.ContainerState) {
+	pods := client.CoreV1().Pods("default")
+	pod, err := pods.Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{
+		{Name: stepContainer, State: state},
+	}
+	if _, err := pods.UpdateStatus(pod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// kubelet kills the containers of the pods whose active deadline is set,
+// until done is closed.
+func kubelet(client *fake.Clientset, done <-chan struct{}) {
+	pods := client.CoreV1().Pods("default")
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		list, err := pods.List(metav1.ListOptions{})
+		if err != nil {
+			continue
+		}
+		for _, pod := range list.Items {
+			if pod.Spec.ActiveDeadlineSeconds == nil || isComplete(&pod) {
+				continue
+			}
+			pod.Status.Phase = v1.PodFailed
+			pod.Status.ContainerStatuses = []v1.ContainerStatus{{
+				Name: stepContainer,
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: 137, Reason: "Error"},
+				},
+			}}
+			pods.UpdateStatus(&pod)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package local
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// buffer is an in-memory log buffer that allows any number of readers
+// to follow the output of a running process.
+type buffer struct {
+	sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+}
+
+func newBuffer() *buffer {
+	b := new(buffer)
+	b.cond = sync.NewCond(&b.Mutex)
+	return b
+}
+
+// Write appends p to the buffer and wakes up any waiting readers.
+func (b *buffer) Write(p []byte) (int, error) {
+	b.Lock()
+	b.data = append(b.data, p...)
+	b.Unlock()
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// Close marks the end of the output.
+func (b *buffer) Close() error {
+	b.Lock()
+	b.closed = true
+	b.Unlock()
+	b.cond.Broadcast()
+	return nil
+}
+
+// Reader returns a reader that follows the buffer from the start
+// until it is closed or the context is cancelled.
+func (b *buffer) Reader(ctx context.Context) io.ReadCloser {
+	r := &reader{buffer: b, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Close()
+		case <-r.done:
+		}
+	}()
+	return r
+}
+
+type reader struct {
+	buffer *buffer
+	offset int
+	once   sync.Once
+	done   chan struct{}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	b := r.buffer
+	b.Lock()
+	defer b.Unlock()
+	for r.offset == len(b.data) && !b.closed && !r.isClosed() {
+		b.cond.Wait()
+	}
+	if r.isClosed() {
+		return 0, io.EOF
+	}
+	if r.offset == len(b.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[r.offset:])
+	r.offset += n
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	r.once.Do(func() {
+		r.buffer.Lock()
+		close(r.done)
+		r.buffer.Unlock()
+		r.buffer.cond.Broadcast()
+	})
+	return nil
+}
+
+func (r *reader) isClosed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,281 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+// ErrNoCommand is returned when a step does not define a command,
+// which is the case for plugin steps that rely on the image entrypoint.
+var ErrNoCommand = errors.New("local: step does not define a command")
+
+type engine struct {
+	sync.Mutex
+
+	base  string
+	procs map[string]*process
+}
+
+type process struct {
+	cmd  *exec.Cmd
+	logs *buffer
+	done chan struct{}
+	err  error
+}
+
+// New returns a new local Engine that runs pipeline steps as host
+// processes. Volumes and working directories are created under a
+// temporary directory that is removed when the pipeline is destroyed.
+func New() backend.Engine {
+	return &engine{
+		procs: map[string]*process{},
+	}
+}
+
+// Setup the pipeline environment.
+func (e *engine) Setup(ctx context.Context, conf *backend.Config) error {
+	base, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		return err
+	}
+	e.Lock()
+	e.base = base
+	e.Unlock()
+
+	for _, vol := range conf.Volumes {
+		if err := os.MkdirAll(e.volumePath(vol.Name), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start the pipeline step.
+func (e *engine) Exec(ctx context.Context, proc *backend.Step) error {
+	args := append(append([]string{}, proc.Entrypoint...), proc.Command...)
+	if len(args) == 0 {
+		return ErrNoCommand
+	}
+
+	dir, err := e.workingDir(proc)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	for k, v := range proc.Environment {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	setpgid(cmd)
+
+	p := &process{
+		cmd:  cmd,
+		logs: newBuffer(),
+		done: make(chan struct{}),
+	}
+	cmd.Stdout = p.logs
+	cmd.Stderr = p.logs
+
+	e.Lock()
+	if _, ok := e.procs[proc.Name]; ok {
+		e.Unlock()
+		return fmt.Errorf("local: step %s is already running", proc.Name)
+	}
+	e.procs[proc.Name] = p
+	e.Unlock()
+
+	if err := cmd.Start(); err != nil {
+		e.Lock()
+		delete(e.procs, proc.Name)
+		e.Unlock()
+		return err
+	}
+
+	go func() {
+		p.err = cmd.Wait()
+		p.logs.Close()
+		close(p.done)
+	}()
+	return nil
+}
+
+// Kill the pipeline step.
+func (e *engine) Kill(ctx context.Context, proc *backend.Step) error {
+	p, err := e.lookup(proc)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-p.done:
+		return nil
+	default:
+		return kill(p.cmd)
+	}
+}
+
+// Remove the pipeline step.
+func (e *engine) Remove(ctx context.Context, proc *backend.Step) error {
+	e.Lock()
+	defer e.Unlock()
+	delete(e.procs, proc.Name)
+	return nil
+}
+
+// Wait for the pipeline step to complete and returns
+// the completion results.
+func (e *engine) Wait(ctx context.Context, proc *backend.Step) (*backend.State, error) {
+	p, err := e.lookup(proc)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.done:
+	}
+
+	state := &backend.State{
+		Exited:   true,
+		ExitCode: exitCode(p.cmd.ProcessState),
+	}
+	if _, ok := p.err.(*exec.ExitError); p.err != nil && !ok {
+		return nil, p.err
+	}
+	return state, nil
+}
+
+// Probe runs the step health check once. Exec probes run on the host in
+// the step working directory, while tcp and http probes connect to the
+// local host.
+func (e *engine) Probe(ctx context.Context, proc *backend.Step) error {
+	p, err := e.lookup(proc)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-p.done:
+		return fmt.Errorf("local: step %s exited with code %d", proc.Name, exitCode(p.cmd.ProcessState))
+	default:
+	}
+
+	args := proc.HealthCheck.Exec
+	if len(args) == 0 {
+		return backend.ProbeHost(ctx, proc.HealthCheck, "localhost")
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = p.cmd.Dir
+	cmd.Env = p.cmd.Env
+	return cmd.Run()
+}
+
+// Archive returns a tar archive of the paths in the step working
+// directory.
+func (e *engine) Archive(ctx context.Context, proc *backend.Step, paths []string) (io.ReadCloser, error) {
+	dir, err := e.workingDir(proc)
+	if err != nil {
+		return nil, err
+	}
+	return backend.ArchiveDir(dir, paths), nil
+}
+
+// Extract the tar archive to the step working directory.
+func (e *engine) Extract(ctx context.Context, proc *backend.Step, r io.Reader) error {
+	dir, err := e.workingDir(proc)
+	if err != nil {
+		return err
+	}
+	return backend.ExtractDir(dir, r)
+}
+
+// Tail the pipeline step logs.
+func (e *engine) Tail(ctx context.Context, proc *backend.Step) (io.ReadCloser, error) {
+	p, err := e.lookup(proc)
+	if err != nil {
+		return nil, err
+	}
+	return p.logs.Reader(ctx), nil
+}
+
+// Destroy the pipeline environment.
+func (e *engine) Destroy(ctx context.Context, conf *backend.Config) error {
+	e.Lock()
+	procs := e.procs
+	e.procs = map[string]*process{}
+	base := e.base
+	e.Unlock()
+
+	for _, p := range procs {
+		select {
+		case <-p.done:
+		default:
+			kill(p.cmd)
+			<-p.done
+		}
+	}
+	if base == "" {
+		return nil
+	}
+	return os.RemoveAll(base)
+}
+
+func (e *engine) Close() error {
+	return nil
+}
+
+func (e *engine) lookup(proc *backend.Step) (*process, error) {
+	e.Lock()
+	defer e.Unlock()
+	p, ok := e.procs[proc.Name]
+	if !ok {
+		return nil, fmt.Errorf("local: step %s is not running", proc.Name)
+	}
+	return p, nil
+}
+
+func (e *engine) volumePath(name string) string {
+	return filepath.Join(e.base, "volumes", name)
+}
+
+// workingDir returns the host directory in which the step runs. A
+// working directory inside a mounted volume is mapped to the volume
+// directory on the host, otherwise the step gets its own directory.
+func (e *engine) workingDir(proc *backend.Step) (string, error) {
+	e.Lock()
+	base := e.base
+	e.Unlock()
+	if base == "" {
+		return "", errors.New("local: pipeline environment is not setup")
+	}
+
+	dir := filepath.Join(base, "steps", proc.Name)
+	for _, path := range proc.Volumes {
+		parts := strings.Split(path, ":")
+		if len(parts) < 2 {
+			continue
+		}
+		source, target := parts[0], filepath.Clean(parts[1])
+		rel, err := filepath.Rel(target, filepath.Clean("/"+proc.WorkingDir))
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if filepath.IsAbs(source) {
+			dir = filepath.Join(source, rel)
+		} else {
+			dir = filepath.Join(e.volumePath(source), rel)
+		}
+		break
+	}
+	return dir, os.MkdirAll(dir, 0755)
+}
@@ -0,0 +1,282 @@
+package local
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+func TestEngine(t *testing.T) {
+	conf := &backend.Config{
+		Volumes: []*backend.Volume{
+			{Name: "pipeline_default"},
+		},
+	}
+	write := &backend.Step{
+		Name:        "pipeline_step_0",
+		WorkingDir:  "/go/src/github.com/drone/envsubst",
+		Volumes:     []string{"pipeline_default:/go"},
+		Environment: map[string]string{"GREETING": "hello"},
+		Entrypoint:  []string{"/bin/sh", "-c"},
+		Command:     []string{"echo $GREETING > greeting.txt; echo done; exit 3"},
+	}
+	read := &backend.Step{
+		Name:       "pipeline_step_1",
+		WorkingDir: "/go/src/github.com/drone/envsubst",
+		Volumes:    []string{"pipeline_default:/go"},
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Command:    []string{"cat greeting.txt"},
+	}
+
+	ctx := context.Background()
+	e := New()
+	if err := e.Setup(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Destroy(ctx, conf)
+
+	for _, test := range []struct {
+		step *backend.Step
+		code int
+		logs string
+	}{
+		{write, 3, "done\n"},
+		{read, 0, "hello\n"},
+	} {
+		if err := e.Exec(ctx, test.step); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := e.Tail(ctx, test.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := e.Wait(ctx, test.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := state.ExitCode, test.code; got != want {
+			t.Errorf("Want exit code %d, got %d", want, got)
+		}
+		out, _ := ioutil.ReadAll(rc)
+		if got, want := string(out), test.logs; got != want {
+			t.Errorf("Want logs %q, got %q", want, got)
+		}
+	}
+}
+
+func TestEngineKill(t *testing.T) {
+	step := &backend.Step{
+		Name:       "pipeline_step_0",
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Command:    []string{"sleep 30"},
+	}
+
+	ctx := context.Background()
+	e := New()
+	if err := e.Setup(ctx, &backend.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Destroy(ctx, &backend.Config{})
+
+	if err := e.Exec(ctx, step); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Kill(ctx, step); err != nil {
+		t.Fatal(err)
+	}
+	state, err := e.Wait(ctx, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := state.ExitCode, 137; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+}
+
+func TestEngineWaitCancel(t *testing.T) {
+	step := &backend.Step{
+		Name:    "pipeline_step_0",
+		Command: []string{"sleep", "30"},
+	}
+
+	e := New()
+	if err := e.Setup(context.Background(), &backend.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Destroy(context.Background(), &backend.Config{})
+
+	if err := e.Exec(context.Background(), step); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := e.Wait(ctx, step); err != context.DeadlineExceeded {
+		t.Errorf("Want deadline exceeded error, got %v", err)
+	}
+}
+
+func TestEngineNoCommand(t *testing.T) {
+	e := New()
+	if err := e.Setup(context.Background(), &backend.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Destroy(context.Background(), &backend.Config{})
+
+	err := e.Exec(context.Background(), &backend.Step{Name: "plugin", Image: "plugins/slack"})
+	if err != ErrNoCommand {
+		t.Errorf("Want ErrNoCommand, got %v", err)
+	}
+}
+
+func TestEngineProbe(t *testing.T) {
+	step := &backend.Step{
+		Name:       "pipeline_services_0",
+		Detached:   true,
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Command:    []string{"sleep 0.2; touch ready; sleep 30"},
+		HealthCheck: &backend.HealthCheck{
+			Exec: []string{"test", "-f", "ready"},
+		},
+	}
+
+	ctx := context.Background()
+	e := New()
+	if err := e.Setup(ctx, &backend.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Destroy(ctx, &backend.Config{})
+
+	if err := e.Exec(ctx, step); err != nil {
+		t.Fatal(err)
+	}
+	prober := e.(backend.Prober)
+	if err := prober.Probe(ctx, step); err == nil {
+		t.Errorf("Want probe error before the step is ready")
+	}
+	time.Sleep(time.Second)
+	if err := prober.Probe(ctx, step); err != nil {
+		t.Errorf("Want step ready, got %s", err)
+	}
+}
+
+func TestEngineProbeTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	step := &backend.Step{
+		Name:     "pipeline_services_0",
+		Detached: true,
+		Command:  []string{"sleep", "30"},
+		HealthCheck: &backend.HealthCheck{
+			TCP: port,
+		},
+	}
+
+	ctx := context.Background()
+	e := New()
+	if err := e.Setup(ctx, &backend.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Destroy(ctx, &backend.Config{})
+
+	if err := e.Exec(ctx, step); err != nil {
+		t.Fatal(err)
+	}
+	prober := e.(backend.Prober)
+	if err := prober.Probe(ctx, step); err == nil {
+		t.Errorf("Want probe error without a listener")
+	}
+
+	l, err = net.Listen("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := prober.Probe(ctx, step); err != nil {
+		t.Errorf("Want step ready, got %s", err)
+	}
+}
+
+func TestEngineArchive(t *testing.T) {
+	conf := &backend.Config{
+		Volumes: []*backend.Volume{
+			{Name: "pipeline_default"},
+		},
+	}
+	step := &backend.Step{
+		Name:       "pipeline_cache_save",
+		WorkingDir: "/pipeline/src",
+		Volumes:    []string{"pipeline_default:/pipeline"},
+	}
+
+	ctx := context.Background()
+	e := New()
+	if err := e.Setup(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Destroy(ctx, conf)
+
+	dir, err := e.(*engine).workingDir(step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "node_modules", "left-pad"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "node_modules", "left-pad", "index.js"), []byte("module.exports"), 0644)
+	os.Symlink("left-pad", filepath.Join(dir, "node_modules", "pad"))
+	ioutil.WriteFile(filepath.Join(dir, "main.js"), []byte("main"), 0644)
+
+	rc, err := e.(backend.Archiver).Archive(ctx, step, []string{"node_modules", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.RemoveAll(dir)
+	if err := e.(backend.Archiver).Extract(ctx, step, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := ioutil.ReadFile(filepath.Join(dir, "node_modules", "pad", "index.js")); string(out) != "module.exports" {
+		t.Errorf("Want archived files restored, got %q", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.js")); !os.IsNotExist(err) {
+		t.Errorf("Want only the archived paths restored")
+	}
+}
+
+func TestEngineExtractOutside(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, header := range []*tar.Header{
+		{Name: "../outside.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+	} {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		tw.WriteHeader(header)
+		tw.Close()
+		if err := backend.ExtractDir(dir, buf); err == nil {
+			t.Errorf("Want error extracting %s -> %s", header.Name, header.Linkname)
+		}
+	}
+}
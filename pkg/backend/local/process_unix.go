@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package local
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setpgid starts the command in its own process group so that any
+// child processes are killed together with the step.
+func setpgid(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// kill sends SIGKILL to the process group of the command.
+func kill(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// exitCode returns the exit code of the process. A process terminated
+// by a signal reports 128 plus the signal number, like a shell does.
+func exitCode(state *os.ProcessState) int {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return 1
+	}
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
@@ -0,0 +1,20 @@
+package local
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setpgid(cmd *exec.Cmd) {}
+
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok {
+		return status.ExitStatus()
+	}
+	return 1
+}
@@ -0,0 +1,40 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// ProbeHost runs the tcp or http probe of the health check against the
+// host, returning an error if the step is not ready.
+func ProbeHost(ctx context.Context, check *HealthCheck, host string) error {
+	switch {
+	case check.TCP != 0:
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(check.TCP)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case check.HTTP != nil:
+		url := "http://" + net.JoinHostPort(host, strconv.Itoa(check.HTTP.Port)) + check.HTTP.Path
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		res, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 400 {
+			return fmt.Errorf("GET %s: unexpected status %s", url, res.Status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("health check does not define a tcp or http probe")
+	}
+}
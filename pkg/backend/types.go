@@ -1,5 +1,7 @@
 package backend
 
+import "time"
+
 type (
 	// Config defines the runtime configuration of a pipeline.
 	Config struct {
@@ -11,9 +13,11 @@ type (
 
 	// Stage denotes a collection of one or more steps.
 	Stage struct {
-		Name  string  `json:"name,omitempty"`
-		Alias string  `json:"alias,omitempty"`
-		Steps []*Step `json:"steps,omitempty"`
+		Name     string        `json:"name,omitempty"`
+		Alias    string        `json:"alias,omitempty"`
+		Steps    []*Step       `json:"steps,omitempty"`
+		Timeout  time.Duration `json:"timeout,omitempty"`
+		MaxProcs int           `json:"max_procs,omitempty"`
 	}
 
 	// Step defines a container process.
@@ -44,12 +48,98 @@ type (
 		CPUSet       string            `json:"cpu_set,omitempty"`
 		OnFailure    bool              `json:"on_failure,omitempty"`
 		OnSuccess    bool              `json:"on_success,omitempty"`
+		DependsOn    []string          `json:"depends_on,omitempty"`
+		When         *When             `json:"when,omitempty"`
+		Retry        *Retry            `json:"retry,omitempty"`
+		HealthCheck  *HealthCheck      `json:"healthcheck,omitempty"`
+		Cache        *Cache            `json:"cache,omitempty"`
+		Timeout      time.Duration     `json:"timeout,omitempty"`
 		AuthConfig   Auth              `json:"auth_config,omitempty"`
 		NetworkMode  string            `json:"network_mode,omitempty"`
 		IpcMode      string            `json:"ipc_mode,omitempty"`
 		Sysctls      map[string]string `json:"sysctls,omitempty"`
 	}
 
+	// When defines the conditions under which a step is executed.
+	// The conditions are evaluated against the CI_* variables in the
+	// step environment.
+	When struct {
+		// Commit branch, matched against CI_COMMIT_BRANCH
+		Branch Constraint `json:"branch,omitempty"`
+		// Build event, matched against CI_BUILD_EVENT
+		Event Constraint `json:"event,omitempty"`
+		// Commit ref, matched against CI_COMMIT_REF
+		Ref Constraint `json:"ref,omitempty"`
+		// Pipeline status, either success or failure
+		Status Constraint `json:"status,omitempty"`
+		// Changed paths, matched against CI_COMMIT_FILES
+		Paths Constraint `json:"paths,omitempty"`
+		// Environment variables, matched by name
+		Environment map[string]Constraint `json:"environment,omitempty"`
+	}
+
+	// Constraint defines glob patterns a value must, or must not,
+	// match. An empty constraint matches any value.
+	Constraint struct {
+		Include []string `json:"include,omitempty"`
+		Exclude []string `json:"exclude,omitempty"`
+	}
+
+	// Retry defines a step retry policy.
+	Retry struct {
+		// Maximum number of attempts, including the first
+		Attempts int `json:"attempts,omitempty"`
+		// Delay between attempts
+		Backoff Backoff `json:"backoff,omitempty"`
+		// Exit codes that trigger a retry, any non-zero
+		// exit code when empty
+		ExitCodes []int `json:"exit_codes,omitempty"`
+	}
+
+	// Backoff defines the delay between retry attempts.
+	Backoff struct {
+		// Backoff strategy, one of constant, linear or
+		// exponential
+		Strategy string `json:"strategy,omitempty"`
+		// Initial delay
+		Delay time.Duration `json:"delay,omitempty"`
+		// Maximum delay, unbounded when zero
+		MaxDelay time.Duration `json:"max_delay,omitempty"`
+	}
+
+	// HealthCheck defines the readiness probe of a detached step. The
+	// steps depending on the detached step are started once the probe
+	// succeeds. Exactly one of the TCP, Exec and HTTP probes is set.
+	HealthCheck struct {
+		// Port accepting tcp connections
+		TCP int `json:"tcp,omitempty"`
+		// Command run in the container, exiting with code 0
+		Exec []string `json:"exec,omitempty"`
+		// Endpoint responding with a 2xx or 3xx status code
+		HTTP *HTTPGet `json:"http,omitempty"`
+		// Delay between probes
+		Interval time.Duration `json:"interval,omitempty"`
+		// Maximum time to wait for the step to become ready
+		Timeout time.Duration `json:"timeout,omitempty"`
+	}
+
+	// HTTPGet defines an http readiness probe.
+	HTTPGet struct {
+		Port int    `json:"port,omitempty"`
+		Path string `json:"path,omitempty"`
+	}
+
+	// Cache defines a cache step, which restores or saves paths of the
+	// step working directory instead of running the step container.
+	Cache struct {
+		// Cache action, either restore or save
+		Action string `json:"action"`
+		// Cache key template, rendered before the step runs
+		Key string `json:"key"`
+		// Paths saved to the cache, relative to the working directory
+		Paths []string `json:"paths,omitempty"`
+	}
+
 	// Auth defines registry authentication credentials.
 	Auth struct {
 		Username string `json:"username,omitempty"`
@@ -93,6 +183,8 @@ type (
 		Exited bool `json:"exited"`
 		// Container is oom killed, true or false
 		OOMKilled bool `json:"oom_killed"`
+		// Container skipped, true or false
+		Skipped bool `json:"skipped,omitempty"`
 	}
 
 	// // State defines the pipeline and process state.
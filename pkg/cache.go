@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/cache"
+	"github.com/marjoram/pipeline/pipeline/multipart"
+)
+
+// Cache step actions.
+const (
+	CacheRestore = "restore"
+	CacheSave    = "save"
+)
+
+// execCache restores or saves the cache of the step, and reports the
+// step state to the tracer like a container step.
+func (r *Runtime) execCache(ctx context.Context, proc *backend.Step, attempt int) error {
+	logs := new(bytes.Buffer)
+	err := r.runCache(ctx, proc, logs)
+	if err != nil {
+		fmt.Fprintln(logs, err)
+	}
+	if r.logger != nil {
+		r.logger.Log(proc, multipart.New(logs))
+	}
+
+	if r.tracer != nil {
+		state := new(State)
+		state.Pipeline.Time = r.started
+		state.Pipeline.Error = r.failure()
+		state.Pipeline.Step = proc
+		state.Pipeline.Attempt = attempt
+		state.Process = &backend.State{Exited: true}
+		if err != nil {
+			state.Process.ExitCode = 1
+		}
+		if err := r.tracer.Trace(state); err != nil {
+			return err
+		}
+	}
+
+	if err != nil {
+		return &CacheError{Name: proc.Name, Err: err}
+	}
+	return nil
+}
+
+func (r *Runtime) runCache(ctx context.Context, proc *backend.Step, logs io.Writer) error {
+	archiver, ok := r.engine.(backend.Archiver)
+	if !ok {
+		return errors.New("engine does not support cache steps")
+	}
+
+	key, err := cache.Key(proc.Cache.Key, proc.Environment, func(paths ...string) (string, error) {
+		rc, err := archiver.Archive(ctx, proc, paths)
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		sum, err := cache.Checksum(rc)
+		if err != nil {
+			return "", fmt.Errorf("checksum %s: %s", strings.Join(paths, " "), err)
+		}
+		return sum, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	switch proc.Cache.Action {
+	case CacheRestore:
+		rc, err := r.cache.Get(ctx, key)
+		if err == cache.ErrNotFound {
+			fmt.Fprintf(logs, "cache miss for key %s\n", key)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		zr, err := gzip.NewReader(rc)
+		if err != nil {
+			return err
+		}
+		if err := archiver.Extract(ctx, proc, zr); err != nil {
+			return err
+		}
+		fmt.Fprintf(logs, "restored cache for key %s\n", key)
+
+	case CacheSave:
+		rc, err := archiver.Archive(ctx, proc, proc.Cache.Paths)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		pr, pw := io.Pipe()
+		go func() {
+			zw := gzip.NewWriter(pw)
+			_, err := io.Copy(zw, rc)
+			if cerr := zw.Close(); err == nil {
+				err = cerr
+			}
+			pw.CloseWithError(err)
+		}()
+		if err := r.cache.Put(ctx, key, pr); err != nil {
+			// unblocks the archive writer.
+			pr.CloseWithError(err)
+			return err
+		}
+		fmt.Fprintf(logs, "saved %s to cache key %s\n", strings.Join(proc.Cache.Paths, ", "), key)
+
+	default:
+		return fmt.Errorf("unknown cache action %q", proc.Cache.Action)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Package cache stores the archives of the paths saved by pipeline cache
+// steps, indexed by the rendered cache key.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ErrNotFound is returned when the store does not have an archive for
+// the key.
+var ErrNotFound = errors.New("cache: not found")
+
+// Store saves and restores cache archives.
+type Store interface {
+	// Get returns the archive saved under the key, or ErrNotFound.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put saves the archive under the key, replacing any existing
+	// archive.
+	Put(ctx context.Context, key string, r io.Reader) error
+}
+
+// Open returns the store located by the url. A file url or a plain path
+// opens a FileStore, and an s3 url opens an S3Store:
+//
+//	file:///var/cache/pipeline
+//	s3://bucket/prefix?region=us-east-1&endpoint=http://minio:9000&path-style=true
+//
+// The s3 credentials are read from the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables.
+func Open(rawurl string) (Store, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "", "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("cache: missing path in %s", rawurl)
+		}
+		return NewFileStore(u.Path), nil
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("cache: missing bucket in %s", rawurl)
+		}
+		query := u.Query()
+		return NewS3Store(S3Config{
+			Endpoint:  query.Get("endpoint"),
+			Region:    query.Get("region"),
+			Bucket:    u.Host,
+			Prefix:    strings.TrimPrefix(u.Path, "/"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			PathStyle: query.Get("path-style") == "true",
+		}), nil
+	default:
+		return nil, fmt.Errorf("cache: unsupported store %s", rawurl)
+	}
+}
+
+// validateKey returns an error if the key cannot be used as a relative
+// file path or object name.
+func validateKey(key string) error {
+	if key == "" {
+		return errors.New("cache: empty key")
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("cache: invalid key %q", key)
+		}
+	}
+	if strings.ContainsAny(key, "\\\x00") {
+		return fmt.Errorf("cache: invalid key %q", key)
+	}
+	return nil
+}
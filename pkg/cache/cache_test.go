@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testStore(t, NewFileStore(dir))
+}
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	if _, err := store.Get(ctx, "npm/master"); err != ErrNotFound {
+		t.Errorf("Want ErrNotFound for a missing key, got %v", err)
+	}
+	for _, data := range []string{"first", "second"} {
+		if err := store.Put(ctx, "npm/master", strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := store.Get(ctx, "npm/master")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(got) != data {
+			t.Errorf("Want archive %q, got %q", data, got)
+		}
+	}
+	for _, key := range []string{"", "../outside", "npm//master", "/npm"} {
+		if err := store.Put(ctx, key, strings.NewReader("data")); err == nil {
+			t.Errorf("Want invalid key error for %q", key)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		url  string
+		want Store
+	}{
+		{
+			url:  "/var/cache/pipeline",
+			want: NewFileStore("/var/cache/pipeline"),
+		},
+		{
+			url:  "file:///var/cache/pipeline",
+			want: NewFileStore("/var/cache/pipeline"),
+		},
+	}
+	for _, test := range tests {
+		got, err := Open(test.url)
+		if err != nil {
+			t.Errorf("%s: %s", test.url, err)
+			continue
+		}
+		if *got.(*FileStore) != *test.want.(*FileStore) {
+			t.Errorf("%s: want %v, got %v", test.url, test.want, got)
+		}
+	}
+
+	store, err := Open("s3://ci-cache/builds?endpoint=http://minio:9000&path-style=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := store.(*S3Store).config
+	if config.Bucket != "ci-cache" || config.Prefix != "builds" || config.Endpoint != "http://minio:9000" ||
+		config.Region != DefaultS3Region || !config.PathStyle {
+		t.Errorf("Want s3 store configured from the url, got %+v", config)
+	}
+
+	for _, url := range []string{"s3:///prefix", "gs://bucket", "file://"} {
+		if _, err := Open(url); err == nil {
+			t.Errorf("Want error opening %s", url)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	env := map[string]string{"CI_COMMIT_BRANCH": "master"}
+	checksum := func(paths ...string) (string, error) {
+		if len(paths) == 1 && paths[0] == "go.sum" {
+			return "abc123", nil
+		}
+		return "", errors.New("not found")
+	}
+
+	got, err := Key(`go/{{ env "CI_COMMIT_BRANCH" }}-{{ checksum "go.sum" }}`, env, checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "go/master-abc123"; got != want {
+		t.Errorf("Want key %q, got %q", want, got)
+	}
+
+	for _, text := range []string{
+		`{{ checksum "go.mod" }}`,
+		`{{ checksum "go.sum"`,
+		`{{ env "CI_BUILD_EVENT" }}`,
+		`../{{ env "CI_COMMIT_BRANCH" }}`,
+	} {
+		if key, err := Key(text, env, checksum); err == nil {
+			t.Errorf("Want error rendering %s, got key %q", text, key)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	archive := func(files ...string) *bytes.Buffer {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		tw.WriteHeader(&tar.Header{Name: "vendor/", Typeflag: tar.TypeDir, Mode: 0755})
+		for i := 0; i < len(files); i += 2 {
+			tw.WriteHeader(&tar.Header{Name: files[i], Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[i+1]))})
+			tw.Write([]byte(files[i+1]))
+		}
+		tw.Close()
+		return buf
+	}
+
+	a, err := Checksum(archive("go.sum", "v1.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Checksum(archive("go.sum", "v1.0.1"))
+	c, _ := Checksum(archive("go.mod", "v1.0.0"))
+	if a == b || a == c {
+		t.Errorf("Want checksum of file names and contents")
+	}
+	if again, _ := Checksum(archive("go.sum", "v1.0.0")); again != a {
+		t.Errorf("Want stable checksum, got %s and %s", a, again)
+	}
+	if _, err := Checksum(archive()); err == nil {
+		t.Errorf("Want error without files")
+	}
+}
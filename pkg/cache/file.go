@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// archive file extension.
+const archiveExt = ".tar.gz"
+
+// FileStore saves the cache archives in a local directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store that saves the cache archives in the
+// directory, which is created on the first save.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// Get returns the archive saved under the key.
+func (s *FileStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Put saves the archive under the key. The archive is written to a
+// temporary file that replaces the existing archive once complete, so
+// concurrent readers never see a partial archive.
+func (s *FileStore) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".cache")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key)+archiveExt)
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// ChecksumFunc returns the checksum of the files in the paths.
+type ChecksumFunc func(paths ...string) (string, error)
+
+// Key renders the cache key template. The template may use the env
+// function, which returns a variable of the step environment, and the
+// checksum function, which returns a checksum of workspace files:
+//
+//	go-{{ env "CI_COMMIT_BRANCH" }}-{{ checksum "go.sum" }}
+func Key(text string, env map[string]string, checksum ChecksumFunc) (string, error) {
+	tmpl, err := template.New("key").Funcs(template.FuncMap{
+		"env": func(name string) string {
+			return env[name]
+		},
+		"checksum": checksum,
+	}).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("cache: invalid key %q: %s", text, err)
+	}
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, nil); err != nil {
+		return "", fmt.Errorf("cache: cannot render key %q: %s", text, err)
+	}
+	key := strings.TrimSpace(buf.String())
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Checksum returns the sha256 checksum of the names and contents of the
+// regular files in the tar archive, or an error if the archive does not
+// contain any file.
+func Checksum(r io.Reader) (string, error) {
+	hash := sha256.New()
+	tr := tar.NewReader(r)
+	var files int
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", header.Name, header.Size)
+		if _, err := io.Copy(hash, tr); err != nil {
+			return "", err
+		}
+		files++
+	}
+	if files == 0 {
+		return "", fmt.Errorf("cache: no files to checksum")
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
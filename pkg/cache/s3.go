@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default s3 settings.
+const (
+	DefaultS3Region = "us-east-1"
+
+	// hash of the empty request body.
+	emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Config configures an S3Store.
+type S3Config struct {
+	// Endpoint of the s3 compatible server, defaults to the aws
+	// endpoint of the region
+	Endpoint string
+	// Region of the bucket, defaults to us-east-1
+	Region string
+	// Bucket storing the archives
+	Bucket string
+	// Prefix of the archive object names
+	Prefix string
+	// Access key credentials
+	AccessKey string
+	SecretKey string
+	// Address the bucket in the url path instead of the host name,
+	// which is required by most s3 compatible servers
+	PathStyle bool
+	// HTTP client, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// S3Store saves the cache archives in an s3 compatible bucket. The
+// requests are signed with aws signature version 4.
+type S3Store struct {
+	config S3Config
+	now    func() time.Time
+}
+
+// NewS3Store returns a store that saves the cache archives in the s3
+// bucket.
+func NewS3Store(config S3Config) *S3Store {
+	if config.Region == "" {
+		config.Region = DefaultS3Region
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	return &S3Store{config: config, now: time.Now}
+}
+
+// Get returns the archive saved under the key.
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	req, err := s.request(ctx, "GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, emptyHash)
+	res, err := s.config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		res.Body.Close()
+		return nil, ErrNotFound
+	case res.StatusCode != http.StatusOK:
+		defer res.Body.Close()
+		return nil, responseError(res)
+	}
+	return res.Body, nil
+}
+
+// Put saves the archive under the key. The archive is buffered in a
+// temporary file, as the request is signed with the hash of its body.
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "cache")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := s.request(ctx, "PUT", key, ioutil.NopCloser(f))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	s.sign(req, hex.EncodeToString(hash.Sum(nil)))
+	res, err := s.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return responseError(res)
+	}
+	return nil
+}
+
+// request returns a request for the archive object of the key.
+func (s *S3Store) request(ctx context.Context, method, key string, body io.ReadCloser) (*http.Request, error) {
+	u, err := url.Parse(s.config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	object := strings.Trim(s.config.Prefix, "/") + "/" + key + archiveExt
+	object = strings.TrimPrefix(object, "/")
+	if s.config.PathStyle {
+		u.Path = "/" + s.config.Bucket + "/" + object
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = "/" + object
+	}
+	u.RawPath = escapePath(u.Path)
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = body
+	}
+	return req.WithContext(ctx), nil
+}
+
+// sign adds the aws signature version 4 authorization header to the
+// request, signing the host and every request header.
+func (s *S3Store) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		hashHex(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+// canonicalQuery returns the query parameters sorted by name, with
+// names and values uri encoded.
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for k, values := range query {
+		for _, v := range values {
+			params = append(params, escape(k, true)+"="+escape(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// escapePath uri encodes each segment of the path.
+func escapePath(path string) string {
+	return escape(path, false)
+}
+
+// escape uri encodes every byte except the unreserved characters, and
+// the slash unless escapeSlash is set.
+func escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hashHex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// responseError returns an error with the status and body of an
+// unsuccessful response.
+func responseError(res *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("cache: %s %s: %s: %s", res.Request.Method, res.Request.URL.Path, res.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/backend/fake"
+	"github.com/marjoram/pipeline/pipeline/cache"
+	"github.com/marjoram/pipeline/pipeline/multipart"
+)
+
+func cacheSpec() *backend.Config {
+	key := `npm/{{ env "CI_COMMIT_BRANCH" }}-{{ checksum "package-lock.json" }}`
+	env := map[string]string{"CI_COMMIT_BRANCH": "master"}
+	return testSpec(
+		&backend.Step{
+			Name:        "restore_cache",
+			OnSuccess:   true,
+			Environment: env,
+			Cache:       &backend.Cache{Action: CacheRestore, Key: key},
+		},
+		&backend.Step{Name: "build", OnSuccess: true},
+		&backend.Step{
+			Name:        "save_cache",
+			OnSuccess:   true,
+			Environment: env,
+			Cache:       &backend.Cache{Action: CacheSave, Key: key, Paths: []string{"node_modules"}},
+		},
+	)
+}
+
+func TestRunCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := cache.NewFileStore(dir)
+
+	// the build step is logged in a separate goroutine that may still be
+	// running when Run returns, so the logs are read after every step of
+	// the pipeline has been logged.
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		logs = map[string]string{}
+	)
+	wg.Add(3)
+	logger := LogFunc(func(step *backend.Step, r multipart.Reader) error {
+		defer wg.Done()
+		part, err := r.NextPart()
+		if err != nil {
+			return err
+		}
+		out, _ := ioutil.ReadAll(part)
+		mu.Lock()
+		logs[step.Name] = string(out)
+		mu.Unlock()
+		return nil
+	})
+
+	// the first build misses the cache, and saves the build output.
+	engine := fake.New()
+	engine.Files["package-lock.json"] = "v1"
+	engine.Files["node_modules/left-pad/index.js"] = "module.exports"
+	engine.Files["main.js"] = "main"
+	if err := New(cacheSpec(), WithEngine(engine), WithCache(store), WithLogger(logger)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := engine.Called("exec"), []string{"build"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want cache steps not executed as containers, got %v", got)
+	}
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.HasPrefix(logs["restore_cache"], "cache miss for key npm/master-") ||
+		!strings.HasPrefix(logs["save_cache"], "saved node_modules to cache key npm/master-") {
+		t.Errorf("Want cache miss and save logged, got %q", logs)
+	}
+
+	// the next build restores the saved paths.
+	engine = fake.New()
+	engine.Files["package-lock.json"] = "v1"
+	if err := New(cacheSpec(), WithEngine(engine), WithCache(store)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := engine.Files["node_modules/left-pad/index.js"]; got != "module.exports" {
+		t.Errorf("Want cached paths restored, got %v", engine.Files)
+	}
+	if _, ok := engine.Files["main.js"]; ok {
+		t.Errorf("Want only the cached paths restored")
+	}
+
+	// the key changes with the checksum of the lock file.
+	engine = fake.New()
+	engine.Files["package-lock.json"] = "v2"
+	if err := New(cacheSpec(), WithEngine(engine), WithCache(store)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := engine.Files["node_modules/left-pad/index.js"]; ok {
+		t.Errorf("Want cache miss after the lock file changed")
+	}
+}
+
+func TestRunCacheSkipped(t *testing.T) {
+	engine := fake.New()
+	var skipped []string
+	tracer := TraceFunc(func(state *State) error {
+		if state.Process.Skipped {
+			skipped = append(skipped, state.Pipeline.Step.Name)
+		}
+		return nil
+	})
+	if err := New(cacheSpec(), WithEngine(engine), WithTracer(tracer)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"restore_cache", "save_cache"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("Want cache steps skipped without a store, got %v", skipped)
+	}
+	if got := engine.Called("archive"); len(got) != 0 {
+		t.Errorf("Want no archive calls, got %v", got)
+	}
+}
+
+func TestRunCacheError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the checksum fails without the lock file.
+	engine := fake.New()
+	err = New(cacheSpec(), WithEngine(engine), WithCache(cache.NewFileStore(dir))).Run()
+	if xerr, ok := err.(*CacheError); !ok || xerr.Name != "restore_cache" {
+		t.Errorf("Want cache error, got %v", err)
+	}
+	if got := engine.Called("exec"); len(got) != 0 {
+		t.Errorf("Want pipeline stopped after cache error, got %v", got)
+	}
+}
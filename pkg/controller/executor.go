@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"github.com/marjoram/pipeline/apis/pipeline.cncd.io/v1alpha1"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultExecutorImage is the image of the executor container, used when
+// the pipeline does not set an image.
+const DefaultExecutorImage = "cncd/pipeline-worker"
+
+// name of the container running the pipeline steps.
+const executorContainer = "executor"
+
+// ExecutorPod returns the executor pod of the pipeline. The executor
+// container runs the steps of the pipeline, and the services of the
+// pipeline run as sidecars of the pod. The steps inherit the connection
+// environment of the services from the executor container.
+func ExecutorPod(pipeline *v1alpha1.Pipeline) (*v1.Pod, error) {
+	driver := pipeline.Spec.Pipeline
+
+	image := driver.Image
+	if image == "" {
+		image = DefaultExecutorImage
+	}
+	env := map[string]string{}
+	for k, v := range pipeline.Spec.EnvVars {
+		env[k] = v
+	}
+	for k, v := range driver.EnvVars {
+		env[k] = v
+	}
+
+	labels := map[string]string{}
+	annotations := map[string]string{}
+	if meta := driver.PodMetadata; meta != nil {
+		for k, v := range meta.Labels {
+			labels[k] = v
+		}
+		for k, v := range meta.Annotations {
+			annotations[k] = v
+		}
+	}
+	labels[pipelineLabel] = pipeline.Name
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pipeline.Name + "-executor",
+			Labels:          labels,
+			Annotations:     annotations,
+			OwnerReferences: ownerReferences(pipeline),
+		},
+		Spec: v1.PodSpec{
+			ServiceAccountName: driver.ServiceAccountName.Name,
+			RestartPolicy:      v1.RestartPolicyNever,
+			Volumes:            pipeline.Spec.Volumes,
+			Containers: []v1.Container{{
+				Name:         executorContainer,
+				Image:        image,
+				Env:          mergeEnv(nil, env),
+				Resources:    driver.Resources,
+				VolumeMounts: driver.VolumeMounts,
+			}},
+		},
+	}
+	if err := AddServices(&pod.Spec, pipeline); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// CreateExecutor creates the executor pod of the pipeline in the
+// namespace, along with the secret holding the service credentials. A
+// secret left by an earlier executor of the pipeline is reused.
+func CreateExecutor(client kubernetes.Interface, namespace string, pipeline *v1alpha1.Pipeline) (*v1.Pod, error) {
+	pod, err := ExecutorPod(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := Secret(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil {
+		_, err := client.CoreV1().Secrets(namespace).Create(secret)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return nil, err
+		}
+	}
+	return client.CoreV1().Pods(namespace).Create(pod)
+}
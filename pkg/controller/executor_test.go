@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/marjoram/pipeline/apis/pipeline.cncd.io/v1alpha1"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExecutorPod(t *testing.T) {
+	pipeline := newPipeline(
+		v1alpha1.ServiceSideCar{Type: v1alpha1.MySQL},
+		v1alpha1.ServiceSideCar{Type: v1alpha1.Redis},
+	)
+	pipeline.Spec.EnvVars = map[string]string{"GOPATH": "/go", "CI": "false"}
+	pipeline.Spec.Pipeline.EnvVars = map[string]string{"CI": "true"}
+
+	pod, err := ExecutorPod(pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pod.Labels[pipelineLabel], "build"; got != want {
+		t.Errorf("Want pipeline label %q, got %q", want, got)
+	}
+	if owner := pod.OwnerReferences[0]; owner.Kind != "Pipeline" || owner.Name != "build" {
+		t.Errorf("Want pod owned by the pipeline, got %v", owner)
+	}
+	if pod.Spec.RestartPolicy != v1.RestartPolicyNever {
+		t.Errorf("Want restart policy Never, got %s", pod.Spec.RestartPolicy)
+	}
+
+	containers := pod.Spec.Containers
+	if len(containers) != 3 {
+		t.Fatalf("Want executor and two sidecars, got %d containers", len(containers))
+	}
+	executor, mysql, redis := containers[0], containers[1], containers[2]
+	if executor.Name != "executor" || executor.Image != DefaultExecutorImage {
+		t.Errorf("Want default executor container, got %s %s", executor.Name, executor.Image)
+	}
+	if mysql.Image != DefaultMySQLImage || mysql.ReadinessProbe == nil {
+		t.Errorf("Want mysql sidecar with a readiness probe, got %s %v", mysql.Image, mysql.ReadinessProbe)
+	}
+	if redis.Image != DefaultRedisImage || redis.ReadinessProbe == nil {
+		t.Errorf("Want redis sidecar with a readiness probe, got %s %v", redis.Image, redis.ReadinessProbe)
+	}
+
+	env := map[string]v1.EnvVar{}
+	for _, v := range executor.Env {
+		env[v.Name] = v
+	}
+	for name, value := range map[string]string{
+		"CI":         "true",
+		"GOPATH":     "/go",
+		"MYSQL_HOST": "127.0.0.1",
+		"REDIS_URL":  "redis://127.0.0.1:6379",
+	} {
+		if got := env[name].Value; got != value {
+			t.Errorf("Want executor variable %s=%s, got %q", name, value, got)
+		}
+	}
+	if got := env["MYSQL_PASSWORD"].ValueFrom; got == nil || got.SecretKeyRef.Name != "build-services" {
+		t.Errorf("Want executor password from the generated secret, got %v", got)
+	}
+}
+
+func TestExecutorPodError(t *testing.T) {
+	_, err := ExecutorPod(newPipeline(v1alpha1.ServiceSideCar{Type: "postgres"}))
+	if err == nil {
+		t.Errorf("Want error for an unknown service type")
+	}
+}
+
+func TestCreateExecutor(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	pipeline := newPipeline(v1alpha1.ServiceSideCar{Type: v1alpha1.MySQL})
+	if _, err := CreateExecutor(client, "ci", pipeline); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CoreV1().Secrets("ci").Get("build-services", metav1.GetOptions{}); err != nil {
+		t.Errorf("Want service secret created, got %s", err)
+	}
+	pod, err := client.CoreV1().Pods("ci").Get("build-executor", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(pod.Spec.Containers); got != 2 {
+		t.Errorf("Want executor and mysql sidecar, got %d containers", got)
+	}
+
+	// the secret of an earlier executor is reused.
+	client.CoreV1().Pods("ci").Delete("build-executor", &metav1.DeleteOptions{})
+	if _, err := CreateExecutor(client, "ci", pipeline); err != nil {
+		t.Errorf("Want existing secret reused, got %s", err)
+	}
+}
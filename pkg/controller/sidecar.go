@@ -0,0 +1,291 @@
+// Package controller converts Pipeline resources to the kubernetes
+// objects run by the pipeline controller.
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/marjoram/pipeline/apis/pipeline.cncd.io/v1alpha1"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Default service sidecar images, used when the service does not set
+// an image.
+const (
+	DefaultMySQLImage = "mysql:5.7"
+	DefaultRedisImage = "redis:5-alpine"
+)
+
+const (
+	// label applied to the generated resources of a pipeline.
+	pipelineLabel = "pipeline.cncd.io/pipeline"
+
+	// services are reached on the loopback interface of the pod.
+	serviceHost = "127.0.0.1"
+
+	mysqlPort = 3306
+	redisPort = 6379
+
+	// default database and user of the mysql service.
+	mysqlDatabase = "pipeline"
+	mysqlUser     = "pipeline"
+
+	// keys of the generated secret.
+	mysqlRootPasswordKey = "mysql-root-password"
+	mysqlPasswordKey     = "mysql-password"
+
+	// readiness probe period, in seconds.
+	probePeriod = 2
+)
+
+// SecretName returns the name of the generated secret holding the
+// service credentials of the pipeline.
+func SecretName(pipeline *v1alpha1.Pipeline) string {
+	return pipeline.Name + "-services"
+}
+
+// Secret returns a secret with generated credentials for the services
+// of the pipeline, or nil if no service requires credentials. The
+// secret is owned by the pipeline, and is created in the namespace of
+// the executor pod.
+func Secret(pipeline *v1alpha1.Pipeline) (*v1.Secret, error) {
+	return newSecret(pipeline, rand.Reader)
+}
+
+func newSecret(pipeline *v1alpha1.Pipeline, random io.Reader) (*v1.Secret, error) {
+	data := map[string][]byte{}
+	for _, service := range pipeline.Spec.Pipeline.Services {
+		if service.Type != v1alpha1.MySQL {
+			continue
+		}
+		for _, key := range []string{mysqlRootPasswordKey, mysqlPasswordKey} {
+			password, err := newPassword(random)
+			if err != nil {
+				return nil, err
+			}
+			data[key] = []byte(password)
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: SecretName(pipeline),
+			Labels: map[string]string{
+				pipelineLabel: pipeline.Name,
+			},
+			OwnerReferences: ownerReferences(pipeline),
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: data,
+	}, nil
+}
+
+// AddServices adds a sidecar container to the executor pod for each
+// service of the pipeline, and adds the connection environment of the
+// services to every other container of the pod. Variables already set
+// by a step are not overridden. The credentials are read from the
+// secret returned by Secret.
+func AddServices(pod *v1.PodSpec, pipeline *v1alpha1.Pipeline) error {
+	seen := map[v1alpha1.PipelineServiceType]bool{}
+	var sidecars []v1.Container
+	var env []v1.EnvVar
+	for _, service := range pipeline.Spec.Pipeline.Services {
+		if seen[service.Type] {
+			return fmt.Errorf("pipeline %s has more than one %s service", pipeline.Name, service.Type)
+		}
+		seen[service.Type] = true
+
+		var sidecar v1.Container
+		switch service.Type {
+		case v1alpha1.MySQL:
+			sidecar = mysqlContainer(pipeline, service)
+			env = append(env, mysqlEnv(pipeline, service)...)
+		case v1alpha1.Redis:
+			sidecar = redisContainer(service)
+			env = append(env, redisEnv()...)
+		default:
+			return fmt.Errorf("pipeline %s: unknown service type %q", pipeline.Name, service.Type)
+		}
+		sidecars = append(sidecars, sidecar)
+	}
+
+	for i := range pod.InitContainers {
+		addEnv(&pod.InitContainers[i], env)
+	}
+	for i := range pod.Containers {
+		addEnv(&pod.Containers[i], env)
+	}
+	pod.Containers = append(pod.Containers, sidecars...)
+	return nil
+}
+
+// returns the mysql sidecar container.
+func mysqlContainer(pipeline *v1alpha1.Pipeline, service v1alpha1.ServiceSideCar) v1.Container {
+	env := []v1.EnvVar{
+		secretEnv("MYSQL_ROOT_PASSWORD", pipeline, mysqlRootPasswordKey),
+		{Name: "MYSQL_DATABASE", Value: mysqlSetting(service, "MYSQL_DATABASE", mysqlDatabase)},
+		{Name: "MYSQL_USER", Value: mysqlSetting(service, "MYSQL_USER", mysqlUser)},
+		secretEnv("MYSQL_PASSWORD", pipeline, mysqlPasswordKey),
+	}
+	return v1.Container{
+		Name:           serviceName(service),
+		Image:          serviceImage(service, DefaultMySQLImage),
+		Env:            mergeEnv(env, service.EnvVars),
+		Resources:      service.Resources,
+		Ports:          []v1.ContainerPort{{Name: "mysql", ContainerPort: mysqlPort}},
+		ReadinessProbe: tcpProbe(mysqlPort),
+	}
+}
+
+// returns the connection environment of the mysql service.
+func mysqlEnv(pipeline *v1alpha1.Pipeline, service v1alpha1.ServiceSideCar) []v1.EnvVar {
+	return []v1.EnvVar{
+		{Name: "MYSQL_HOST", Value: serviceHost},
+		{Name: "MYSQL_PORT", Value: strconv.Itoa(mysqlPort)},
+		{Name: "MYSQL_DATABASE", Value: mysqlSetting(service, "MYSQL_DATABASE", mysqlDatabase)},
+		{Name: "MYSQL_USER", Value: mysqlSetting(service, "MYSQL_USER", mysqlUser)},
+		secretEnv("MYSQL_PASSWORD", pipeline, mysqlPasswordKey),
+	}
+}
+
+// returns the redis sidecar container.
+func redisContainer(service v1alpha1.ServiceSideCar) v1.Container {
+	return v1.Container{
+		Name:           serviceName(service),
+		Image:          serviceImage(service, DefaultRedisImage),
+		Env:            mergeEnv(nil, service.EnvVars),
+		Resources:      service.Resources,
+		Ports:          []v1.ContainerPort{{Name: "redis", ContainerPort: redisPort}},
+		ReadinessProbe: tcpProbe(redisPort),
+	}
+}
+
+// returns the connection environment of the redis service.
+func redisEnv() []v1.EnvVar {
+	return []v1.EnvVar{
+		{Name: "REDIS_HOST", Value: serviceHost},
+		{Name: "REDIS_PORT", Value: strconv.Itoa(redisPort)},
+		{Name: "REDIS_URL", Value: fmt.Sprintf("redis://%s:%d", serviceHost, redisPort)},
+	}
+}
+
+// helper function that returns the container name of the service,
+// which defaults to the service type.
+func serviceName(service v1alpha1.ServiceSideCar) string {
+	if service.Name != "" {
+		return service.Name
+	}
+	return strings.ToLower(string(service.Type))
+}
+
+// helper function that returns the service image, or the default
+// image of the service type.
+func serviceImage(service v1alpha1.ServiceSideCar, image string) string {
+	if service.Image != "" {
+		return service.Image
+	}
+	return image
+}
+
+// helper function that returns a mysql setting from the service
+// environment, or the default value.
+func mysqlSetting(service v1alpha1.ServiceSideCar, name, value string) string {
+	if v, ok := service.EnvVars[name]; ok {
+		return v
+	}
+	return value
+}
+
+// helper function that returns a variable read from the generated
+// secret of the pipeline.
+func secretEnv(name string, pipeline *v1alpha1.Pipeline, key string) v1.EnvVar {
+	return v1.EnvVar{
+		Name: name,
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: SecretName(pipeline)},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+// helper function that returns the variables, overridden by the
+// service environment, which is added in sorted order.
+func mergeEnv(env []v1.EnvVar, vars map[string]string) []v1.EnvVar {
+	var out []v1.EnvVar
+	for _, v := range env {
+		if _, ok := vars[v.Name]; !ok {
+			out = append(out, v)
+		}
+	}
+	var keys []string
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		out = append(out, v1.EnvVar{Name: k, Value: vars[k]})
+	}
+	return out
+}
+
+// helper function that adds the variables that are not already set to
+// the container environment.
+func addEnv(container *v1.Container, env []v1.EnvVar) {
+	set := map[string]bool{}
+	for _, v := range container.Env {
+		set[v.Name] = true
+	}
+	for _, v := range env {
+		if !set[v.Name] {
+			container.Env = append(container.Env, v)
+		}
+	}
+}
+
+// helper function that returns the owner references of the generated
+// resources of the pipeline.
+func ownerReferences(pipeline *v1alpha1.Pipeline) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{
+		APIVersion: v1alpha1.SchemeGroupVersion.String(),
+		Kind:       v1alpha1.PipelineKind,
+		Name:       pipeline.Name,
+		UID:        pipeline.UID,
+		Controller: &controller,
+	}}
+}
+
+// helper function that returns a tcp readiness probe of the port.
+func tcpProbe(port int) *v1.Probe {
+	return &v1.Probe{
+		Handler: v1.Handler{
+			TCPSocket: &v1.TCPSocketAction{
+				Port: intstr.FromInt(port),
+			},
+		},
+		PeriodSeconds: probePeriod,
+	}
+}
+
+// helper function that returns a random hex encoded password.
+func newPassword(random io.Reader) (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(random, b); err != nil {
+		return "", fmt.Errorf("cannot generate service password: %s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
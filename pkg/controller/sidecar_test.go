@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/marjoram/pipeline/apis/pipeline.cncd.io/v1alpha1"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newPipeline(services ...v1alpha1.ServiceSideCar) *v1alpha1.Pipeline {
+	return &v1alpha1.Pipeline{
+		ObjectMeta: metav1.ObjectMeta{Name: "build", UID: "uid"},
+		Spec: v1alpha1.PipelineSpec{
+			Pipeline: v1alpha1.DriverSpec{Services: services},
+		},
+	}
+}
+
+func TestSecret(t *testing.T) {
+	secret, err := Secret(newPipeline(v1alpha1.ServiceSideCar{Type: v1alpha1.MySQL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Name != "build-services" {
+		t.Errorf("Want secret build-services, got %s", secret.Name)
+	}
+	if owner := secret.OwnerReferences[0]; owner.Kind != "Pipeline" || owner.Name != "build" || owner.UID != "uid" {
+		t.Errorf("Want secret owned by the pipeline, got %v", owner)
+	}
+	root, password := secret.Data[mysqlRootPasswordKey], secret.Data[mysqlPasswordKey]
+	if len(root) != 32 || len(password) != 32 || bytes.Equal(root, password) {
+		t.Errorf("Want distinct generated passwords, got %q and %q", root, password)
+	}
+
+	secret, err = Secret(newPipeline(v1alpha1.ServiceSideCar{Type: v1alpha1.Redis}))
+	if err != nil || secret != nil {
+		t.Errorf("Want no secret without mysql service, got %v, %v", secret, err)
+	}
+
+	_, err = newSecret(newPipeline(v1alpha1.ServiceSideCar{Type: v1alpha1.MySQL}), strings.NewReader("short"))
+	if err == nil {
+		t.Errorf("Want password generation error")
+	}
+}
+
+func TestAddServices(t *testing.T) {
+	pipeline := newPipeline(
+		v1alpha1.ServiceSideCar{Type: v1alpha1.MySQL},
+		v1alpha1.ServiceSideCar{
+			Name: "cache",
+			Type: v1alpha1.Redis,
+			PipelinePodSpec: v1alpha1.PipelinePodSpec{
+				Image: "redis:4",
+			},
+		},
+	)
+	pod := &v1.PodSpec{
+		Containers: []v1.Container{
+			{Name: "step", Env: []v1.EnvVar{{Name: "REDIS_HOST", Value: "redis"}}},
+		},
+	}
+	if err := AddServices(pod, pipeline); err != nil {
+		t.Fatal(err)
+	}
+	if len(pod.Containers) != 3 {
+		t.Fatalf("Want step and two sidecars, got %d containers", len(pod.Containers))
+	}
+
+	mysql, redis := pod.Containers[1], pod.Containers[2]
+	if mysql.Name != "mysql" || mysql.Image != DefaultMySQLImage {
+		t.Errorf("Want default mysql sidecar, got %s %s", mysql.Name, mysql.Image)
+	}
+	if redis.Name != "cache" || redis.Image != "redis:4" {
+		t.Errorf("Want redis sidecar overrides, got %s %s", redis.Name, redis.Image)
+	}
+	if probe := mysql.ReadinessProbe; probe == nil || probe.TCPSocket.Port.IntValue() != 3306 {
+		t.Errorf("Want mysql readiness probe on port 3306, got %v", probe)
+	}
+	if probe := redis.ReadinessProbe; probe == nil || probe.TCPSocket.Port.IntValue() != 6379 {
+		t.Errorf("Want redis readiness probe on port 6379, got %v", probe)
+	}
+
+	env := map[string]v1.EnvVar{}
+	for _, v := range pod.Containers[0].Env {
+		env[v.Name] = v
+	}
+	for name, value := range map[string]string{
+		"MYSQL_HOST":     "127.0.0.1",
+		"MYSQL_PORT":     "3306",
+		"MYSQL_DATABASE": "pipeline",
+		"MYSQL_USER":     "pipeline",
+		"REDIS_HOST":     "redis",
+		"REDIS_URL":      "redis://127.0.0.1:6379",
+	} {
+		if got := env[name].Value; got != value {
+			t.Errorf("Want step variable %s=%s, got %q", name, value, got)
+		}
+	}
+	want := &v1.SecretKeySelector{
+		LocalObjectReference: v1.LocalObjectReference{Name: "build-services"},
+		Key:                  mysqlPasswordKey,
+	}
+	if got := env["MYSQL_PASSWORD"].ValueFrom; got == nil || !reflect.DeepEqual(got.SecretKeyRef, want) {
+		t.Errorf("Want step password from the generated secret, got %v", got)
+	}
+}
+
+func TestAddServicesOverride(t *testing.T) {
+	pipeline := newPipeline(v1alpha1.ServiceSideCar{
+		Type: v1alpha1.MySQL,
+		PipelinePodSpec: v1alpha1.PipelinePodSpec{
+			EnvVars: map[string]string{"MYSQL_DATABASE": "test"},
+		},
+	})
+	pod := &v1.PodSpec{Containers: []v1.Container{{Name: "step"}}}
+	if err := AddServices(pod, pipeline); err != nil {
+		t.Fatal(err)
+	}
+	for _, container := range pod.Containers {
+		var found bool
+		for _, v := range container.Env {
+			if v.Name == "MYSQL_DATABASE" {
+				if found || v.Value != "test" {
+					t.Errorf("%s: want a single MYSQL_DATABASE=test, got %v", container.Name, container.Env)
+				}
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: want MYSQL_DATABASE set", container.Name)
+		}
+	}
+}
+
+func TestAddServicesError(t *testing.T) {
+	tests := []struct {
+		services []v1alpha1.ServiceSideCar
+		err      string
+	}{
+		{
+			services: []v1alpha1.ServiceSideCar{{Type: "postgres"}},
+			err:      `pipeline build: unknown service type "postgres"`,
+		},
+		{
+			services: []v1alpha1.ServiceSideCar{{Type: v1alpha1.Redis}, {Type: v1alpha1.Redis}},
+			err:      "pipeline build has more than one cache service",
+		},
+	}
+	for _, test := range tests {
+		err := AddServices(&v1.PodSpec{}, newPipeline(test.services...))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("Want error %q, got %v", test.err, err)
+		}
+	}
+}
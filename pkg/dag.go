@@ -0,0 +1,168 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+// graph is the step dependency graph of a pipeline configuration.
+type graph struct {
+	// steps in declaration order.
+	steps []*backend.Step
+	// deps maps each step name to the names of the steps it
+	// depends on.
+	deps map[string][]string
+	// stages maps each step name to its stage.
+	stages map[string]*backend.Stage
+}
+
+// newGraph builds the dependency graph for the pipeline configuration.
+// A step that declares depends_on waits for the named steps only, while
+// a step that does not waits for every step in the previous stage. An
+// error is returned if a dependency cannot be resolved or the graph
+// contains a cycle.
+func newGraph(spec *backend.Config) (*graph, error) {
+	g := &graph{
+		deps:   map[string][]string{},
+		stages: map[string]*backend.Stage{},
+	}
+
+	// index steps by name and alias so that dependencies may
+	// reference either.
+	names := map[string]string{}
+	aliases := map[string]string{}
+	for _, stage := range spec.Stages {
+		for _, step := range stage.Steps {
+			if _, ok := names[step.Name]; ok {
+				return nil, fmt.Errorf("duplicate step name %q", step.Name)
+			}
+			names[step.Name] = step.Name
+			if step.Alias != "" {
+				aliases[step.Alias] = step.Name
+			}
+			g.steps = append(g.steps, step)
+			g.stages[step.Name] = stage
+		}
+	}
+
+	var prev []string
+	for _, stage := range spec.Stages {
+		var curr []string
+		for _, step := range stage.Steps {
+			curr = append(curr, step.Name)
+			if step.DependsOn == nil {
+				g.deps[step.Name] = prev
+				continue
+			}
+			for _, dep := range step.DependsOn {
+				name, ok := names[dep]
+				if !ok {
+					name, ok = aliases[dep]
+				}
+				if !ok {
+					return nil, fmt.Errorf("step %q depends on unknown step %q", step.Name, dep)
+				}
+				g.deps[step.Name] = append(g.deps[step.Name], name)
+			}
+		}
+		prev = curr
+	}
+
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// validate returns an error if the graph contains a cycle.
+func (g *graph) validate() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := map[string]int{}
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, prev := range path {
+				if prev == name {
+					cycle := append(path[i:], name)
+					return fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> "))
+				}
+			}
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range g.deps[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, step := range g.steps {
+		if err := visit(step.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FilterSteps returns a copy of the configuration with only the named
+// steps, matched by name or alias, and the detached service steps.
+// Dependencies on the removed steps are dropped. A step that loses all
+// of its dependencies keeps an empty depends_on, so that it does not
+// wait on the previous stage instead.
+func FilterSteps(config *backend.Config, names []string) *backend.Config {
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	kept := map[string]bool{}
+	filtered := *config
+	filtered.Stages = nil
+	for _, stage := range config.Stages {
+		copied := *stage
+		copied.Steps = nil
+		for _, step := range stage.Steps {
+			if step.Detached || selected[step.Name] || selected[step.Alias] {
+				copied.Steps = append(copied.Steps, step)
+				kept[step.Name] = true
+				kept[step.Alias] = true
+			}
+		}
+		if len(copied.Steps) != 0 {
+			filtered.Stages = append(filtered.Stages, &copied)
+		}
+	}
+
+	for _, stage := range filtered.Stages {
+		for i, step := range stage.Steps {
+			if len(step.DependsOn) == 0 {
+				continue
+			}
+			copied := *step
+			copied.DependsOn = []string{}
+			for _, dep := range step.DependsOn {
+				if kept[dep] {
+					copied.DependsOn = append(copied.DependsOn, dep)
+				}
+			}
+			stage.Steps[i] = &copied
+		}
+	}
+	return &filtered
+}
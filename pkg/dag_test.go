@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+func TestGraph(t *testing.T) {
+	spec := &backend.Config{
+		Stages: []*backend.Stage{
+			{Steps: []*backend.Step{{Name: "clone"}}},
+			{Steps: []*backend.Step{
+				{Name: "backend", DependsOn: []string{"clone"}},
+				{Name: "frontend", Alias: "web", DependsOn: []string{"clone"}},
+			}},
+			{Steps: []*backend.Step{{Name: "deploy"}}},
+			{Steps: []*backend.Step{{Name: "notify", DependsOn: []string{"web"}}}},
+		},
+	}
+	g, err := newGraph(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"clone":    nil,
+		"backend":  {"clone"},
+		"frontend": {"clone"},
+		"deploy":   {"backend", "frontend"},
+		"notify":   {"frontend"},
+	}
+	if !reflect.DeepEqual(g.deps, want) {
+		t.Errorf("Want dependencies %v, got %v", want, g.deps)
+	}
+}
+
+func TestGraphUnknown(t *testing.T) {
+	spec := &backend.Config{
+		Stages: []*backend.Stage{
+			{Steps: []*backend.Step{{Name: "build", DependsOn: []string{"clone"}}}},
+		},
+	}
+	_, err := newGraph(spec)
+	if err == nil {
+		t.Fatalf("Want error for unknown dependency")
+	}
+	got, want := err.Error(), `step "build" depends on unknown step "clone"`
+	if got != want {
+		t.Errorf("Want error message %q, got %q", want, got)
+	}
+}
+
+func TestGraphCycle(t *testing.T) {
+	spec := &backend.Config{
+		Stages: []*backend.Stage{
+			{Steps: []*backend.Step{
+				{Name: "a", DependsOn: []string{"c"}},
+				{Name: "b", DependsOn: []string{"a"}},
+				{Name: "c", DependsOn: []string{"b"}},
+			}},
+		},
+	}
+	_, err := newGraph(spec)
+	if err == nil {
+		t.Fatalf("Want error for dependency cycle")
+	}
+	got, want := err.Error(), "dependency cycle detected: a -> c -> b -> a"
+	if got != want {
+		t.Errorf("Want error message %q, got %q", want, got)
+	}
+}
+
+func TestGraphDuplicate(t *testing.T) {
+	spec := &backend.Config{
+		Stages: []*backend.Stage{
+			{Steps: []*backend.Step{{Name: "build"}}},
+			{Steps: []*backend.Step{{Name: "build"}}},
+		},
+	}
+	if _, err := newGraph(spec); err == nil {
+		t.Errorf("Want error for duplicate step name")
+	}
+}
+
+func TestFilterSteps(t *testing.T) {
+	spec := &backend.Config{
+		Stages: []*backend.Stage{
+			{Steps: []*backend.Step{{Name: "redis", Detached: true}}},
+			{Steps: []*backend.Step{{Name: "clone"}}},
+			{Steps: []*backend.Step{
+				{Name: "build", DependsOn: []string{"clone"}},
+				{Name: "test", Alias: "unit", DependsOn: []string{"clone", "build"}},
+			}},
+		},
+	}
+	filtered := FilterSteps(spec, []string{"build", "unit"})
+
+	var names []string
+	for _, stage := range filtered.Stages {
+		for _, step := range stage.Steps {
+			names = append(names, step.Name)
+		}
+	}
+	if want := []string{"redis", "build", "test"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Want steps %v, got %v", want, names)
+	}
+
+	// the build step lost its only dependency, and must not wait on
+	// the service stage instead.
+	g, err := newGraph(filtered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deps := g.deps["build"]; len(deps) != 0 {
+		t.Errorf("Want build without dependencies, got %v", deps)
+	}
+	if deps, want := g.deps["test"], []string{"build"}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("Want test dependencies %v, got %v", want, deps)
+	}
+	if got := spec.Stages[2].Steps[0].DependsOn; len(got) != 1 {
+		t.Errorf("Want original configuration unchanged, got %v", got)
+	}
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrSkip is used as a return value when container execution should be
+	// skipped at runtime. It is not returned as an error by any function.
+	ErrSkip = errors.New("Skipped")
+
+	// ErrCancel is used as a return value when the container execution receives
+	// a cancellation signal from the context.
+	ErrCancel = errors.New("Cancelled")
+)
+
+// An ExitError reports an unsuccessful exit.
+type ExitError struct {
+	Name string
+	Code int
+}
+
+// Error returns the error message in string format.
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("%s : exit code %d", e.Name, e.Code)
+}
+
+// An OomError reports the process received an OOMKill from the kernel.
+type OomError struct {
+	Name string
+	Code int
+}
+
+// Error returns the error message in string format.
+func (e *OomError) Error() string {
+	return fmt.Sprintf("%s : received oom kill", e.Name)
+}
+
+// A TimeoutError reports the process was killed after exceeding its
+// step or stage timeout.
+type TimeoutError struct {
+	Name    string
+	Timeout time.Duration
+}
+
+// Error returns the error message in string format.
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s : timeout after %s", e.Name, e.Timeout)
+}
+
+// A HealthError reports the detached step did not become ready before
+// its health check timeout.
+type HealthError struct {
+	Name    string
+	Timeout time.Duration
+	Err     error
+}
+
+// Error returns the error message in string format.
+func (e *HealthError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s : not ready after %s", e.Name, e.Timeout)
+	}
+	return fmt.Sprintf("%s : not ready after %s: %s", e.Name, e.Timeout, e.Err)
+}
+
+// A CacheError reports the cache step failed to restore or save the
+// cache.
+type CacheError struct {
+	Name string
+	Err  error
+}
+
+// Error returns the error message in string format.
+func (e *CacheError) Error() string {
+	return fmt.Sprintf("%s : %s", e.Name, e.Err)
+}
+
+// A ParseError reports an invalid pipeline configuration, and the json
+// path of the invalid value.
+type ParseError struct {
+	Path    string
+	Message string
+}
+
+// Error returns the error message in string format.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s : %s", e.Path, e.Message)
+}
@@ -1,7 +1,9 @@
 package pipeline
 
 import (
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestExitError(t *testing.T) {
@@ -24,3 +26,25 @@ func TestOomError(t *testing.T) {
 		t.Errorf("Want error message %q, got %q", want, got)
 	}
 }
+
+func TestTimeoutError(t *testing.T) {
+	err := TimeoutError{
+		Name:    "build",
+		Timeout: time.Minute,
+	}
+	got, want := err.Error(), "build : timeout after 1m0s"
+	if got != want {
+		t.Errorf("Want error message %q, got %q", want, got)
+	}
+}
+
+func TestCacheError(t *testing.T) {
+	err := CacheError{
+		Name: "restore_cache",
+		Err:  errors.New("engine does not support cache steps"),
+	}
+	got, want := err.Error(), "restore_cache : engine does not support cache steps"
+	if got != want {
+		t.Errorf("Want error message %q, got %q", want, got)
+	}
+}
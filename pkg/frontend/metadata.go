@@ -0,0 +1,119 @@
+// Package frontend defines the build metadata that is exposed to the
+// pipeline configuration and to every pipeline step.
+package frontend
+
+import (
+	"strconv"
+	"strings"
+)
+
+type (
+	// Metadata defines runtime metadata.
+	Metadata struct {
+		ID   string `json:"id,omitempty"`
+		Repo Repo   `json:"repo,omitempty"`
+		Curr Build  `json:"curr,omitempty"`
+		Prev Build  `json:"prev,omitempty"`
+		Job  Job    `json:"job,omitempty"`
+		Sys  System `json:"sys,omitempty"`
+	}
+
+	// Repo defines runtime metadata for a repository.
+	Repo struct {
+		Name    string `json:"name,omitempty"`
+		Link    string `json:"link,omitempty"`
+		Remote  string `json:"remote,omitempty"`
+		Private bool   `json:"private,omitempty"`
+	}
+
+	// Build defines runtime metadata for a build.
+	Build struct {
+		Number   int    `json:"number,omitempty"`
+		Created  int64  `json:"created,omitempty"`
+		Started  int64  `json:"started,omitempty"`
+		Finished int64  `json:"finished,omitempty"`
+		Status   string `json:"status,omitempty"`
+		Event    string `json:"event,omitempty"`
+		Link     string `json:"link,omitempty"`
+		Target   string `json:"target,omitempty"`
+		Commit   Commit `json:"commit,omitempty"`
+	}
+
+	// Commit defines runtime metadata for a commit.
+	Commit struct {
+		Sha     string   `json:"sha,omitempty"`
+		Ref     string   `json:"ref,omitempty"`
+		Refspec string   `json:"refspec,omitempty"`
+		Branch  string   `json:"branch,omitempty"`
+		Message string   `json:"message,omitempty"`
+		Author  Author   `json:"author,omitempty"`
+		Files   []string `json:"files,omitempty"`
+	}
+
+	// Author defines runtime metadata for a commit author.
+	Author struct {
+		Name   string `json:"name,omitempty"`
+		Email  string `json:"email,omitempty"`
+		Avatar string `json:"avatar,omitempty"`
+	}
+
+	// Job defines runtime metadata for a job.
+	Job struct {
+		Number int               `json:"number,omitempty"`
+		Matrix map[string]string `json:"matrix,omitempty"`
+	}
+
+	// System defines runtime metadata for a ci/cd system.
+	System struct {
+		Name string `json:"name,omitempty"`
+		Host string `json:"host,omitempty"`
+		Link string `json:"link,omitempty"`
+		Arch string `json:"arch,omitempty"`
+	}
+)
+
+// Environ returns the metadata as a map of environment variables. Each
+// CI_* variable is also exported with the DRONE_* prefix for
+// compatibility with existing plugins.
+func (m *Metadata) Environ() map[string]string {
+	params := map[string]string{
+		"CI_REPO":               m.Repo.Name,
+		"CI_REPO_NAME":          m.Repo.Name,
+		"CI_REPO_LINK":          m.Repo.Link,
+		"CI_REPO_REMOTE":        m.Repo.Remote,
+		"CI_REMOTE_URL":         m.Repo.Remote,
+		"CI_COMMIT_SHA":         m.Curr.Commit.Sha,
+		"CI_COMMIT_REF":         m.Curr.Commit.Ref,
+		"CI_COMMIT_BRANCH":      m.Curr.Commit.Branch,
+		"CI_COMMIT_MESSAGE":     m.Curr.Commit.Message,
+		"CI_COMMIT_AUTHOR":      m.Curr.Commit.Author.Name,
+		"CI_COMMIT_AUTHOR_NAME": m.Curr.Commit.Author.Name,
+		"CI_BUILD_NUMBER":       strconv.Itoa(m.Curr.Number),
+		"CI_BUILD_EVENT":        m.Curr.Event,
+		"CI_BUILD_CREATED":      strconv.FormatInt(m.Curr.Created, 10),
+		"CI_BUILD_STARTED":      strconv.FormatInt(m.Curr.Started, 10),
+		"CI_SYSTEM":             m.Sys.Name,
+		"CI_SYSTEM_NAME":        m.Sys.Name,
+		"CI_SYSTEM_LINK":        m.Sys.Link,
+		"CI_SYSTEM_ARCH":        m.Sys.Arch,
+	}
+	if len(m.Curr.Commit.Files) != 0 {
+		params["CI_COMMIT_FILES"] = strings.Join(m.Curr.Commit.Files, "\n")
+	}
+	if m.Curr.Commit.Author.Email != "" {
+		params["CI_COMMIT_AUTHOR_EMAIL"] = m.Curr.Commit.Author.Email
+	}
+	if m.Curr.Target != "" {
+		params["CI_BUILD_TARGET"] = m.Curr.Target
+	}
+	if m.Job.Number != 0 {
+		params["CI_JOB_NUMBER"] = strconv.Itoa(m.Job.Number)
+	}
+
+	environ := map[string]string{"CI": m.Sys.Name}
+	for k, v := range params {
+		environ[k] = v
+		environ["DRONE_"+strings.TrimPrefix(k, "CI_")] = v
+	}
+	return environ
+}
@@ -0,0 +1,40 @@
+package yaml
+
+import (
+	libcompose "github.com/docker/libcompose/yaml"
+)
+
+// Cache defines the cache section. The paths are restored from the
+// cache before the pipeline steps, and saved once the pipeline steps
+// succeed. The key is a template that may use the env and checksum
+// functions:
+//
+//	cache:
+//	  key: npm-{{ checksum "package-lock.json" }}
+//	  paths: [ node_modules ]
+//
+// The section may also be a list of paths, cached under the default key.
+type Cache struct {
+	Key   string
+	Paths []string
+}
+
+// UnmarshalYAML implements the Unmarshaller interface.
+func (c *Cache) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var paths libcompose.Stringorslice
+	if err := unmarshal(&paths); err == nil {
+		c.Paths = paths
+		return nil
+	}
+
+	out := struct {
+		Key   string
+		Paths libcompose.Stringorslice
+	}{}
+	if err := unmarshal(&out); err != nil {
+		return err
+	}
+	c.Key = out.Key
+	c.Paths = out.Paths
+	return nil
+}
@@ -0,0 +1,81 @@
+package yaml
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Clone defines the clone section. The section either lists the clone
+// steps, which replace the default clone step, or configures the
+// default clone step:
+//
+//	clone:
+//	  depth: 50
+//	  submodules: true
+//	  tags: true
+//	  ref: refs/heads/release
+//
+// The default clone step is disabled with the disable setting.
+type Clone struct {
+	Containers
+
+	Disable    bool
+	Depth      int
+	Submodules bool
+	Tags       bool
+	Ref        string
+}
+
+// cloneSettings are the settings of the default clone step, which are
+// distinguished from the clone steps by their scalar values.
+type cloneSettings struct {
+	Disable    bool   `yaml:"disable"`
+	Depth      int    `yaml:"depth"`
+	Submodules bool   `yaml:"submodules"`
+	Tags       bool   `yaml:"tags"`
+	Ref        string `yaml:"ref"`
+}
+
+// UnmarshalYAML implements the Unmarshaller interface.
+func (c *Clone) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	slice := yaml.MapSlice{}
+	if err := unmarshal(&slice); err != nil {
+		return err
+	}
+
+	steps := yaml.MapSlice{}
+	settings := yaml.MapSlice{}
+	for _, item := range slice {
+		if _, ok := item.Value.(yaml.MapSlice); ok {
+			steps = append(steps, item)
+			continue
+		}
+		switch fmt.Sprint(item.Key) {
+		case "disable", "depth", "submodules", "tags", "ref":
+			settings = append(settings, item)
+		default:
+			return fmt.Errorf("unknown clone setting %v", item.Key)
+		}
+	}
+
+	out, _ := yaml.Marshal(settings)
+	s := cloneSettings{}
+	if err := yaml.Unmarshal(out, &s); err != nil {
+		return err
+	}
+	c.Disable = s.Disable
+	c.Depth = s.Depth
+	c.Submodules = s.Submodules
+	c.Tags = s.Tags
+	c.Ref = s.Ref
+
+	if len(steps) == 0 {
+		return nil
+	}
+	if len(settings) != 0 && !(len(settings) == 1 && c.Disable) {
+		return fmt.Errorf("clone settings cannot be combined with clone steps")
+	}
+	out, _ = yaml.Marshal(steps)
+	return yaml.Unmarshal(out, &c.Containers)
+}
@@ -0,0 +1,217 @@
+// Package compiler compiles the pipeline configuration to the
+// intermediate representation executed by the pipeline runtime.
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/frontend"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml"
+)
+
+// Default workspace and prefix values.
+const (
+	DefaultPrefix        = "pipeline"
+	DefaultWorkspaceBase = "/pipeline"
+	DefaultWorkspacePath = "src"
+	DefaultCloneImage    = "plugins/git"
+	DefaultCacheImage    = "alpine"
+	DefaultCacheKey      = `{{ env "CI_REPO" }}-{{ env "CI_COMMIT_BRANCH" }}`
+)
+
+// Registry represents registry credentials.
+type Registry struct {
+	Hostname string
+	Username string
+	Password string
+	Email    string
+}
+
+// Secret represents a secret exposed to the steps that request it.
+type Secret struct {
+	Name  string
+	Value string
+}
+
+// Compiler compiles the yaml
+type Compiler struct {
+	prefix     string
+	base       string
+	path       string
+	clone      string
+	cache      string
+	env        map[string]string
+	volumes    []string
+	networks   []string
+	escalated  []string
+	registries []Registry
+	secrets    map[string]Secret
+	metadata   frontend.Metadata
+}
+
+// New creates a new Compiler with options.
+func New(opts ...Option) *Compiler {
+	compiler := &Compiler{
+		prefix:  DefaultPrefix,
+		base:    DefaultWorkspaceBase,
+		path:    DefaultWorkspacePath,
+		clone:   DefaultCloneImage,
+		cache:   DefaultCacheImage,
+		env:     map[string]string{},
+		secrets: map[string]Secret{},
+	}
+	for _, opt := range opts {
+		opt(compiler)
+	}
+	return compiler
+}
+
+// Compile compiles the YAML configuration to the pipeline intermediate
+// representation configuration format.
+func (c *Compiler) Compile(conf *yaml.Config) *backend.Config {
+	config := new(backend.Config)
+
+	// overrides the default workspace paths when specified
+	// in the YAML file.
+	workspace := yaml.Workspace{Base: c.base, Path: c.path}
+	if len(conf.Workspace.Base) != 0 {
+		workspace.Base = conf.Workspace.Base
+	}
+	if len(conf.Workspace.Path) != 0 {
+		workspace.Path = conf.Workspace.Path
+	}
+
+	// add default volume
+	config.Volumes = append(config.Volumes, &backend.Volume{
+		Name:   fmt.Sprintf("%s_default", c.prefix),
+		Driver: "local",
+	})
+
+	// add default network
+	config.Networks = append(config.Networks, &backend.Network{
+		Name:   fmt.Sprintf("%s_default", c.prefix),
+		Driver: "bridge",
+	})
+
+	// add clone steps, one stage per clone step.
+	for i, container := range c.cloneContainers(conf.Clone) {
+		name := fmt.Sprintf("%s_clone_%d", c.prefix, i)
+		stage := new(backend.Stage)
+		stage.Name = name
+		stage.Alias = container.Name
+		step := c.createProcess(name, container, "clone", workspace)
+		stage.Steps = append(stage.Steps, step)
+		config.Stages = append(config.Stages, stage)
+
+		// the default clone step checks out the head of the configured
+		// ref instead of the build commit.
+		if ref := conf.Clone.Ref; ref != "" && len(conf.Clone.Containers.Containers) == 0 {
+			for _, prefix := range []string{"CI_", "DRONE_"} {
+				step.Environment[prefix+"COMMIT_REF"] = ref
+				delete(step.Environment, prefix+"COMMIT_SHA")
+			}
+		}
+	}
+
+	// restore the cached paths once the workspace is cloned.
+	if len(conf.Cache.Paths) != 0 {
+		config.Stages = append(config.Stages, c.cacheStage("restore", conf.Cache, workspace))
+	}
+
+	// add services steps, which are started in a single stage
+	// and detached from the pipeline.
+	if len(conf.Services.Containers) != 0 {
+		stage := new(backend.Stage)
+		stage.Name = fmt.Sprintf("%s_services", c.prefix)
+		stage.Alias = "services"
+
+		for i, container := range conf.Services.Containers {
+			name := fmt.Sprintf("%s_services_%d", c.prefix, i)
+			stage.Steps = append(stage.Steps, c.createProcess(name, container, "services", workspace))
+		}
+		config.Stages = append(config.Stages, stage)
+	}
+
+	// add pipeline steps. steps in the same group are added
+	// to the same stage and executed in parallel.
+	var stage *backend.Stage
+	var group string
+	for i, container := range conf.Pipeline.Containers {
+		if stage == nil || group != container.Group || container.Group == "" {
+			group = container.Group
+
+			stage = new(backend.Stage)
+			stage.Name = fmt.Sprintf("%s_stage_%v", c.prefix, i)
+			stage.Alias = container.Name
+			config.Stages = append(config.Stages, stage)
+		}
+
+		name := fmt.Sprintf("%s_step_%d", c.prefix, i)
+		stage.Steps = append(stage.Steps, c.createProcess(name, container, "pipeline", workspace))
+	}
+
+	// save the cached paths once the pipeline steps succeed.
+	if len(conf.Cache.Paths) != 0 {
+		config.Stages = append(config.Stages, c.cacheStage("save", conf.Cache, workspace))
+	}
+
+	return config
+}
+
+// cacheStage returns the stage of the cache step, which restores or
+// saves the cached paths.
+func (c *Compiler) cacheStage(action string, conf yaml.Cache, workspace yaml.Workspace) *backend.Stage {
+	cache := &backend.Cache{
+		Action: action,
+		Key:    conf.Key,
+	}
+	if cache.Key == "" {
+		cache.Key = DefaultCacheKey
+	}
+	if action == "save" {
+		cache.Paths = conf.Paths
+	}
+
+	alias := action + "_cache"
+	name := fmt.Sprintf("%s_cache_%s", c.prefix, action)
+	container := &yaml.Container{
+		Name:  alias,
+		Image: c.cache,
+	}
+	step := c.createProcess(name, container, "cache", workspace)
+	step.Cache = cache
+	return &backend.Stage{
+		Name:  name,
+		Alias: alias,
+		Steps: []*backend.Step{step},
+	}
+}
+
+// cloneContainers returns the clone steps of the pipeline. The default
+// clone step is added unless the clone section defines its own steps,
+// or disables cloning.
+func (c *Compiler) cloneContainers(clone yaml.Clone) []*yaml.Container {
+	switch {
+	case clone.Disable:
+		return nil
+	case len(clone.Containers.Containers) != 0:
+		return clone.Containers.Containers
+	}
+
+	container := &yaml.Container{
+		Name:  "clone",
+		Image: c.clone,
+		Vargs: map[string]interface{}{},
+	}
+	if clone.Depth != 0 {
+		container.Vargs["depth"] = clone.Depth
+	}
+	if clone.Submodules {
+		container.Vargs["recursive"] = true
+	}
+	if clone.Tags {
+		container.Vargs["tags"] = true
+	}
+	return []*yaml.Container{container}
+}
@@ -0,0 +1,426 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/frontend"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/envsubst"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml/matrix"
+)
+
+var update = flag.Bool("update", false, "update the sample pipeline.json files")
+
+// TestSamples compiles each sample pipeline and compares the result
+// with the checked-in intermediate representation.
+func TestSamples(t *testing.T) {
+	files, err := filepath.Glob("../../../../samples/*/pipeline.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("Want sample pipelines, got none")
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := compileSample(data)
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+			continue
+		}
+
+		golden := filepath.Join(filepath.Dir(file), "pipeline.json")
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("%s: %s", golden, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: compiled configuration does not match %s", file, golden)
+		}
+	}
+}
+
+func TestCompileGroups(t *testing.T) {
+	conf, err := yaml.ParseString(`
+clone:
+  disable: true
+pipeline:
+  foo:
+    image: golang
+    group: build
+  bar:
+    image: golang
+    group: build
+  baz:
+    image: golang
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := New(WithPrefix("test")).Compile(conf)
+	if got, want := len(config.Stages), 2; got != want {
+		t.Fatalf("Want %d stages, got %d", want, got)
+	}
+	if got, want := len(config.Stages[0].Steps), 2; got != want {
+		t.Errorf("Want %d steps in the group stage, got %d", want, got)
+	}
+	if got, want := config.Stages[1].Steps[0].Name, "test_step_2"; got != want {
+		t.Errorf("Want step name %q, got %q", want, got)
+	}
+}
+
+func TestCompileServices(t *testing.T) {
+	conf, err := yaml.ParseString(`
+clone:
+  disable: true
+pipeline:
+  build:
+    image: golang
+    commands: [ go test ]
+services:
+  database:
+    image: mysql
+    healthcheck:
+      tcp: 3306
+      timeout: 30s
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := New().Compile(conf)
+	if got, want := len(config.Stages), 2; got != want {
+		t.Fatalf("Want %d stages, got %d", want, got)
+	}
+	service := config.Stages[0].Steps[0]
+	if !service.Detached {
+		t.Errorf("Want service step detached")
+	}
+	if got, want := service.Image, "mysql:latest"; got != want {
+		t.Errorf("Want image %q, got %q", want, got)
+	}
+	if got := service.Networks[0].Aliases; len(got) != 1 || got[0] != "database" {
+		t.Errorf("Want service network alias database, got %v", got)
+	}
+	if check := service.HealthCheck; check == nil || check.TCP != 3306 || check.Timeout != 30*time.Second {
+		t.Errorf("Want service health check on port 3306, got %v", check)
+	}
+	if service.WorkingDir != "" {
+		t.Errorf("Want no working directory for services, got %q", service.WorkingDir)
+	}
+	if got := config.Stages[1].Steps[0].Environment["CI_SCRIPT"]; got == "" {
+		t.Errorf("Want build script in the step environment")
+	}
+}
+
+func TestCompileShellVariables(t *testing.T) {
+	data, err := envsubst.EvalStrict(`
+clone:
+  disable: true
+pipeline:
+  build:
+    image: golang
+    commands:
+      - echo $${HOME} ${CI_COMMIT_SHA}
+`, envsubst.MapOf(map[string]string{"CI_COMMIT_SHA": "d0876d3"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := yaml.ParseString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := New().Compile(conf)
+	script, err := base64.StdEncoding.DecodeString(config.Stages[0].Steps[0].Environment["CI_SCRIPT"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\necho ${HOME} d0876d3\n"; !strings.Contains(string(script), want) {
+		t.Errorf("Want build script command %q, got %q", want, script)
+	}
+}
+
+func TestCompilePlugin(t *testing.T) {
+	conf, err := yaml.ParseString(`
+clone:
+  disable: true
+pipeline:
+  publish:
+    image: plugins/docker
+    repo: foo/bar
+    tags: [ latest, "1.0" ]
+    secrets: [ docker_password ]
+    when:
+      branch: master
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := New(
+		WithEscalated("plugins/docker"),
+		WithSecret(Secret{Name: "docker_password", Value: "correct-horse"}),
+	).Compile(conf)
+
+	step := config.Stages[0].Steps[0]
+	if !step.Privileged {
+		t.Errorf("Want escalated plugin privileged")
+	}
+	for k, v := range map[string]string{
+		"PLUGIN_REPO":     "foo/bar",
+		"PLUGIN_TAGS":     "latest,1.0",
+		"DOCKER_PASSWORD": "correct-horse",
+	} {
+		if got := step.Environment[k]; got != v {
+			t.Errorf("Want %s=%q, got %q", k, v, got)
+		}
+	}
+	if step.When == nil || len(step.When.Branch.Include) != 1 || step.When.Branch.Include[0] != "master" {
+		t.Errorf("Want branch constraint master, got %v", step.When)
+	}
+}
+
+func TestCompileClone(t *testing.T) {
+	conf, err := yaml.ParseString(`
+workspace:
+  base: /go
+  path: src/github.com/drone/envsubst
+clone:
+  depth: 50
+  submodules: true
+  tags: true
+pipeline:
+  build:
+    image: golang
+    commands: [ go build ]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := New(
+		WithMetadata(sampleMetadata),
+		WithCloneImage("plugins/git:linux-arm"),
+	).Compile(conf)
+	if got, want := len(config.Stages), 2; got != want {
+		t.Fatalf("Want %d stages, got %d", want, got)
+	}
+
+	clone := config.Stages[0].Steps[0]
+	if got, want := clone.Name, "pipeline_clone_0"; got != want {
+		t.Errorf("Want clone step name %q, got %q", want, got)
+	}
+	if got, want := clone.Alias, "clone"; got != want {
+		t.Errorf("Want clone step alias %q, got %q", want, got)
+	}
+	if got, want := clone.Image, "plugins/git:linux-arm"; got != want {
+		t.Errorf("Want clone image %q, got %q", want, got)
+	}
+	for k, v := range map[string]string{
+		"PLUGIN_DEPTH":     "50",
+		"PLUGIN_RECURSIVE": "true",
+		"PLUGIN_TAGS":      "true",
+		"DRONE_COMMIT_SHA": sampleMetadata.Curr.Commit.Sha,
+	} {
+		if got := clone.Environment[k]; got != v {
+			t.Errorf("Want %s=%q, got %q", k, v, got)
+		}
+	}
+
+	// every step shares the workspace volume, and runs in the
+	// workspace directory.
+	for _, stage := range config.Stages {
+		step := stage.Steps[0]
+		if got, want := step.WorkingDir, "/go/src/github.com/drone/envsubst"; got != want {
+			t.Errorf("Want %s working directory %q, got %q", step.Alias, want, got)
+		}
+		if got, want := step.Volumes[0], "pipeline_default:/go"; got != want {
+			t.Errorf("Want %s workspace volume %q, got %q", step.Alias, want, got)
+		}
+	}
+}
+
+func TestCompileCache(t *testing.T) {
+	conf, err := yaml.ParseString(`
+clone:
+  disable: true
+cache:
+  key: npm-{{ checksum "package-lock.json" }}
+  paths: [ node_modules, .npm ]
+pipeline:
+  build:
+    image: node
+    commands: [ npm install ]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := New(WithCacheImage("busybox")).Compile(conf)
+
+	var aliases []string
+	for _, stage := range config.Stages {
+		aliases = append(aliases, stage.Alias)
+	}
+	if want := []string{"restore_cache", "build", "save_cache"}; !reflect.DeepEqual(aliases, want) {
+		t.Fatalf("Want stages %v, got %v", want, aliases)
+	}
+
+	restore, save := config.Stages[0].Steps[0], config.Stages[2].Steps[0]
+	key := `npm-{{ checksum "package-lock.json" }}`
+	if want := (&backend.Cache{Action: "restore", Key: key}); !reflect.DeepEqual(restore.Cache, want) {
+		t.Errorf("Want restore cache %v, got %v", want, restore.Cache)
+	}
+	if want := (&backend.Cache{Action: "save", Key: key, Paths: []string{"node_modules", ".npm"}}); !reflect.DeepEqual(save.Cache, want) {
+		t.Errorf("Want save cache %v, got %v", want, save.Cache)
+	}
+	if got, want := save.Name, "pipeline_cache_save"; got != want {
+		t.Errorf("Want save step name %q, got %q", want, got)
+	}
+	if got, want := save.Image, "busybox:latest"; got != want {
+		t.Errorf("Want cache image %q, got %q", want, got)
+	}
+	if got, want := save.WorkingDir, "/pipeline/src"; got != want {
+		t.Errorf("Want cache step in the workspace %q, got %q", want, got)
+	}
+
+	// a list of paths is cached under the default key.
+	conf, err = yaml.ParseString("clone:\n  disable: true\ncache: [ node_modules ]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = New().Compile(conf)
+	if len(config.Stages) != 2 || config.Stages[1].Steps[0].Cache.Key != DefaultCacheKey {
+		t.Errorf("Want cache steps with the default key")
+	}
+}
+
+func TestCompileCloneRef(t *testing.T) {
+	conf, err := yaml.ParseString(`
+clone:
+  ref: refs/heads/release
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := New(WithMetadata(sampleMetadata)).Compile(conf).Stages[0].Steps[0]
+	for _, prefix := range []string{"CI_", "DRONE_"} {
+		if got, want := clone.Environment[prefix+"COMMIT_REF"], "refs/heads/release"; got != want {
+			t.Errorf("Want %sCOMMIT_REF %q, got %q", prefix, want, got)
+		}
+		if got, ok := clone.Environment[prefix+"COMMIT_SHA"]; ok {
+			t.Errorf("Want no %sCOMMIT_SHA when checking out a ref, got %q", prefix, got)
+		}
+	}
+}
+
+func TestCompileCloneOverride(t *testing.T) {
+	tests := []struct {
+		conf   string
+		stages []string
+	}{
+		{
+			conf:   "pipeline:\n  build:\n    image: golang\n",
+			stages: []string{"clone", "build"},
+		},
+		{
+			conf:   "clone:\n  disable: true\npipeline:\n  build:\n    image: golang\n",
+			stages: []string{"build"},
+		},
+		{
+			conf:   "clone:\n  git:\n    image: plugins/git\n  lfs:\n    image: plugins/git-lfs\npipeline:\n  build:\n    image: golang\n",
+			stages: []string{"git", "lfs", "build"},
+		},
+	}
+	for _, test := range tests {
+		conf, err := yaml.ParseString(test.conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stages []string
+		for _, stage := range New().Compile(conf).Stages {
+			stages = append(stages, stage.Alias)
+		}
+		if !reflect.DeepEqual(stages, test.stages) {
+			t.Errorf("Want stages %v, got %v", test.stages, stages)
+		}
+	}
+
+	for _, conf := range []string{
+		"clone:\n  depht: 50\n",
+		"clone:\n  depth: 50\n  git:\n    image: plugins/git\n",
+	} {
+		if _, err := yaml.ParseString(conf); err == nil {
+			t.Errorf("Want error parsing %q", conf)
+		}
+	}
+}
+
+func compileSample(data []byte) ([]byte, error) {
+	jobs, err := matrix.Expand(data, func(data []byte, axis matrix.Axis) (*backend.Config, error) {
+		conf, err := yaml.ParseBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		metadata := sampleMetadata
+		metadata.Job.Matrix = axis
+		return New(WithMetadata(metadata)).Compile(conf), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if len(jobs) == 1 && jobs[0].Axis == nil {
+		out, err = json.MarshalIndent(jobs[0].Config, "", "  ")
+	} else {
+		out, err = json.MarshalIndent(jobs, "", "  ")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+var sampleMetadata = frontend.Metadata{
+	Repo: frontend.Repo{
+		Name:   "drone/envsubst",
+		Link:   "https://github.com/drone/envsubst",
+		Remote: "https://github.com/drone/envsubst.git",
+	},
+	Curr: frontend.Build{
+		Number:  6,
+		Created: 1486119586,
+		Started: 1486119585,
+		Event:   "push",
+		Commit: frontend.Commit{
+			Sha:     "d0876d3176965f9552a611cbd56e24a9264355e6",
+			Ref:     "refs/heads/master",
+			Branch:  "master",
+			Message: "added a few more test cases for escaping behavior",
+			Author:  frontend.Author{Name: "bradrydzewski"},
+		},
+	},
+	Sys: frontend.System{
+		Name: "pipec",
+		Link: "https://github.com/cncd/pipec",
+		Arch: "linux/amd64",
+	},
+}
@@ -0,0 +1,288 @@
+package compiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml"
+)
+
+func (c *Compiler) createProcess(name string, container *yaml.Container, section string, workspace yaml.Workspace) *backend.Step {
+	var (
+		detached   bool
+		workingdir string
+
+		workspaceBase = workspace.Base
+		workspacePath = path.Join(workspace.Base, workspace.Path)
+
+		privileged  = container.Privileged
+		entrypoint  = container.Entrypoint
+		command     = container.Command
+		image       = expandImage(container.Image)
+		environment = map[string]string{}
+		networks    = []backend.Conn{
+			{Name: fmt.Sprintf("%s_default", c.prefix)},
+		}
+		volumes = []string{
+			fmt.Sprintf("%s_default:%s", c.prefix, workspaceBase),
+		}
+	)
+
+	// services are reached by the other steps using their
+	// name as the network alias.
+	if section == "services" {
+		detached = true
+		networks[0].Aliases = []string{container.Name}
+	} else {
+		detached = container.Detached
+		workingdir = workspacePath
+	}
+
+	for _, network := range c.networks {
+		networks = append(networks, backend.Conn{Name: network})
+	}
+	volumes = append(volumes, container.Volumes...)
+	volumes = append(volumes, c.volumes...)
+
+	// plugin settings are passed to the container using the
+	// PLUGIN_ environment variable prefix.
+	if !isService(section) && len(container.Commands) == 0 {
+		for k, v := range container.Vargs {
+			environment["PLUGIN_"+strings.ToUpper(k)] = toEnv(v)
+		}
+	}
+
+	for k, v := range container.Environment {
+		environment[k] = v
+	}
+	for _, name := range container.Secrets {
+		if secret, ok := c.secrets[name]; ok {
+			environment[strings.ToUpper(name)] = secret.Value
+		}
+	}
+	for k, v := range c.metadata.Environ() {
+		environment[k] = v
+	}
+	for k, v := range c.env {
+		environment[k] = v
+	}
+	environment["CI_WORKSPACE"] = workspacePath
+	environment["DRONE_WORKSPACE"] = workspacePath
+
+	if !isService(section) && len(container.Commands) != 0 {
+		entrypoint = []string{"/bin/sh", "-c"}
+		command = []string{"echo $CI_SCRIPT | base64 -d | /bin/sh -e"}
+		environment["CI_SCRIPT"] = generateScriptPosix(container.Commands)
+		environment["HOME"] = "/root"
+		environment["SHELL"] = "/bin/sh"
+	}
+
+	if matchImage(container.Image, c.escalated...) {
+		privileged = true
+	}
+
+	authConfig := backend.Auth{
+		Username: container.AuthConfig.Username,
+		Password: container.AuthConfig.Password,
+		Email:    container.AuthConfig.Email,
+	}
+	for _, registry := range c.registries {
+		if matchHostname(image, registry.Hostname) {
+			authConfig.Username = registry.Username
+			authConfig.Password = registry.Password
+			authConfig.Email = registry.Email
+			break
+		}
+	}
+
+	return &backend.Step{
+		Name:         name,
+		Alias:        container.Name,
+		Image:        image,
+		Pull:         container.Pull,
+		Detached:     detached,
+		Privileged:   privileged,
+		WorkingDir:   workingdir,
+		Environment:  environment,
+		Labels:       container.Labels,
+		Entrypoint:   entrypoint,
+		Command:      command,
+		ExtraHosts:   container.ExtraHosts,
+		Volumes:      volumes,
+		Tmpfs:        container.Tmpfs,
+		Devices:      container.Devices,
+		Networks:     networks,
+		DNS:          container.DNS,
+		DNSSearch:    container.DNSSearch,
+		MemSwapLimit: int64(container.MemSwapLimit),
+		MemLimit:     int64(container.MemLimit),
+		ShmSize:      int64(container.ShmSize),
+		CPUQuota:     int64(container.CPUQuota),
+		CPUShares:    int64(container.CPUShares),
+		CPUSet:       container.CPUSet,
+		AuthConfig:   authConfig,
+		OnSuccess:    container.Constraints.Status.IsEmpty() || container.Constraints.Status.Includes("success"),
+		OnFailure:    container.Constraints.Status.Includes("failure"),
+		DependsOn:    container.DependsOn,
+		When:         toWhen(container.Constraints),
+		HealthCheck:  toHealthCheck(container.HealthCheck, detached),
+		NetworkMode:  container.NetworkMode,
+		IpcMode:      container.IpcMode,
+		Sysctls:      container.Sysctls,
+	}
+}
+
+// toWhen converts the step constraints that are evaluated by the
+// runtime. The status constraint is converted to the step OnSuccess
+// and OnFailure flags instead.
+func toWhen(from yaml.Constraints) *backend.When {
+	when := &backend.When{
+		Branch: toConstraint(from.Branch),
+		Event:  toConstraint(from.Event),
+		Ref:    toConstraint(from.Ref),
+		Paths:  toConstraint(from.Paths),
+	}
+	for name, constraint := range from.Environment {
+		if when.Environment == nil {
+			when.Environment = map[string]backend.Constraint{}
+		}
+		when.Environment[name] = toConstraint(constraint)
+	}
+	if reflect.DeepEqual(when, &backend.When{}) {
+		return nil
+	}
+	return when
+}
+
+// toHealthCheck converts the readiness probe of a detached step, which
+// is ignored for the other steps.
+func toHealthCheck(from *yaml.HealthCheck, detached bool) *backend.HealthCheck {
+	if from == nil || !detached {
+		return nil
+	}
+	check := &backend.HealthCheck{
+		TCP:      from.TCP,
+		Exec:     from.Exec,
+		Interval: from.Interval,
+		Timeout:  from.Timeout,
+	}
+	if from.HTTP != nil {
+		check.HTTP = &backend.HTTPGet{
+			Port: from.HTTP.Port,
+			Path: from.HTTP.Path,
+		}
+	}
+	return check
+}
+
+func toConstraint(from yaml.Constraint) backend.Constraint {
+	return backend.Constraint{
+		Include: from.Include,
+		Exclude: from.Exclude,
+	}
+}
+
+// toEnv converts a plugin setting to its environment variable value.
+// Lists are joined with commas, and maps are encoded as json.
+func toEnv(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case bool:
+		return strconv.FormatBool(t)
+	case int:
+		return strconv.Itoa(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case []interface{}:
+		var parts []string
+		for _, s := range t {
+			parts = append(parts, toEnv(s))
+		}
+		return strings.Join(parts, ",")
+	case nil:
+		return ""
+	default:
+		out, _ := json.Marshal(toJSON(t))
+		return string(out)
+	}
+}
+
+// toJSON converts the yaml map types, which use interface keys, to
+// types that can be encoded as json.
+func toJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		out := map[string]interface{}{}
+		for k, v := range t {
+			out[fmt.Sprint(k)] = toJSON(v)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, v := range t {
+			out[i] = toJSON(v)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func isService(section string) bool {
+	return section == "services"
+}
+
+// expandImage returns the fully qualified image name, adding the
+// latest tag if the image is not tagged.
+func expandImage(name string) string {
+	ref, err := reference.ParseNamed(name)
+	if err != nil {
+		return name
+	}
+	if _, ok := ref.(reference.Canonical); ok {
+		return name
+	}
+	return reference.EnsureTagged(ref).String()
+}
+
+// matchImage returns true if the image matches one of the given
+// images, ignoring the tag.
+func matchImage(from string, to ...string) bool {
+	from = trimImage(from)
+	for _, match := range to {
+		if from == trimImage(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHostname returns true if the image hostname matches the
+// registry hostname. Images without a hostname are pulled from
+// the docker hub.
+func matchHostname(image, hostname string) bool {
+	host := "docker.io"
+	if parts := strings.SplitN(image, "/", 2); len(parts) == 2 {
+		if strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost" {
+			host = parts[0]
+		}
+	}
+	return host == hostname
+}
+
+// trimImage returns the image name without the tag or digest.
+func trimImage(name string) string {
+	ref, err := reference.ParseNamed(name)
+	if err != nil {
+		return name
+	}
+	return reference.TrimNamed(ref).String()
+}
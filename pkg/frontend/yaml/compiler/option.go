@@ -0,0 +1,120 @@
+package compiler
+
+import (
+	"github.com/marjoram/pipeline/pipeline/frontend"
+)
+
+// Option configures a compiler option.
+type Option func(*Compiler)
+
+// WithPrefix configures the compiler with the prefix. The prefix is
+// used to prefix container, volume and network names to avoid
+// collision at runtime.
+func WithPrefix(prefix string) Option {
+	return func(compiler *Compiler) {
+		compiler.prefix = prefix
+	}
+}
+
+// WithWorkspace configures the compiler with the workspace base
+// and path. The workspace base is a volume created at runtime and
+// mounted into all containers in the pipeline. The base and path
+// are joined to provide the working directory for all build and
+// plugin steps in the pipeline.
+func WithWorkspace(base, path string) Option {
+	return func(compiler *Compiler) {
+		compiler.base = base
+		compiler.path = path
+	}
+}
+
+// WithCloneImage configures the compiler with the image of the default
+// clone step, which is added to pipelines that do not define their own
+// clone steps.
+func WithCloneImage(image string) Option {
+	return func(compiler *Compiler) {
+		compiler.clone = image
+	}
+}
+
+// WithCacheImage configures the compiler with the image of the cache
+// steps. The engine may create the step container to copy the cached
+// paths in and out of the workspace, but never runs the image.
+func WithCacheImage(image string) Option {
+	return func(compiler *Compiler) {
+		compiler.cache = image
+	}
+}
+
+// WithMetadata configures the compiler with the repository, build
+// and system metadata. The metadata is used to generate the CI_*
+// and DRONE_* environment variables of every step.
+func WithMetadata(metadata frontend.Metadata) Option {
+	return func(compiler *Compiler) {
+		compiler.metadata = metadata
+	}
+}
+
+// WithEnviron configures the compiler with environment variables
+// added to every step in the pipeline.
+func WithEnviron(env map[string]string) Option {
+	return func(compiler *Compiler) {
+		for k, v := range env {
+			compiler.env[k] = v
+		}
+	}
+}
+
+// WithNetrc configures the compiler with netrc authentication
+// credentials added by default to every container in the pipeline.
+func WithNetrc(username, password, machine string) Option {
+	return WithEnviron(
+		map[string]string{
+			"CI_NETRC_USERNAME": username,
+			"CI_NETRC_PASSWORD": password,
+			"CI_NETRC_MACHINE":  machine,
+		},
+	)
+}
+
+// WithVolumes configures the compiler with default volumes that
+// are mounted to each container in the pipeline.
+func WithVolumes(volumes ...string) Option {
+	return func(compiler *Compiler) {
+		compiler.volumes = volumes
+	}
+}
+
+// WithNetworks configures the compiler with additional networks
+// to be connected to pipeline containers.
+func WithNetworks(networks ...string) Option {
+	return func(compiler *Compiler) {
+		compiler.networks = networks
+	}
+}
+
+// WithEscalated configures the compiler to automatically execute
+// images as privileged containers if they match the given list.
+func WithEscalated(images ...string) Option {
+	return func(compiler *Compiler) {
+		compiler.escalated = images
+	}
+}
+
+// WithRegistry configures the compiler with registry credentials
+// that should be used to download images.
+func WithRegistry(registries ...Registry) Option {
+	return func(compiler *Compiler) {
+		compiler.registries = registries
+	}
+}
+
+// WithSecret configures the compiler with external secrets that are
+// exposed to steps which request them by name.
+func WithSecret(secrets ...Secret) Option {
+	return func(compiler *Compiler) {
+		for _, secret := range secrets {
+			compiler.secrets[secret.Name] = secret
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package yaml
+
+import (
+	libcompose "github.com/docker/libcompose/yaml"
+)
+
+type (
+	// Constraints defines a set of runtime constraints.
+	Constraints struct {
+		Branch      Constraint
+		Event       Constraint
+		Ref         Constraint
+		Status      Constraint
+		Paths       Constraint
+		Environment map[string]Constraint
+	}
+
+	// Constraint defines a runtime constraint.
+	Constraint struct {
+		Include []string
+		Exclude []string
+	}
+)
+
+// IsEmpty returns true if the constraint does not include or exclude
+// any value.
+func (c *Constraint) IsEmpty() bool {
+	return len(c.Include) == 0 && len(c.Exclude) == 0
+}
+
+// Includes returns true if the string is explicitly included.
+func (c *Constraint) Includes(v string) bool {
+	for _, pattern := range c.Include {
+		if pattern == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Excludes returns true if the string is explicitly excluded.
+func (c *Constraint) Excludes(v string) bool {
+	for _, pattern := range c.Exclude {
+		if pattern == v {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalYAML unmarshals the constraint, which is either a single
+// value, a list of values, or a map with include and exclude lists.
+func (c *Constraint) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var out1 = struct {
+		Include libcompose.Stringorslice
+		Exclude libcompose.Stringorslice
+	}{}
+
+	var out2 libcompose.Stringorslice
+
+	unmarshal(&out1)
+	unmarshal(&out2)
+
+	c.Exclude = out1.Exclude
+	c.Include = append(
+		out1.Include,
+		out2...,
+	)
+	return nil
+}
@@ -0,0 +1,100 @@
+package yaml
+
+import (
+	"fmt"
+	"time"
+
+	libcompose "github.com/docker/libcompose/yaml"
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// AuthConfig defines registry authentication credentials.
+	AuthConfig struct {
+		Username string
+		Password string
+		Email    string
+	}
+
+	// Containers denotes an ordered collection of containers.
+	Containers struct {
+		Containers []*Container
+	}
+
+	// HealthCheck defines the readiness probe of a service, or of a
+	// detached step, using one of the tcp, exec and http probes.
+	HealthCheck struct {
+		TCP      int                `yaml:"tcp,omitempty"`
+		Exec     libcompose.Command `yaml:"exec,omitempty"`
+		HTTP     *HTTPGet           `yaml:"http,omitempty"`
+		Interval time.Duration      `yaml:"interval,omitempty"`
+		Timeout  time.Duration      `yaml:"timeout,omitempty"`
+	}
+
+	// HTTPGet defines an http readiness probe.
+	HTTPGet struct {
+		Port int    `yaml:"port,omitempty"`
+		Path string `yaml:"path,omitempty"`
+	}
+
+	// Container defines a container.
+	Container struct {
+		AuthConfig    AuthConfig                `yaml:"auth_config,omitempty"`
+		CapAdd        []string                  `yaml:"cap_add,omitempty"`
+		CapDrop       []string                  `yaml:"cap_drop,omitempty"`
+		Command       libcompose.Command        `yaml:"command,omitempty"`
+		Commands      libcompose.Stringorslice  `yaml:"commands,omitempty"`
+		CPUQuota      libcompose.StringorInt    `yaml:"cpu_quota,omitempty"`
+		CPUSet        string                    `yaml:"cpuset,omitempty"`
+		CPUShares     libcompose.StringorInt    `yaml:"cpu_shares,omitempty"`
+		DependsOn     libcompose.Stringorslice  `yaml:"depends_on,omitempty"`
+		Detached      bool                      `yaml:"detach,omitempty"`
+		Devices       []string                  `yaml:"devices,omitempty"`
+		DNS           libcompose.Stringorslice  `yaml:"dns,omitempty"`
+		DNSSearch     libcompose.Stringorslice  `yaml:"dns_search,omitempty"`
+		Entrypoint    libcompose.Command        `yaml:"entrypoint,omitempty"`
+		Environment   libcompose.SliceorMap     `yaml:"environment,omitempty"`
+		ExtraHosts    []string                  `yaml:"extra_hosts,omitempty"`
+		Group         string                    `yaml:"group,omitempty"`
+		HealthCheck   *HealthCheck              `yaml:"healthcheck,omitempty"`
+		Image         string                    `yaml:"image,omitempty"`
+		IpcMode       string                    `yaml:"ipc_mode,omitempty"`
+		Labels        libcompose.SliceorMap     `yaml:"labels,omitempty"`
+		MemLimit      libcompose.MemStringorInt `yaml:"mem_limit,omitempty"`
+		MemSwapLimit  libcompose.MemStringorInt `yaml:"memswap_limit,omitempty"`
+		MemSwappiness libcompose.MemStringorInt `yaml:"mem_swappiness,omitempty"`
+		Name          string                    `yaml:"name,omitempty"`
+		NetworkMode   string                    `yaml:"network_mode,omitempty"`
+		Privileged    bool                      `yaml:"privileged,omitempty"`
+		Pull          bool                      `yaml:"pull,omitempty"`
+		Secrets       libcompose.Stringorslice  `yaml:"secrets,omitempty"`
+		ShmSize       libcompose.MemStringorInt `yaml:"shm_size,omitempty"`
+		Sysctls       libcompose.SliceorMap     `yaml:"sysctls,omitempty"`
+		Tmpfs         []string                  `yaml:"tmpfs,omitempty"`
+		Volumes       []string                  `yaml:"volumes,omitempty"`
+		Constraints   Constraints               `yaml:"when,omitempty"`
+		Vargs         map[string]interface{}    `yaml:",inline"`
+	}
+)
+
+// UnmarshalYAML implements the Unmarshaller interface.
+func (c *Containers) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	slice := yaml.MapSlice{}
+	if err := unmarshal(&slice); err != nil {
+		return err
+	}
+
+	for _, s := range slice {
+		container := Container{}
+		out, _ := yaml.Marshal(s.Value)
+
+		if err := yaml.Unmarshal(out, &container); err != nil {
+			return err
+		}
+		if container.Name == "" {
+			container.Name = fmt.Sprintf("%v", s.Key)
+		}
+		c.Containers = append(c.Containers, &container)
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+// Package envsubst substitutes ${VAR} expressions in the pipeline
+// configuration with the value of the named variable, using a subset of
+// the bash parameter expansion syntax. A literal $ is written as $$.
+//
+// The supported expressions are:
+//
+//	${VAR}               value of VAR
+//	${#VAR}              length of VAR
+//	${VAR:-default}      default if VAR is unset or empty
+//	${VAR-default}       default if VAR is unset
+//	${VAR:+alternate}    alternate if VAR is set and not empty
+//	${VAR+alternate}     alternate if VAR is set
+//	${VAR:?message}      error if VAR is unset or empty
+//	${VAR?message}       error if VAR is unset
+//	${VAR#prefix}        remove the shortest matching prefix
+//	${VAR##prefix}       remove the longest matching prefix
+//	${VAR%suffix}        remove the shortest matching suffix
+//	${VAR%%suffix}       remove the longest matching suffix
+//	${VAR/old/new}       replace the first match of old
+//	${VAR//old/new}      replace every match of old
+//	${VAR^} ${VAR^^}     uppercase the first or every character
+//	${VAR,} ${VAR,,}     lowercase the first or every character
+//	${VAR:offset}        substring starting at offset
+//	${VAR:offset:length} substring of length starting at offset
+//
+// Prefix, suffix and replacement patterns may use the * and ? glob
+// wildcards. Bare $VAR references are not substituted, since they are
+// commonly evaluated by the shell when the step runs.
+package envsubst
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Mapping returns the value of the named variable, and whether the
+// variable is set.
+type Mapping func(name string) (string, bool)
+
+// MapOf returns a Mapping that looks up variables in the map.
+func MapOf(env map[string]string) Mapping {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+// Environ returns a Mapping that looks up variables in each of the maps,
+// in order of precedence, and then in the process environment.
+func Environ(envs ...map[string]string) Mapping {
+	return func(name string) (string, bool) {
+		for _, env := range envs {
+			if v, ok := env[name]; ok {
+				return v, true
+			}
+		}
+		return os.LookupEnv(name)
+	}
+}
+
+// EvalEnviron substitutes the expressions in s using the Environ of the
+// maps. It behaves like EvalStrict if strict is true, and like Eval
+// otherwise.
+func EvalEnviron(s string, strict bool, envs ...map[string]string) (string, error) {
+	return eval(s, Environ(envs...), strict)
+}
+
+// Eval substitutes the expressions in s. Unset variables are replaced
+// with an empty string.
+func Eval(s string, mapping Mapping) (string, error) {
+	return eval(s, mapping, false)
+}
+
+// EvalStrict substitutes the expressions in s. An error is returned if
+// an expression references an unset variable without a default value.
+func EvalStrict(s string, mapping Mapping) (string, error) {
+	return eval(s, mapping, true)
+}
+
+func eval(s string, mapping Mapping, strict bool) (string, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			buf.WriteByte('$')
+			i++
+		case '{':
+			end := closingBrace(s, i+2)
+			if end == -1 {
+				return "", fmt.Errorf("envsubst: missing closing brace in %q", s[i:])
+			}
+			v, err := expand(s[i+2:end], mapping, strict)
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(v)
+			i = end
+		default:
+			buf.WriteByte('$')
+		}
+	}
+	return buf.String(), nil
+}
+
+// closingBrace returns the index of the brace that closes the
+// expression starting at i, accounting for nested expressions.
+func closingBrace(s string, i int) int {
+	depth := 1
+	for ; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '$':
+			i++
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+var nameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+
+// expand evaluates a single expression, without the enclosing braces.
+func expand(expr string, mapping Mapping, strict bool) (string, error) {
+	if len(expr) > 1 && expr[0] == '#' && nameRegexp.FindString(expr[1:]) == expr[1:] {
+		v, ok := mapping(expr[1:])
+		if !ok && strict {
+			return "", unsetError(expr[1:])
+		}
+		return strconv.Itoa(utf8.RuneCountInString(v)), nil
+	}
+
+	name := nameRegexp.FindString(expr)
+	if name == "" {
+		return "", badSubstitution(expr)
+	}
+	op := expr[len(name):]
+	value, set := mapping(name)
+
+	// evaluates the word following the operator, which may itself
+	// contain expressions.
+	word := func(n int) (string, error) {
+		return eval(op[n:], mapping, strict)
+	}
+
+	switch {
+	case op == "":
+		if !set && strict {
+			return "", unsetError(name)
+		}
+		return value, nil
+	case strings.HasPrefix(op, ":-"):
+		if value == "" {
+			return word(2)
+		}
+		return value, nil
+	case strings.HasPrefix(op, "-"):
+		if !set {
+			return word(1)
+		}
+		return value, nil
+	case strings.HasPrefix(op, ":+"):
+		if value != "" {
+			return word(2)
+		}
+		return "", nil
+	case strings.HasPrefix(op, "+"):
+		if set {
+			return word(1)
+		}
+		return "", nil
+	case strings.HasPrefix(op, ":?"), strings.HasPrefix(op, "?"):
+		n := strings.Index(op, "?") + 1
+		if !set || (n == 2 && value == "") {
+			msg, err := word(n)
+			if err != nil {
+				return "", err
+			}
+			if msg == "" {
+				msg = "parameter null or not set"
+			}
+			return "", fmt.Errorf("envsubst: %s: %s", name, msg)
+		}
+		return value, nil
+	}
+
+	if !set && strict {
+		return "", unsetError(name)
+	}
+
+	switch {
+	case strings.HasPrefix(op, "#"):
+		longest := strings.HasPrefix(op, "##")
+		pattern, err := word(operatorLen(longest))
+		if err != nil {
+			return "", err
+		}
+		return trimPrefix(value, pattern, longest), nil
+	case strings.HasPrefix(op, "%"):
+		longest := strings.HasPrefix(op, "%%")
+		pattern, err := word(operatorLen(longest))
+		if err != nil {
+			return "", err
+		}
+		return trimSuffix(value, pattern, longest), nil
+	case strings.HasPrefix(op, "/"):
+		all := strings.HasPrefix(op, "//")
+		parts := strings.SplitN(op[operatorLen(all):], "/", 2)
+		pattern, err := eval(parts[0], mapping, strict)
+		if err != nil {
+			return "", err
+		}
+		var replacement string
+		if len(parts) == 2 {
+			if replacement, err = eval(parts[1], mapping, strict); err != nil {
+				return "", err
+			}
+		}
+		return replace(value, pattern, replacement, all), nil
+	case op == "^^":
+		return strings.ToUpper(value), nil
+	case op == ",,":
+		return strings.ToLower(value), nil
+	case op == "^":
+		return mapFirst(value, unicode.ToUpper), nil
+	case op == ",":
+		return mapFirst(value, unicode.ToLower), nil
+	case strings.HasPrefix(op, ":"):
+		return substring(expr, value, op[1:])
+	default:
+		return "", badSubstitution(expr)
+	}
+}
+
+// trimPrefix removes the shortest or longest prefix matching the glob
+// pattern.
+func trimPrefix(value, pattern string, longest bool) string {
+	re := compileGlob(pattern)
+	if longest {
+		for i := len(value); i >= 0; i-- {
+			if re.MatchString(value[:i]) {
+				return value[i:]
+			}
+		}
+		return value
+	}
+	for i := 0; i <= len(value); i++ {
+		if re.MatchString(value[:i]) {
+			return value[i:]
+		}
+	}
+	return value
+}
+
+// trimSuffix removes the shortest or longest suffix matching the glob
+// pattern.
+func trimSuffix(value, pattern string, longest bool) string {
+	re := compileGlob(pattern)
+	if longest {
+		for i := 0; i <= len(value); i++ {
+			if re.MatchString(value[i:]) {
+				return value[:i]
+			}
+		}
+		return value
+	}
+	for i := len(value); i >= 0; i-- {
+		if re.MatchString(value[i:]) {
+			return value[:i]
+		}
+	}
+	return value
+}
+
+// replace replaces the first, or every, longest match of the glob
+// pattern.
+func replace(value, pattern, replacement string, all bool) string {
+	if pattern == "" {
+		return value
+	}
+	re := regexp.MustCompile(globToRegexp(pattern))
+	if all {
+		return re.ReplaceAllLiteralString(value, replacement)
+	}
+	loc := re.FindStringIndex(value)
+	if loc == nil {
+		return value
+	}
+	return value[:loc[0]] + replacement + value[loc[1]:]
+}
+
+// substring returns the substring at the offset, and of the optional
+// length. A negative offset counts from the end of the value, and must
+// be separated from the colon by a space.
+func substring(expr, value, args string) (string, error) {
+	parts := strings.SplitN(args, ":", 2)
+	offset, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return "", badSubstitution(expr)
+	}
+	runes := []rune(value)
+	if offset < 0 {
+		offset += len(runes)
+	}
+	if offset < 0 || offset > len(runes) {
+		return "", nil
+	}
+	runes = runes[offset:]
+	if len(parts) == 2 {
+		length, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return "", badSubstitution(expr)
+		}
+		if length < 0 {
+			length += len(runes)
+			if length < 0 {
+				return "", badSubstitution(expr)
+			}
+		}
+		if length < len(runes) {
+			runes = runes[:length]
+		}
+	}
+	return string(runes), nil
+}
+
+// operatorLen returns the length of an operator that is either a single
+// character, or the same character repeated.
+func operatorLen(double bool) int {
+	if double {
+		return 2
+	}
+	return 1
+}
+
+func mapFirst(value string, f func(rune) rune) string {
+	r, size := utf8.DecodeRuneInString(value)
+	if size == 0 {
+		return value
+	}
+	return string(f(r)) + value[size:]
+}
+
+// compileGlob returns a regular expression that matches the whole
+// string against the glob pattern.
+func compileGlob(pattern string) *regexp.Regexp {
+	return regexp.MustCompile("^" + globToRegexp(pattern) + "$")
+}
+
+// globToRegexp converts the * and ? glob wildcards to a regular
+// expression, quoting every other character.
+func globToRegexp(pattern string) string {
+	var buf bytes.Buffer
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			buf.WriteString("(?s:.*)")
+		case '?':
+			buf.WriteString("(?s:.)")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return buf.String()
+}
+
+func unsetError(name string) error {
+	return fmt.Errorf("envsubst: variable %s is not set", name)
+}
+
+func badSubstitution(expr string) error {
+	return fmt.Errorf("envsubst: bad substitution ${%s}", expr)
+}
@@ -0,0 +1,123 @@
+package envsubst
+
+import (
+	"os"
+	"testing"
+)
+
+var testEnv = MapOf(map[string]string{
+	"CI_COMMIT_SHA":    "d0876d3176965f9552a611cbd56e24a9264355e6",
+	"CI_COMMIT_REF":    "refs/tags/v1.2.3",
+	"CI_COMMIT_BRANCH": "feature/envsubst",
+	"CI_REPO_NAME":     "drone/envsubst",
+	"EMPTY":            "",
+	"NAME":             "pipeline",
+})
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"no expressions", "no expressions"},
+		{"${CI_COMMIT_SHA}", "d0876d3176965f9552a611cbd56e24a9264355e6"},
+		{"plugins/docker:${NAME}-${NAME}", "plugins/docker:pipeline-pipeline"},
+		{"${UNSET}", ""},
+		{"$NAME ${NAME}", "$NAME pipeline"},
+		{"$$", "$"},
+		{"$${NAME}", "${NAME}"},
+		{"echo $$HOME", "echo $HOME"},
+		{"price: 5$", "price: 5$"},
+		{"${#NAME}", "8"},
+		{"${UNSET:-default}", "default"},
+		{"${EMPTY:-default}", "default"},
+		{"${EMPTY-default}", ""},
+		{"${UNSET-default}", "default"},
+		{"${UNSET:-${NAME}}", "pipeline"},
+		{"${UNSET:-${UNSET:-nested}}", "nested"},
+		{"${NAME:+alternate}", "alternate"},
+		{"${EMPTY:+alternate}", ""},
+		{"${EMPTY+alternate}", "alternate"},
+		{"${CI_COMMIT_REF#refs/tags/}", "v1.2.3"},
+		{"${CI_COMMIT_REF##*/}", "v1.2.3"},
+		{"${CI_COMMIT_REF#*/}", "tags/v1.2.3"},
+		{"${CI_COMMIT_REF%.*}", "refs/tags/v1.2"},
+		{"${CI_COMMIT_REF%%.*}", "refs/tags/v1"},
+		{"${CI_COMMIT_BRANCH/e/E}", "fEature/envsubst"},
+		{"${CI_COMMIT_BRANCH//e/E}", "fEaturE/Envsubst"},
+		{"${CI_COMMIT_BRANCH//e}", "fatur/nvsubst"},
+		{"${NAME^}", "Pipeline"},
+		{"${NAME^^}", "PIPELINE"},
+		{"${CI_COMMIT_BRANCH,,}", "feature/envsubst"},
+		{"${CI_COMMIT_SHA:0:8}", "d0876d31"},
+		{"${NAME:4}", "line"},
+		{"${NAME: -4}", "line"},
+		{"${NAME:0:-4}", "pipe"},
+		{"${NAME:20}", ""},
+	}
+	for _, test := range tests {
+		got, err := Eval(test.in, testEnv)
+		if err != nil {
+			t.Errorf("%q: %s", test.in, err)
+			continue
+		}
+		if got != test.out {
+			t.Errorf("%q: want %q, got %q", test.in, test.out, got)
+		}
+	}
+}
+
+func TestEvalStrict(t *testing.T) {
+	if _, err := EvalStrict("${UNSET}", testEnv); err == nil {
+		t.Errorf("Want error for unset variable")
+	}
+	if _, err := EvalStrict("${UNSET##*/}", testEnv); err == nil {
+		t.Errorf("Want error for unset variable with a pattern")
+	}
+	for _, in := range []string{"${EMPTY}", "${UNSET:-default}", "${UNSET-}", "${UNSET:+alternate}", "$UNSET", "$${UNSET}"} {
+		if _, err := EvalStrict(in, testEnv); err != nil {
+			t.Errorf("%q: want no error, got %s", in, err)
+		}
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	for _, in := range []string{
+		"${NAME",
+		"${}",
+		"${1NAME}",
+		"${NAME!}",
+		"${NAME:x}",
+		"${UNSET:?must be set}",
+		"${EMPTY:?}",
+		"${UNSET?}",
+	} {
+		if _, err := Eval(in, testEnv); err == nil {
+			t.Errorf("%q: want error", in)
+		}
+	}
+	if _, err := Eval("${EMPTY?}", testEnv); err != nil {
+		t.Errorf("Want no error for empty variable with ?, got %s", err)
+	}
+}
+
+func TestEvalEnviron(t *testing.T) {
+	os.Setenv("ENVSUBST_TEST", "process")
+	defer os.Unsetenv("ENVSUBST_TEST")
+
+	got, err := EvalEnviron(
+		"${A} ${B} ${ENVSUBST_TEST} ${ENVSUBST_TEST_UNSET:-default} $${HOME}",
+		true,
+		map[string]string{"A": "axis"},
+		map[string]string{"A": "metadata", "B": "metadata"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "axis metadata process default ${HOME}"; got != want {
+		t.Errorf("Want %q, got %q", want, got)
+	}
+	if _, err := EvalEnviron("${ENVSUBST_TEST_UNSET}", true); err == nil {
+		t.Errorf("Want error for unset variable")
+	}
+}
@@ -0,0 +1,438 @@
+// Package include merges included files and expands step templates in
+// the pipeline configuration, before it is compiled.
+//
+// Included files are listed in the top level include section, and are
+// merged in order before the including file, which overrides the steps
+// and settings of its includes:
+//
+//	include:
+//	  - ci/templates.yml
+//	  - configmap://ci-templates/go.yml
+//
+// Step templates are defined in the templates section, and are used by
+// steps that name them in the template key. The template parameters are
+// declared with their default values in the params key, are overridden
+// by the with key of the step, and replace ${param} references in the
+// template. Parameters without a default value are required:
+//
+//	templates:
+//	  go-test:
+//	    image: golang:${go_version}
+//	    commands: [ go test ${packages} ]
+//	    params:
+//	      go_version: 1.9
+//	      packages:
+//
+//	pipeline:
+//	  test:
+//	    template: go-test
+//	    with:
+//	      packages: ./...
+//
+// The keys of the step override the keys of the template.
+package include
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Error reports an invalid include or template, and the file and yaml
+// path it originates from.
+type Error struct {
+	File    string
+	Path    string
+	Message string
+}
+
+// Error returns the error message in string format.
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.File, e.Path, e.Message)
+}
+
+// sections are the top level sections that are merged by key, and
+// whose steps may use templates.
+var sections = []string{"clone", "pipeline", "services"}
+
+// Option configures a resolver option.
+type Option func(*Resolver)
+
+// WithFiles configures the resolver to include local files under the
+// root directory.
+func WithFiles(root string) Option {
+	return func(r *Resolver) {
+		r.files = FileLoader(root)
+	}
+}
+
+// WithConfigMaps configures the resolver to include files from
+// ConfigMaps, using the configmap://<name>/<key> format.
+func WithConfigMaps(loader Loader) Option {
+	return func(r *Resolver) {
+		r.configmaps = loader
+	}
+}
+
+// Resolver resolves includes and templates.
+type Resolver struct {
+	files      Loader
+	configmaps Loader
+}
+
+// New creates a new Resolver with options.
+func New(opts ...Option) *Resolver {
+	r := new(Resolver)
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Resolve merges the included files and expands the step templates of
+// the configuration loaded from the origin file. The configuration is
+// returned unchanged if it does not use includes or templates.
+func (r *Resolver) Resolve(origin string, data []byte) ([]byte, error) {
+	doc := yaml.MapSlice{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, &Error{File: origin, Message: err.Error()}
+	}
+	if lookup(doc, "include") == nil && lookup(doc, "templates") == nil && !usesTemplates(doc) {
+		return data, nil
+	}
+
+	s := &state{
+		resolver: r,
+		origins:  map[string]string{},
+	}
+	doc, err := s.resolve(origin, doc, []string{origin})
+	if err != nil {
+		return nil, err
+	}
+	doc, err = s.expand(doc)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// state holds the origins of a single Resolve invocation.
+type state struct {
+	resolver *Resolver
+	// origins maps the yaml path of each step and template to the
+	// file it is defined in.
+	origins map[string]string
+}
+
+// resolve merges the included files of the document. The stack holds
+// the chain of files including the document, to detect cycles.
+func (s *state) resolve(origin string, doc yaml.MapSlice, stack []string) (yaml.MapSlice, error) {
+	names, err := stringList(lookup(doc, "include"))
+	if err != nil {
+		return nil, &Error{File: origin, Path: "include", Message: err.Error()}
+	}
+
+	merged := yaml.MapSlice{}
+	for _, name := range names {
+		name = resolveName(origin, name)
+		for _, prev := range stack {
+			if prev == name {
+				return nil, &Error{
+					File:    origin,
+					Path:    "include",
+					Message: fmt.Sprintf("include cycle %s", strings.Join(append(stack, name), " -> ")),
+				}
+			}
+		}
+
+		data, err := s.load(name)
+		if err != nil {
+			return nil, &Error{File: origin, Path: "include", Message: err.Error()}
+		}
+		included := yaml.MapSlice{}
+		if err := yaml.Unmarshal(data, &included); err != nil {
+			return nil, &Error{File: name, Message: err.Error()}
+		}
+		included, err = s.resolve(name, included, append(stack, name))
+		if err != nil {
+			return nil, err
+		}
+		merged = merge(merged, included)
+	}
+
+	local := remove(doc, "include")
+	for _, section := range append(sections, "templates") {
+		entries, _ := lookup(local, section).(yaml.MapSlice)
+		for _, entry := range entries {
+			s.origins[section+"."+fmt.Sprint(entry.Key)] = origin
+		}
+	}
+	return merge(merged, local), nil
+}
+
+func (s *state) load(name string) ([]byte, error) {
+	loader := s.resolver.files
+	if strings.HasPrefix(name, ConfigMapScheme) {
+		loader = s.resolver.configmaps
+	}
+	if loader == nil {
+		return nil, fmt.Errorf("%s: %s", ErrUnsupported, name)
+	}
+	return loader.Load(name)
+}
+
+// expand expands the step templates, and removes the templates section
+// from the document.
+func (s *state) expand(doc yaml.MapSlice) (yaml.MapSlice, error) {
+	templates, ok := lookup(doc, "templates").(yaml.MapSlice)
+	if !ok && lookup(doc, "templates") != nil {
+		return nil, &Error{File: s.origins["templates"], Path: "templates", Message: "templates must be a mapping"}
+	}
+	doc = remove(doc, "templates")
+
+	for i, item := range doc {
+		section := fmt.Sprint(item.Key)
+		entries, ok := item.Value.(yaml.MapSlice)
+		if !ok || !isSection(section) {
+			continue
+		}
+		expanded := make(yaml.MapSlice, len(entries))
+		for j, entry := range entries {
+			path := section + "." + fmt.Sprint(entry.Key)
+			step, ok := entry.Value.(yaml.MapSlice)
+			if ok && lookup(step, "template") != nil {
+				var err error
+				if step, err = s.expandStep(path, step, templates, nil, nil); err != nil {
+					return nil, err
+				}
+			}
+			expanded[j] = yaml.MapItem{Key: entry.Key, Value: valueOr(step, entry.Value)}
+		}
+		doc[i].Value = expanded
+	}
+	return doc, nil
+}
+
+// expandStep merges the step with the template it names. The stack
+// holds the chain of templates being expanded, to detect cycles, and
+// the inherited parameters of a template that extends another template
+// override the defaults of the parameters both declare.
+func (s *state) expandStep(path string, step, templates yaml.MapSlice, stack []string, inherited map[string]interface{}) (yaml.MapSlice, error) {
+	fail := func(format string, args ...interface{}) error {
+		origin := s.origins[path]
+		if len(stack) != 0 {
+			origin = s.origins["templates."+stack[len(stack)-1]]
+			path = "templates." + stack[len(stack)-1]
+		}
+		return &Error{File: origin, Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+
+	name, ok := lookup(step, "template").(string)
+	if !ok {
+		return nil, fail("template must be a string")
+	}
+	for _, prev := range stack {
+		if prev == name {
+			return nil, fail("template cycle %s", strings.Join(append(stack, name), " -> "))
+		}
+	}
+	tmpl, ok := lookup(templates, name).(yaml.MapSlice)
+	if !ok {
+		return nil, fail("template %q is not defined", name)
+	}
+
+	// the parameter defaults are overridden by the step parameters,
+	// and parameters without a value are required.
+	params, ok := lookup(tmpl, "params").(yaml.MapSlice)
+	if !ok && lookup(tmpl, "params") != nil {
+		return nil, fail("params of template %q must be a mapping", name)
+	}
+	values := map[string]interface{}{}
+	for _, param := range params {
+		key := fmt.Sprint(param.Key)
+		values[key] = param.Value
+		if value, ok := inherited[key]; ok && value != nil {
+			values[key] = value
+		}
+	}
+	with, ok := lookup(step, "with").(yaml.MapSlice)
+	if !ok && lookup(step, "with") != nil {
+		return nil, fail("with must be a mapping")
+	}
+	for _, param := range with {
+		key := fmt.Sprint(param.Key)
+		if _, ok := values[key]; !ok {
+			return nil, fail("template %q does not declare parameter %q", name, key)
+		}
+		values[key] = param.Value
+	}
+	var missing []string
+	for key, value := range values {
+		if value == nil {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return nil, fail("template %q requires parameters %s", name, strings.Join(missing, ", "))
+	}
+
+	body := substitute(remove(tmpl, "params"), values).(yaml.MapSlice)
+	if lookup(body, "template") != nil {
+		var err error
+		if body, err = s.expandStep(path, body, templates, append(stack, name), values); err != nil {
+			return nil, err
+		}
+	}
+	return merge(body, remove(remove(step, "template"), "with")), nil
+}
+
+// merge returns the base document overridden by the keys of over. The
+// keys of the sections are merged, keeping the order of the base.
+func merge(base, over yaml.MapSlice) yaml.MapSlice {
+	out := append(yaml.MapSlice{}, base...)
+	for _, item := range over {
+		key := fmt.Sprint(item.Key)
+		i := index(out, key)
+		if i == -1 {
+			out = append(out, item)
+			continue
+		}
+		prev, ok1 := out[i].Value.(yaml.MapSlice)
+		next, ok2 := item.Value.(yaml.MapSlice)
+		if ok1 && ok2 && (isSection(key) || key == "templates") {
+			out[i].Value = mergeEntries(prev, next)
+		} else {
+			out[i].Value = item.Value
+		}
+	}
+	return out
+}
+
+// mergeEntries replaces the entries of base with the entries of over
+// with the same key, and appends the other entries.
+func mergeEntries(base, over yaml.MapSlice) yaml.MapSlice {
+	out := append(yaml.MapSlice{}, base...)
+	for _, item := range over {
+		if i := index(out, fmt.Sprint(item.Key)); i != -1 {
+			out[i] = item
+		} else {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// substitute replaces the ${param} references in the strings of the
+// value. A string that is a single reference is replaced by the value
+// of the parameter, which need not be a string.
+func substitute(v interface{}, params map[string]interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		for key, value := range params {
+			ref := "${" + key + "}"
+			if t == ref {
+				return value
+			}
+			t = strings.Replace(t, ref, fmt.Sprint(value), -1)
+		}
+		return t
+	case yaml.MapSlice:
+		out := make(yaml.MapSlice, len(t))
+		for i, item := range t {
+			out[i] = yaml.MapItem{Key: item.Key, Value: substitute(item.Value, params)}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			out[i] = substitute(item, params)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// usesTemplates returns true if a step of the document names a template.
+func usesTemplates(doc yaml.MapSlice) bool {
+	for _, section := range sections {
+		entries, _ := lookup(doc, section).(yaml.MapSlice)
+		for _, entry := range entries {
+			if step, ok := entry.Value.(yaml.MapSlice); ok && lookup(step, "template") != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isSection(key string) bool {
+	for _, section := range sections {
+		if section == key {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList returns the value as a list of strings, which may also be
+// written as a single string.
+func stringList(v interface{}) ([]string, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{t}, nil
+	case []interface{}:
+		var out []string
+		for _, item := range t {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("include must be a list of strings")
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("include must be a list of strings")
+	}
+}
+
+func valueOr(step yaml.MapSlice, v interface{}) interface{} {
+	if step == nil {
+		return v
+	}
+	return step
+}
+
+func lookup(slice yaml.MapSlice, key string) interface{} {
+	if i := index(slice, key); i != -1 {
+		return slice[i].Value
+	}
+	return nil
+}
+
+func index(slice yaml.MapSlice, key string) int {
+	for i, item := range slice {
+		if fmt.Sprint(item.Key) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func remove(slice yaml.MapSlice, key string) yaml.MapSlice {
+	out := yaml.MapSlice{}
+	for _, item := range slice {
+		if fmt.Sprint(item.Key) != key {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,309 @@
+package include
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResolveUnchanged(t *testing.T) {
+	data := []byte("pipeline:\n  test:\n    image: golang\n")
+	got, err := New().Resolve("pipeline.yml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("Want configuration without includes unchanged, got %s", got)
+	}
+}
+
+func TestResolveFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"ci/base.yml": `
+workspace:
+  base: /go
+pipeline:
+  build:
+    image: golang
+    commands: [ go build ]
+  test:
+    image: golang
+    commands: [ go test ]
+`,
+		"pipeline.yml": `
+include: ci/base.yml
+pipeline:
+  test:
+    image: golang:1.9
+    commands: [ go test -race ]
+  publish:
+    image: plugins/docker
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	got := resolveFile(t, New(WithFiles(dir)), dir, "pipeline.yml")
+	want := parse(t, `
+workspace:
+  base: /go
+pipeline:
+  build:
+    image: golang
+    commands: [ go build ]
+  test:
+    image: golang:1.9
+    commands: [ go test -race ]
+  publish:
+    image: plugins/docker
+`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Want merged configuration\n%s\ngot\n%s", marshal(want), marshal(got))
+	}
+}
+
+func TestResolveNested(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"ci/a.yml":     "include: b.yml\npipeline:\n  a:\n    image: a\n",
+		"ci/b.yml":     "pipeline:\n  b:\n    image: b\n",
+		"pipeline.yml": "include: [ ci/a.yml ]\npipeline:\n  c:\n    image: c\n",
+	})
+	defer os.RemoveAll(dir)
+	got := resolveFile(t, New(WithFiles(dir)), dir, "pipeline.yml")
+	want := parse(t, "pipeline:\n  b:\n    image: b\n  a:\n    image: a\n  c:\n    image: c\n")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Want nested includes merged in order, got\n%s", marshal(got))
+	}
+}
+
+func TestResolveConfigMaps(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "ci-templates", Namespace: "ci"},
+		Data: map[string]string{
+			"go.yml":     "include: common.yml\ntemplates:\n  go-test:\n    image: golang\n    commands: [ go test ]\n",
+			"common.yml": "workspace:\n  base: /go\n",
+		},
+	})
+	resolver := New(WithConfigMaps(ConfigMapLoader(client, "ci")))
+
+	data := []byte("include: configmap://ci-templates/go.yml\npipeline:\n  test:\n    template: go-test\n")
+	out, err := resolver.Resolve("pipeline.yml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := parse(t, "workspace:\n  base: /go\npipeline:\n  test:\n    image: golang\n    commands: [ go test ]\n")
+	if got := parse(t, string(out)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want configuration from configmaps, got\n%s", out)
+	}
+
+	_, err = resolver.Resolve("pipeline.yml", []byte("include: configmap://ci-templates/missing.yml\n"))
+	if err == nil || !strings.Contains(err.Error(), "does not have key missing.yml") {
+		t.Errorf("Want missing key error, got %v", err)
+	}
+}
+
+func TestResolveTemplates(t *testing.T) {
+	data := []byte(`
+templates:
+  go-test:
+    image: golang:${go_version}
+    commands: [ "go test ${packages}" ]
+    environment:
+      CGO_ENABLED: ${cgo}
+    params:
+      go_version: 1.9
+      packages:
+      cgo: 0
+  go-race:
+    template: go-test
+    commands: [ "go test -race ${packages}" ]
+    params:
+      packages: ./...
+
+pipeline:
+  test:
+    template: go-test
+    with:
+      packages: ./pkg/...
+      cgo: 1
+  race:
+    template: go-race
+    when:
+      event: push
+`)
+	out, err := New().Resolve("pipeline.yml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := parse(t, `
+pipeline:
+  test:
+    image: golang:1.9
+    commands: [ "go test ./pkg/..." ]
+    environment:
+      CGO_ENABLED: 1
+  race:
+    image: golang:1.9
+    commands: [ "go test -race ./..." ]
+    environment:
+      CGO_ENABLED: 0
+    when:
+      event: push
+`)
+	if got := parse(t, string(out)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want expanded templates\n%s\ngot\n%s", marshal(want), out)
+	}
+}
+
+func TestResolveAnchors(t *testing.T) {
+	data := []byte(`
+templates:
+  go: &go
+    image: golang
+    params: {}
+pipeline:
+  build:
+    <<: *go
+    commands: [ go build ]
+  test:
+    template: go
+`)
+	out, err := New().Resolve("pipeline.yml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "commands:\n    - go build") || strings.Contains(string(out), "template") {
+		t.Errorf("Want anchors and templates resolved, got\n%s", out)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.yml":         "include: b.yml\n",
+		"b.yml":         "include: a.yml\n",
+		"invalid.yml":   "pipeline: [\n",
+		"templates.yml": "templates:\n  go:\n    image: golang:${version}\n    params:\n      version:\n",
+	})
+	defer os.RemoveAll(dir)
+	resolver := New(WithFiles(dir))
+	origin := filepath.Join(dir, "pipeline.yml")
+
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{
+			data: "include: a.yml\n",
+			err:  "include cycle " + origin + " -> " + filepath.Join(dir, "a.yml") + " -> " + filepath.Join(dir, "b.yml") + " -> " + filepath.Join(dir, "a.yml"),
+		},
+		{
+			data: "include: ../outside.yml\n",
+			err:  "outside of the include root",
+		},
+		{
+			data: "include: invalid.yml\n",
+			err:  filepath.Join(dir, "invalid.yml") + ": yaml:",
+		},
+		{
+			data: "include: configmap://ci/go.yml\n",
+			err:  "unsupported include: configmap://ci/go.yml",
+		},
+		{
+			data: "include: [ 1 ]\n",
+			err:  origin + ": include: include must be a list of strings",
+		},
+		{
+			data: "pipeline:\n  test:\n    template: go\n",
+			err:  origin + `: pipeline.test: template "go" is not defined`,
+		},
+		{
+			data: "include: templates.yml\npipeline:\n  test:\n    template: go\n",
+			err:  origin + `: pipeline.test: template "go" requires parameters version`,
+		},
+		{
+			data: "include: templates.yml\npipeline:\n  test:\n    template: go\n    with:\n      version: 1\n      arch: arm\n",
+			err:  origin + `: pipeline.test: template "go" does not declare parameter "arch"`,
+		},
+		{
+			data: "templates:\n  a:\n    template: b\n  b:\n    template: a\npipeline:\n  test:\n    template: a\n",
+			err:  origin + ": templates.b: template cycle a -> b -> a",
+		},
+	}
+	for _, test := range tests {
+		_, err := resolver.Resolve(origin, []byte(test.data))
+		if err == nil {
+			t.Errorf("Want error %q for %q", test.err, test.data)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Want error %q for %q, got %q", test.err, test.data, err)
+		}
+	}
+}
+
+func TestResolveErrorOrigin(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"ci/steps.yml": "pipeline:\n  test:\n    template: missing\n",
+		"pipeline.yml": "include: ci/steps.yml\n",
+	})
+	defer os.RemoveAll(dir)
+	_, err := New(WithFiles(dir)).Resolve(filepath.Join(dir, "pipeline.yml"), []byte("include: ci/steps.yml\n"))
+	want := &Error{
+		File:    filepath.Join(dir, "ci", "steps.yml"),
+		Path:    "pipeline.test",
+		Message: `template "missing" is not defined`,
+	}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("Want error %v, got %v", want, err)
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func resolveFile(t *testing.T, resolver *Resolver, dir, name string) yaml.MapSlice {
+	path := filepath.Join(dir, name)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := resolver.Resolve(path, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parse(t, string(out))
+}
+
+func parse(t *testing.T, s string) yaml.MapSlice {
+	out := yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(s), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func marshal(v interface{}) string {
+	out, _ := yaml.Marshal(v)
+	return string(out)
+}
@@ -0,0 +1,89 @@
+package include
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConfigMapScheme is the prefix of includes loaded from a ConfigMap,
+// in the configmap://<name>/<key> format.
+const ConfigMapScheme = "configmap://"
+
+// ErrUnsupported is returned when an include cannot be loaded because
+// no loader is configured for it.
+var ErrUnsupported = errors.New("unsupported include")
+
+// Loader loads the contents of an included file.
+type Loader interface {
+	Load(name string) ([]byte, error)
+}
+
+// LoaderFunc type is an adapter to allow the use of an ordinary
+// function as a Loader.
+type LoaderFunc func(name string) ([]byte, error)
+
+// Load calls f(name).
+func (f LoaderFunc) Load(name string) ([]byte, error) {
+	return f(name)
+}
+
+// FileLoader returns a Loader that reads local files. Files outside of
+// the root directory cannot be included.
+func FileLoader(root string) Loader {
+	return LoaderFunc(func(name string) ([]byte, error) {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		path, err := filepath.Abs(name)
+		if err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(root, path); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, fmt.Errorf("%s is outside of the include root %s", name, root)
+		}
+		return ioutil.ReadFile(path)
+	})
+}
+
+// ConfigMapLoader returns a Loader that reads keys of the ConfigMaps
+// in the namespace.
+func ConfigMapLoader(client kubernetes.Interface, namespace string) Loader {
+	return LoaderFunc(func(name string) ([]byte, error) {
+		parts := strings.SplitN(strings.TrimPrefix(name, ConfigMapScheme), "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%s is not in the %s<name>/<key> format", name, ConfigMapScheme)
+		}
+		configmap, err := client.CoreV1().ConfigMaps(namespace).Get(parts[0], metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		data, ok := configmap.Data[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("configmap %s does not have key %s", parts[0], parts[1])
+		}
+		return []byte(data), nil
+	})
+}
+
+// resolveName returns the name of the include relative to the file
+// that includes it.
+func resolveName(origin, name string) string {
+	switch {
+	case strings.HasPrefix(name, ConfigMapScheme):
+		return name
+	case strings.HasPrefix(origin, ConfigMapScheme):
+		parts := strings.SplitN(strings.TrimPrefix(origin, ConfigMapScheme), "/", 2)
+		return ConfigMapScheme + parts[0] + "/" + name
+	case filepath.IsAbs(name):
+		return filepath.Clean(name)
+	default:
+		return filepath.Join(filepath.Dir(origin), name)
+	}
+}
@@ -0,0 +1,400 @@
+// Package linter reports problems in the pipeline configuration before
+// it is compiled, such as unknown keys, invalid constraints and the use
+// of privileged features in untrusted repositories.
+package linter
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	libyaml "gopkg.in/yaml.v2"
+
+	"github.com/marjoram/pipeline/pipeline/frontend/yaml"
+)
+
+// Severity defines the severity of a diagnostic.
+type Severity string
+
+// Diagnostic severities.
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Lint rules.
+const (
+	RuleSyntax          = "syntax"
+	RuleUnknownKey      = "unknown-key"
+	RuleMissingImage    = "missing-image"
+	RuleUntrusted       = "untrusted"
+	RuleDuplicateName   = "duplicate-name"
+	RuleBadWhen         = "bad-when"
+	RuleServiceConflict = "service-conflict"
+)
+
+// Diagnostic defines a problem found in the configuration.
+type Diagnostic struct {
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Severity Severity `json:"severity"`
+	Rule     string   `json:"rule"`
+	Message  string   `json:"message"`
+}
+
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s (%s)", d.Line, d.Column, d.Severity, d.Message, d.Rule)
+}
+
+// Option configures a linter option.
+type Option func(*Linter)
+
+// WithTrusted configures the linter to allow privileged containers
+// and host volumes.
+func WithTrusted(trusted bool) Option {
+	return func(l *Linter) {
+		l.trusted = trusted
+	}
+}
+
+// Linter lints the pipeline configuration.
+type Linter struct {
+	trusted bool
+}
+
+// New creates a new Linter with options.
+func New(opts ...Option) *Linter {
+	linter := new(Linter)
+	for _, opt := range opts {
+		opt(linter)
+	}
+	return linter
+}
+
+// Lint lints the yaml configuration and returns the diagnostics sorted
+// by position. A configuration that cannot be parsed returns a single
+// syntax diagnostic.
+func (l *Linter) Lint(data []byte) []*Diagnostic {
+	raw := libyaml.MapSlice{}
+	if err := libyaml.Unmarshal(data, &raw); err != nil {
+		return []*Diagnostic{syntaxError(err)}
+	}
+	conf, err := yaml.ParseBytes(data)
+	if err != nil {
+		return []*Diagnostic{syntaxError(err)}
+	}
+
+	s := &state{
+		linter: l,
+		root:   parsePositions(data),
+	}
+	s.lintConfig(raw, conf)
+
+	sort.SliceStable(s.diagnostics, func(i, j int) bool {
+		a, b := s.diagnostics[i], s.diagnostics[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return s.diagnostics
+}
+
+// HasErrors returns true if any of the diagnostics is an error.
+func HasErrors(diagnostics []*Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// state holds the diagnostics of a single Lint invocation.
+type state struct {
+	linter      *Linter
+	root        *node
+	diagnostics []*Diagnostic
+}
+
+func (s *state) report(n *node, severity Severity, rule, format string, args ...interface{}) {
+	d := &Diagnostic{
+		Line:     1,
+		Column:   1,
+		Severity: severity,
+		Rule:     rule,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if n != nil && n.line != 0 {
+		d.Line = n.line
+		d.Column = n.column
+	}
+	s.diagnostics = append(s.diagnostics, d)
+}
+
+func (s *state) lintConfig(raw libyaml.MapSlice, conf *yaml.Config) {
+	for _, item := range raw {
+		key := fmt.Sprint(item.Key)
+		if !configKeys[key] {
+			s.report(s.root.child(key), SeverityWarning, RuleUnknownKey, "unknown key %q", key)
+		}
+	}
+
+	// step names must be unique across the clone and pipeline sections,
+	// since they are used as the network alias of the step.
+	names := map[string]bool{}
+	s.lintSection("clone", raw, conf.Clone.Containers.Containers, names)
+	s.lintSection("pipeline", raw, conf.Pipeline.Containers, names)
+
+	services := map[string]bool{}
+	s.lintSection("services", raw, conf.Services.Containers, services)
+
+	section := s.root.child("services")
+	for i, container := range conf.Services.Containers {
+		if names[container.Name] {
+			s.report(containerNode(section, i), SeverityError, RuleServiceConflict,
+				"service %q conflicts with the step of the same name", container.Name)
+		}
+	}
+}
+
+func (s *state) lintSection(section string, raw libyaml.MapSlice, containers []*yaml.Container, names map[string]bool) {
+	parent := s.root.child(section)
+	items, _ := lookup(raw, section).(libyaml.MapSlice)
+
+	// the steps are the mapping entries of the section, which may
+	// also contain settings, such as the clone depth.
+	var steps []int
+	for i, item := range items {
+		if _, ok := item.Value.(libyaml.MapSlice); ok {
+			steps = append(steps, i)
+		}
+	}
+
+	for i, container := range containers {
+		n := parent
+		var fields libyaml.MapSlice
+		if i < len(steps) {
+			n = containerNode(parent, steps[i])
+			fields, _ = items[steps[i]].Value.(libyaml.MapSlice)
+		}
+
+		if names[container.Name] {
+			s.report(n, SeverityError, RuleDuplicateName, "duplicate step name %q", container.Name)
+		}
+		names[container.Name] = true
+
+		// steps using a template inherit the image of the template,
+		// which may be defined in an included file.
+		if container.Image == "" && lookup(fields, "template") == nil {
+			s.report(n, SeverityError, RuleMissingImage, "step %q does not define an image", container.Name)
+		}
+
+		// plugins are configured using custom keys, which are only
+		// unknown to steps that run commands and to services.
+		if section == "services" || len(container.Commands) != 0 {
+			for _, field := range fields {
+				key := fmt.Sprint(field.Key)
+				if !containerKeys[key] {
+					s.report(child(n, key), SeverityWarning, RuleUnknownKey,
+						"unknown key %q in step %q", key, container.Name)
+				}
+			}
+		}
+
+		if !s.linter.trusted {
+			if container.Privileged {
+				s.report(child(n, "privileged"), SeverityError, RuleUntrusted,
+					"step %q cannot be privileged in an untrusted repository", container.Name)
+			}
+			for _, volume := range container.Volumes {
+				if isHostVolume(volume) {
+					s.report(child(n, "volumes"), SeverityError, RuleUntrusted,
+						"step %q cannot mount host volume %q in an untrusted repository", container.Name, volume)
+				}
+			}
+		}
+
+		if when := lookup(fields, "when"); when != nil {
+			s.lintWhen(child(n, "when"), when, container)
+		}
+	}
+}
+
+func (s *state) lintWhen(n *node, raw interface{}, container *yaml.Container) {
+	fields, ok := raw.(libyaml.MapSlice)
+	if !ok {
+		s.report(n, SeverityError, RuleBadWhen, "when in step %q must be a mapping", container.Name)
+		return
+	}
+	for _, field := range fields {
+		key := fmt.Sprint(field.Key)
+		switch key {
+		case "branch", "event", "ref", "status", "paths":
+			if !isConstraint(field.Value) {
+				s.report(child(n, key), SeverityError, RuleBadWhen,
+					"when %s in step %q must be a string, a list or an include and exclude mapping", key, container.Name)
+			}
+		case "environment":
+			env, ok := field.Value.(libyaml.MapSlice)
+			if !ok {
+				s.report(child(n, key), SeverityError, RuleBadWhen,
+					"when environment in step %q must be a mapping", container.Name)
+				continue
+			}
+			for _, item := range env {
+				if !isConstraint(item.Value) {
+					s.report(child(child(n, key), fmt.Sprint(item.Key)), SeverityError, RuleBadWhen,
+						"when environment %v in step %q must be a string, a list or an include and exclude mapping", item.Key, container.Name)
+				}
+			}
+		default:
+			s.report(child(n, key), SeverityError, RuleBadWhen, "unknown when key %q in step %q", key, container.Name)
+		}
+	}
+
+	constraints := container.Constraints
+	for _, value := range append(constraints.Status.Include, constraints.Status.Exclude...) {
+		if !statuses[value] {
+			s.report(child(n, "status"), SeverityError, RuleBadWhen,
+				"unknown status %q in step %q, expected success or failure", value, container.Name)
+		}
+	}
+	for _, value := range append(constraints.Event.Include, constraints.Event.Exclude...) {
+		if !events[value] {
+			s.report(child(n, "event"), SeverityError, RuleBadWhen,
+				"unknown event %q in step %q, expected push, pull_request, tag or deployment", value, container.Name)
+		}
+	}
+}
+
+var (
+	// configKeys are the known top level keys.
+	configKeys = keysOf(yaml.Config{}, "matrix", "include", "templates")
+
+	// containerKeys are the known container keys.
+	containerKeys = keysOf(yaml.Container{}, "template", "with")
+
+	statuses = map[string]bool{
+		"success": true,
+		"failure": true,
+	}
+
+	events = map[string]bool{
+		"push":         true,
+		"pull_request": true,
+		"tag":          true,
+		"deployment":   true,
+	}
+)
+
+// keysOf returns the yaml keys of the struct fields, using the same
+// naming rules as the yaml decoder.
+func keysOf(v interface{}, extra ...string) map[string]bool {
+	keys := map[string]bool{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		switch {
+		case len(tag) > 1 && tag[1] == "inline":
+			continue
+		case tag[0] != "":
+			keys[tag[0]] = true
+		default:
+			keys[strings.ToLower(field.Name)] = true
+		}
+	}
+	for _, key := range extra {
+		keys[key] = true
+	}
+	return keys
+}
+
+// isConstraint returns true if the value can be decoded as a
+// constraint.
+func isConstraint(v interface{}) bool {
+	switch t := v.(type) {
+	case string, int, float64, bool:
+		return true
+	case []interface{}:
+		for _, item := range t {
+			if !isConstraint(item) {
+				return false
+			}
+			if _, ok := item.([]interface{}); ok {
+				return false
+			}
+		}
+		return true
+	case libyaml.MapSlice:
+		for _, item := range t {
+			key := fmt.Sprint(item.Key)
+			if key != "include" && key != "exclude" {
+				return false
+			}
+			if _, ok := item.Value.(libyaml.MapSlice); ok || !isConstraint(item.Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// isHostVolume returns true if the volume mounts a host path.
+func isHostVolume(volume string) bool {
+	return strings.HasPrefix(volume, "/") ||
+		strings.HasPrefix(volume, ".") ||
+		strings.HasPrefix(volume, "~")
+}
+
+// containerNode returns the node of the ith container in the section,
+// or the section node if the container is not indexed.
+func containerNode(section *node, i int) *node {
+	n := section.index(i)
+	if n == nil {
+		return section
+	}
+	return n
+}
+
+// child returns the named child node, or the parent node if the child
+// is not indexed.
+func child(n *node, key string) *node {
+	if c := n.child(key); c != nil {
+		return c
+	}
+	return n
+}
+
+func lookup(slice libyaml.MapSlice, key string) interface{} {
+	for _, item := range slice {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+var lineRegexp = regexp.MustCompile(`line (\d+): `)
+
+// syntaxError converts a yaml parsing error to a diagnostic.
+func syntaxError(err error) *Diagnostic {
+	d := &Diagnostic{
+		Line:     1,
+		Column:   1,
+		Severity: SeverityError,
+		Rule:     RuleSyntax,
+		Message:  strings.TrimPrefix(err.Error(), "yaml: "),
+	}
+	if match := lineRegexp.FindStringSubmatch(d.Message); match != nil {
+		d.Line, _ = strconv.Atoi(match[1])
+		d.Message = strings.Replace(d.Message, match[0], "", 1)
+	}
+	return d
+}
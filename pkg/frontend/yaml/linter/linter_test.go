@@ -0,0 +1,207 @@
+package linter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    string
+		trusted bool
+		want    []Diagnostic
+	}{
+		{
+			name: "valid",
+			conf: `
+pipeline:
+  build:
+    image: golang
+    commands:
+      - |
+        go build
+        status: ok
+  publish:
+    image: plugins/docker
+    repo: foo/bar
+    when:
+      event: [ push, tag ]
+      status: { exclude: failure }
+`,
+		},
+		{
+			name: "unknown keys",
+			conf: `
+pipelines:
+  build:
+    image: golang
+pipeline:
+  build:
+    image: golang
+    command: [ go test ]
+    commands: [ go build ]
+`,
+			want: []Diagnostic{
+				{Line: 2, Column: 1, Severity: SeverityWarning, Rule: RuleUnknownKey},
+			},
+		},
+		{
+			name: "unknown step key",
+			conf: `
+pipeline:
+  build:
+    image: golang
+    comands: [ go build ]
+    commands: [ go test ]
+`,
+			want: []Diagnostic{
+				{Line: 5, Column: 5, Severity: SeverityWarning, Rule: RuleUnknownKey},
+			},
+		},
+		{
+			name: "missing image",
+			conf: `
+pipeline:
+  build:
+    commands: [ go build ]
+`,
+			want: []Diagnostic{
+				{Line: 3, Column: 3, Severity: SeverityError, Rule: RuleMissingImage},
+			},
+		},
+		{
+			name: "templates",
+			conf: `
+include: ci/templates.yml
+pipeline:
+  build:
+    template: go-build
+    with:
+      packages: ./...
+    commands: [ go build ]
+`,
+		},
+		{
+			name: "untrusted",
+			conf: `
+pipeline:
+  build:
+    image: docker
+    privileged: true
+    volumes:
+      - /var/run/docker.sock:/var/run/docker.sock
+      - cache:/cache
+`,
+			want: []Diagnostic{
+				{Line: 5, Column: 5, Severity: SeverityError, Rule: RuleUntrusted},
+				{Line: 6, Column: 5, Severity: SeverityError, Rule: RuleUntrusted},
+			},
+		},
+		{
+			name:    "trusted",
+			trusted: true,
+			conf: `
+pipeline:
+  build:
+    image: docker
+    privileged: true
+    volumes: [ /var/run/docker.sock:/var/run/docker.sock ]
+`,
+		},
+		{
+			name: "duplicate names",
+			conf: `
+pipeline:
+  build:
+    image: golang
+  test:
+    image: golang
+    name: build
+`,
+			want: []Diagnostic{
+				{Line: 5, Column: 3, Severity: SeverityError, Rule: RuleDuplicateName},
+			},
+		},
+		{
+			name: "bad when",
+			conf: `
+pipeline:
+  build:
+    image: golang
+    when:
+      branches: master
+      event: [ push, commit ]
+      status: { include: [ succes ] }
+      ref: { only: refs/tags/* }
+`,
+			want: []Diagnostic{
+				{Line: 6, Column: 7, Severity: SeverityError, Rule: RuleBadWhen},
+				{Line: 7, Column: 7, Severity: SeverityError, Rule: RuleBadWhen},
+				{Line: 8, Column: 7, Severity: SeverityError, Rule: RuleBadWhen},
+				{Line: 9, Column: 7, Severity: SeverityError, Rule: RuleBadWhen},
+			},
+		},
+		{
+			name: "service conflict",
+			conf: `
+pipeline:
+  database:
+    image: golang
+services:
+  database:
+    image: mysql
+`,
+			want: []Diagnostic{
+				{Line: 6, Column: 3, Severity: SeverityError, Rule: RuleServiceConflict},
+			},
+		},
+		{
+			name: "syntax",
+			conf: "pipeline:\n  build:\n    image: [ golang\n",
+			want: []Diagnostic{
+				{Line: 3, Column: 1, Severity: SeverityError, Rule: RuleSyntax},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := New(WithTrusted(test.trusted)).Lint([]byte(test.conf))
+		if len(got) != len(test.want) {
+			t.Errorf("%s: want %d diagnostics, got %v", test.name, len(test.want), got)
+			continue
+		}
+		for i, want := range test.want {
+			d := got[i]
+			if d.Line != want.Line || d.Column != want.Column || d.Severity != want.Severity || d.Rule != want.Rule {
+				t.Errorf("%s: want %d:%d %s %s, got %s", test.name, want.Line, want.Column, want.Severity, want.Rule, d)
+			}
+		}
+	}
+}
+
+func TestHasErrors(t *testing.T) {
+	if HasErrors([]*Diagnostic{{Severity: SeverityWarning}}) {
+		t.Errorf("Want warnings not reported as errors")
+	}
+	if !HasErrors([]*Diagnostic{{Severity: SeverityWarning}, {Severity: SeverityError}}) {
+		t.Errorf("Want errors reported")
+	}
+}
+
+func TestLintSamples(t *testing.T) {
+	files, err := filepath.Glob("../../../../samples/*/pipeline.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := New().Lint(data); len(got) != 0 {
+			t.Errorf("%s: want no diagnostics, got %v", file, got)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package linter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// node is a mapping key in the yaml document and the position at
+// which it is declared. The yaml library does not expose the position
+// of decoded values, so the keys are indexed by scanning the document
+// line by line. Flow style mappings are not indexed, in which case the
+// position of the nearest enclosing key is used instead.
+type node struct {
+	key      string
+	line     int
+	column   int
+	children []*node
+}
+
+// child returns the first child node with the given key.
+func (n *node) child(key string) *node {
+	if n == nil {
+		return nil
+	}
+	for _, child := range n.children {
+		if child.key == key {
+			return child
+		}
+	}
+	return nil
+}
+
+// index returns the nth child node, which matches the nth entry of
+// an ordered yaml mapping.
+func (n *node) index(i int) *node {
+	if n == nil || i >= len(n.children) {
+		return nil
+	}
+	return n.children[i]
+}
+
+var keyRegexp = regexp.MustCompile(`^("[^"]*"|'[^']*'|[^\s#'"{\[\-][^:#]*?|-[^\s:#][^:#]*?)\s*:(\s|$)`)
+
+// parsePositions indexes the mapping keys of the yaml document.
+func parsePositions(data []byte) *node {
+	type frame struct {
+		indent int
+		node   *node
+	}
+	root := new(node)
+	stack := []frame{{indent: -1, node: root}}
+	block := -1
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+
+		// skip the contents of block scalars, which are indented
+		// deeper than the key or list item that declares them.
+		if block >= 0 {
+			if trimmed == "" || indent > block {
+				continue
+			}
+			block = -1
+		}
+		if trimmed == "" || trimmed[0] == '#' || trimmed == "---" {
+			continue
+		}
+
+		start := indent
+		for trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			trimmed = strings.TrimLeft(trimmed[1:], " ")
+			indent = len(line) - len(trimmed)
+		}
+
+		match := keyRegexp.FindStringSubmatch(trimmed)
+		if match == nil {
+			if isBlockScalar(trimmed) {
+				block = start
+			}
+			continue
+		}
+
+		for len(stack) > 1 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		n := &node{
+			key:    strings.Trim(match[1], `"'`),
+			line:   i + 1,
+			column: indent + 1,
+		}
+		parent := stack[len(stack)-1].node
+		parent.children = append(parent.children, n)
+		stack = append(stack, frame{indent: indent, node: n})
+
+		if isBlockScalar(strings.TrimSpace(trimmed[len(match[0]):])) {
+			block = indent
+		}
+	}
+	return root
+}
+
+// isBlockScalar returns true if the value is a literal or folded
+// block scalar indicator.
+func isBlockScalar(value string) bool {
+	if i := strings.Index(value, "#"); i != -1 {
+		value = strings.TrimSpace(value[:i])
+	}
+	if value == "" || (value[0] != '|' && value[0] != '>') {
+		return false
+	}
+	return strings.Trim(value[1:], "+-0123456789") == ""
+}
@@ -0,0 +1,90 @@
+package matrix
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+// Job is a single matrix combination compiled to the intermediate
+// representation. Each job is executed and reported independently.
+type Job struct {
+	// Job number, starting at 1
+	Number int `json:"number"`
+	// Matrix variables of the job
+	Axis Axis `json:"axis,omitempty"`
+	// Compiled pipeline configuration
+	Config *backend.Config `json:"config"`
+}
+
+// CompileFunc compiles the pipeline configuration of a matrix axis
+// to the intermediate representation.
+type CompileFunc func(data []byte, axis Axis) (*backend.Config, error)
+
+// Expand compiles one job for each axis of the pipeline matrix, or a
+// single job if the configuration does not define a matrix. The axis
+// variables are substituted in the configuration before it is compiled,
+// and are added to the environment of every compiled step.
+func Expand(data []byte, compile CompileFunc) ([]*Job, error) {
+	axes, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(axes) == 0 {
+		axes = []Axis{nil}
+	}
+
+	var jobs []*Job
+	for i, axis := range axes {
+		conf, err := compile(Substitute(data, axis), axis)
+		if err != nil {
+			return nil, err
+		}
+		for _, stage := range conf.Stages {
+			for _, step := range stage.Steps {
+				if len(axis) != 0 && step.Environment == nil {
+					step.Environment = map[string]string{}
+				}
+				for k, v := range axis {
+					step.Environment[k] = v
+				}
+			}
+		}
+		jobs = append(jobs, &Job{
+			Number: i + 1,
+			Axis:   axis,
+			Config: conf,
+		})
+	}
+	return jobs, nil
+}
+
+// Substitute replaces ${VAR} references to the axis variables in the
+// pipeline configuration. References to other variables, and escaped
+// $${VAR} references, are left as-is for envsubst.
+func Substitute(data []byte, axis Axis) []byte {
+	if len(axis) == 0 {
+		return data
+	}
+	s := string(data)
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "$$"):
+			buf.WriteString("$$")
+			i++
+			continue
+		case strings.HasPrefix(s[i:], "${"):
+			if end := strings.IndexByte(s[i:], '}'); end != -1 {
+				if v, ok := axis[s[i+2:i+end]]; ok {
+					buf.WriteString(v)
+					i += end
+					continue
+				}
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.Bytes()
+}
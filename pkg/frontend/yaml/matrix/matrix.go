@@ -0,0 +1,125 @@
+// Package matrix expands the matrix section of a pipeline
+// configuration into the individual builds it describes.
+package matrix
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	limitTags = 10
+	limitAxis = 25
+)
+
+// Matrix represents the build matrix.
+type Matrix map[string][]string
+
+// Axis represents a single permutation of entries from the build matrix.
+type Axis map[string]string
+
+// String returns a string representation of an Axis as a space
+// separated list of variables, sorted by name.
+func (a Axis) String() string {
+	var envs []string
+	for k, v := range a {
+		envs = append(envs, k+"="+v)
+	}
+	sort.Strings(envs)
+	return strings.Join(envs, " ")
+}
+
+// Parse parses the matrix section of the pipeline configuration and
+// returns a list of all axes, or nil if the configuration does not
+// define a matrix.
+func Parse(data []byte) ([]Axis, error) {
+	axis, err := parseList(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(axis) != 0 {
+		return axis, nil
+	}
+
+	matrix, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matrix) == 0 {
+		return nil, nil
+	}
+	return calc(matrix)
+}
+
+// ParseString parses the matrix section of the pipeline configuration
+// and returns a list of all axes.
+func ParseString(data string) ([]Axis, error) {
+	return Parse([]byte(data))
+}
+
+// calc generates every permutation of the matrix variables.
+func calc(matrix Matrix) ([]Axis, error) {
+	// calculate number of permutations and extract the list of tags
+	// (ie go_version, redis_version, etc)
+	var perm = 1
+	var tags []string
+	for k, v := range matrix {
+		if len(v) == 0 {
+			return nil, fmt.Errorf("matrix: variable %s has no values", k)
+		}
+		perm *= len(v)
+		tags = append(tags, k)
+	}
+	sort.Strings(tags)
+
+	if len(tags) > limitTags {
+		return nil, fmt.Errorf("matrix: %d variables exceed the limit of %d", len(tags), limitTags)
+	}
+	if perm > limitAxis {
+		return nil, fmt.Errorf("matrix: %d combinations exceed the limit of %d", perm, limitAxis)
+	}
+
+	// for each axis calculate the unique set of values that should be used.
+	var axisList []Axis
+	for p := 0; p < perm; p++ {
+		axis := Axis{}
+		decr := perm
+		for _, tag := range tags {
+			elems := matrix[tag]
+			decr = decr / len(elems)
+			elem := p / decr % len(elems)
+			axis[tag] = elems[elem]
+		}
+		axisList = append(axisList, axis)
+	}
+	return axisList, nil
+}
+
+func parse(raw []byte) (Matrix, error) {
+	data := struct {
+		Matrix map[string][]string
+	}{}
+	if err := yaml.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	return data.Matrix, nil
+}
+
+func parseList(raw []byte) ([]Axis, error) {
+	data := struct {
+		Matrix struct {
+			Include []Axis
+		}
+	}{}
+	if err := yaml.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	if len(data.Matrix.Include) > limitAxis {
+		return nil, fmt.Errorf("matrix: %d combinations exceed the limit of %d", len(data.Matrix.Include), limitAxis)
+	}
+	return data.Matrix.Include, nil
+}
@@ -0,0 +1,160 @@
+package matrix
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+func TestParse(t *testing.T) {
+	axes, err := ParseString(fakeMatrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(axes), 24; got != want {
+		t.Fatalf("Want %d axes, got %d", want, got)
+	}
+
+	set := map[string]bool{}
+	for _, axis := range axes {
+		set[axis.String()] = true
+	}
+	if got, want := len(set), 24; got != want {
+		t.Errorf("Want %d unique axes, got %d", want, got)
+	}
+	if got, want := axes[0].String(), "DATABASE=mysql:5.6 GO_VERSION=1.x NODE_VERSION=0.10"; got != want {
+		t.Errorf("Want first axis %q, got %q", want, got)
+	}
+}
+
+func TestParseInclude(t *testing.T) {
+	axes, err := ParseString(fakeMatrixInclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Axis{
+		{"GO_VERSION": "1.5", "PYTHON_VERSION": "3.4"},
+		{"GO_VERSION": "1.6", "PYTHON_VERSION": "3.4"},
+	}
+	if !reflect.DeepEqual(axes, want) {
+		t.Errorf("Want axes %v, got %v", want, axes)
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	axes, err := ParseString("pipeline: {}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if axes != nil {
+		t.Errorf("Want nil axes, got %v", axes)
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	_, err := ParseString(`
+matrix:
+  A: [1, 2, 3, 4, 5, 6]
+  B: [1, 2, 3, 4, 5, 6]
+`)
+	if err == nil {
+		t.Errorf("Want error when the matrix exceeds the axis limit")
+	}
+}
+
+func TestParseIncludeLimit(t *testing.T) {
+	var include []string
+	for i := 0; i <= limitAxis; i++ {
+		include = append(include, "    - GO_VERSION: 1."+strconv.Itoa(i))
+	}
+	_, err := ParseString("matrix:\n  include:\n" + strings.Join(include, "\n"))
+	if err == nil {
+		t.Errorf("Want error when the matrix include exceeds the axis limit")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := ParseString("matrix:\n  include: [GO_VERSION]\n"); err == nil {
+		t.Errorf("Want error parsing an invalid matrix include")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	var compiled []string
+	compile := func(data []byte, axis Axis) (*backend.Config, error) {
+		compiled = append(compiled, string(data))
+		return &backend.Config{
+			Stages: []*backend.Stage{
+				{Steps: []*backend.Step{{Name: "test"}}},
+			},
+		}, nil
+	}
+
+	jobs, err := Expand([]byte(fakeMatrixExpand), compile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(jobs), 2; got != want {
+		t.Fatalf("Want %d jobs, got %d", want, got)
+	}
+	if got, want := jobs[1].Number, 2; got != want {
+		t.Errorf("Want job number %d, got %d", want, got)
+	}
+	if got, want := compiled[1], "image: golang:1.9\nmatrix:\n  GO_VERSION: [1.8, 1.9]\n"; got != want {
+		t.Errorf("Want substituted configuration %q, got %q", want, got)
+	}
+	env := jobs[1].Config.Stages[0].Steps[0].Environment
+	if got, want := env["GO_VERSION"], "1.9"; got != want {
+		t.Errorf("Want axis variable in step environment %q, got %q", want, got)
+	}
+}
+
+func TestExpandNoMatrix(t *testing.T) {
+	compile := func(data []byte, axis Axis) (*backend.Config, error) {
+		return &backend.Config{}, nil
+	}
+	jobs, err := Expand([]byte("image: golang:${GO_VERSION}"), compile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Axis != nil {
+		t.Errorf("Want a single job without axis, got %v", jobs)
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	got := string(Substitute([]byte("${GO_VERSION} ${CI_COMMIT_SHA} $${GO_VERSION} $$$${GO_VERSION}"), Axis{"GO_VERSION": "1.9"}))
+	if want := "1.9 ${CI_COMMIT_SHA} $${GO_VERSION} $$$${GO_VERSION}"; got != want {
+		t.Errorf("Want %q, got %q", want, got)
+	}
+}
+
+var fakeMatrix = `
+matrix:
+  GO_VERSION:
+    - 1.x
+    - 1.4
+    - 1.3
+  NODE_VERSION:
+    - 0.10
+    - 0.11
+  DATABASE:
+    - mysql:5.6
+    - mysql:5.7
+    - postgres:9.4
+    - postgres:9.5
+`
+
+var fakeMatrixInclude = `
+matrix:
+  include:
+    - GO_VERSION: 1.5
+      PYTHON_VERSION: 3.4
+    - GO_VERSION: 1.6
+      PYTHON_VERSION: 3.4
+`
+
+var fakeMatrixExpand = "image: golang:${GO_VERSION}\nmatrix:\n  GO_VERSION: [1.8, 1.9]\n"
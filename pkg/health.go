@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+// Default health check settings.
+const (
+	DefaultProbeInterval = time.Second
+	DefaultProbeTimeout  = time.Minute
+)
+
+// waitReady probes the detached step until its health check succeeds,
+// or returns an error once the health check timeout expires.
+func (r *Runtime) waitReady(ctx context.Context, proc *backend.Step) error {
+	prober, ok := r.engine.(backend.Prober)
+	if !ok {
+		return fmt.Errorf("%s : engine does not support health checks", proc.Name)
+	}
+
+	interval := proc.HealthCheck.Interval
+	if interval == 0 {
+		interval = DefaultProbeInterval
+	}
+	timeout := proc.HealthCheck.Timeout
+	if timeout == 0 {
+		timeout = DefaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := prober.Probe(ctx, proc)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-r.ctx.Done():
+			return ErrCancel
+		case <-ctx.Done():
+			return &HealthError{
+				Name:    proc.Name,
+				Timeout: timeout,
+				Err:     err,
+			}
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package pipeline
+
+import "context"
+
+// Limiter bounds the number of concurrently running pipeline steps.
+type Limiter interface {
+	// Acquire blocks until a step may run or the context is
+	// cancelled.
+	Acquire(context.Context) error
+
+	// Release frees a slot acquired by a step.
+	Release()
+}
+
+// NewLimiter returns a Limiter that allows at most n concurrently
+// running steps. A single Limiter may be shared by multiple runtimes
+// to bound the steps running across all of them.
+func NewLimiter(n int) Limiter {
+	return make(limiter, n)
+}
+
+type limiter chan struct{}
+
+func (l limiter) Acquire(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case l <- struct{}{}:
+		return nil
+	}
+}
+
+func (l limiter) Release() {
+	<-l
+}
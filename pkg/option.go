@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/cache"
+)
+
+// Option configures a runtime option.
+type Option func(*Runtime)
+
+// WithEngine returns an option configured with a runtime engine.
+func WithEngine(engine backend.Engine) Option {
+	return func(r *Runtime) {
+		r.engine = engine
+	}
+}
+
+// WithLogger returns an option configured with a runtime logger.
+func WithLogger(logger Logger) Option {
+	return func(r *Runtime) {
+		r.logger = logger
+	}
+}
+
+// WithTracer returns an option configured with a runtime tracer.
+func WithTracer(tracer Tracer) Option {
+	return func(r *Runtime) {
+		r.tracer = tracer
+	}
+}
+
+// WithContext returns an option configured with a context.
+func WithContext(ctx context.Context) Option {
+	return func(r *Runtime) {
+		r.ctx = ctx
+	}
+}
+
+// WithMaxProcs returns an option configured with the maximum number
+// of concurrently running steps. Stages may override the limit for
+// their own steps.
+func WithMaxProcs(n int) Option {
+	return func(r *Runtime) {
+		if n > 0 {
+			r.limiter = NewLimiter(n)
+		}
+	}
+}
+
+// WithLimiter returns an option configured with a limiter that is
+// shared with other runtimes, in addition to the runtime limit.
+func WithLimiter(limiter Limiter) Option {
+	return func(r *Runtime) {
+		r.shared = limiter
+	}
+}
+
+// WithCache returns an option configured with the store of the cache
+// steps. Cache steps are skipped when no store is configured.
+func WithCache(store cache.Store) Option {
+	return func(r *Runtime) {
+		r.cache = store
+	}
+}
@@ -0,0 +1,420 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+// minMemLimit is the minimum memory limit accepted by docker.
+const minMemLimit = 4 * 1024 * 1024
+
+// minCPUQuota is the minimum cpu quota, in microseconds, accepted by
+// docker.
+const minCPUQuota = 1000
+
+var cpusetRegexp = regexp.MustCompile(`^\d+(-\d+)?(,\d+(-\d+)?)*$`)
+
+// Parse parses the pipeline config from an io.Reader. Unknown fields
+// are rejected, and the configuration is validated before it is
+// returned. The returned error is a *ParseError that points to the
+// json path of the invalid value.
+func Parse(r io.Reader) (*backend.Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBytes(data)
+}
+
+// ParseBytes parses the pipeline config from bytes b.
+func ParseBytes(data []byte) (*backend.Config, error) {
+	var raw interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, syntaxError(data, err)
+	}
+	if err := checkSchema("$", raw, reflect.TypeOf(backend.Config{})); err != nil {
+		return nil, err
+	}
+
+	config := new(backend.Config)
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, &ParseError{Path: "$", Message: err.Error()}
+	}
+	if err := validate(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ParseString parses the pipeline config from string s.
+func ParseString(s string) (*backend.Config, error) {
+	return ParseBytes([]byte(s))
+}
+
+// syntaxError converts a json syntax error offset to a line and column.
+func syntaxError(data []byte, err error) error {
+	serr, ok := err.(*json.SyntaxError)
+	if !ok {
+		return &ParseError{Path: "$", Message: err.Error()}
+	}
+	// the offset is past the byte that caused the error.
+	offset := serr.Offset
+	if offset > 0 {
+		offset--
+	}
+	prefix := data[:offset]
+	line := bytes.Count(prefix, []byte("\n")) + 1
+	column := len(prefix) - bytes.LastIndexByte(prefix, '\n')
+	return &ParseError{
+		Path:    "$",
+		Message: fmt.Sprintf("%s at line %d, column %d", serr, line, column),
+	}
+}
+
+// checkSchema checks the decoded json value against the type it is
+// unmarshaled to, rejecting unknown fields and mismatched types.
+func checkSchema(path string, v interface{}, t reflect.Type) error {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if v == nil {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return schemaError(path, "object", v)
+		}
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(obj) {
+			field, ok := fields[key]
+			if !ok {
+				return &ParseError{Path: path + "." + key, Message: "unknown field"}
+			}
+			if err := checkSchema(path+"."+key, obj[key], field.Type); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return schemaError(path, "object", v)
+		}
+		for _, key := range sortedKeys(obj) {
+			if err := checkSchema(fmt.Sprintf("%s[%q]", path, key), obj[key], t.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return schemaError(path, "array", v)
+		}
+		for i, item := range arr {
+			if err := checkSchema(fmt.Sprintf("%s[%d]", path, i), item, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			return schemaError(path, "string", v)
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			return schemaError(path, "boolean", v)
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, ok := v.(json.Number)
+		if !ok {
+			return schemaError(path, "integer", v)
+		}
+		if _, err := n.Int64(); err != nil {
+			return schemaError(path, "integer", v)
+		}
+	}
+	return nil
+}
+
+func schemaError(path, want string, got interface{}) error {
+	var kind string
+	switch got.(type) {
+	case map[string]interface{}:
+		kind = "object"
+	case []interface{}:
+		kind = "array"
+	case string:
+		kind = "string"
+	case bool:
+		kind = "boolean"
+	case json.Number:
+		kind = "number"
+	}
+	return &ParseError{Path: path, Message: fmt.Sprintf("expected %s, got %s", want, kind)}
+}
+
+// jsonFields returns the struct fields indexed by json name.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validate validates the pipeline configuration.
+func validate(config *backend.Config) error {
+	volumes := map[string]bool{}
+	for i, volume := range config.Volumes {
+		path := fmt.Sprintf("$.volumes[%d]", i)
+		if volume == nil || volume.Name == "" {
+			return &ParseError{Path: path + ".name", Message: "volume name is required"}
+		}
+		volumes[volume.Name] = true
+	}
+
+	networks := map[string]bool{}
+	for i, network := range config.Networks {
+		path := fmt.Sprintf("$.networks[%d]", i)
+		if network == nil || network.Name == "" {
+			return &ParseError{Path: path + ".name", Message: "network name is required"}
+		}
+		networks[network.Name] = true
+	}
+
+	names := map[string]string{}
+	for i, stage := range config.Stages {
+		if stage == nil {
+			return &ParseError{Path: fmt.Sprintf("$.pipeline[%d]", i), Message: "stage is null"}
+		}
+		for j, step := range stage.Steps {
+			path := fmt.Sprintf("$.pipeline[%d].steps[%d]", i, j)
+			if step == nil {
+				return &ParseError{Path: path, Message: "step is null"}
+			}
+			if step.Name == "" {
+				return &ParseError{Path: path + ".name", Message: "step name is required"}
+			}
+			if prev, ok := names[step.Name]; ok {
+				return &ParseError{
+					Path:    path + ".name",
+					Message: fmt.Sprintf("duplicate step name %q, first declared at %s", step.Name, prev),
+				}
+			}
+			names[step.Name] = path + ".name"
+
+			if err := validateStep(path, step, volumes, networks); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateStep(path string, step *backend.Step, volumes, networks map[string]bool) error {
+	for i, volume := range step.Volumes {
+		parts := strings.Split(volume, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || !strings.HasPrefix(parts[1], "/") {
+			return &ParseError{
+				Path:    fmt.Sprintf("%s.volumes[%d]", path, i),
+				Message: fmt.Sprintf("invalid volume %q, expected source:target[:mode]", volume),
+			}
+		}
+		// sources that are not absolute paths are named volumes, which
+		// must be defined by the configuration.
+		if !strings.HasPrefix(parts[0], "/") && !volumes[parts[0]] {
+			return &ParseError{
+				Path:    fmt.Sprintf("%s.volumes[%d]", path, i),
+				Message: fmt.Sprintf("undefined volume %q", parts[0]),
+			}
+		}
+	}
+
+	for i, conn := range step.Networks {
+		if !networks[conn.Name] {
+			return &ParseError{
+				Path:    fmt.Sprintf("%s.networks[%d].name", path, i),
+				Message: fmt.Sprintf("undefined network %q", conn.Name),
+			}
+		}
+	}
+
+	for i, tmpfs := range step.Tmpfs {
+		if err := validateTmpfs(tmpfs); err != nil {
+			return &ParseError{Path: fmt.Sprintf("%s.tmpfs[%d]", path, i), Message: err.Error()}
+		}
+	}
+
+	for i, device := range step.Devices {
+		if err := validateDevice(device); err != nil {
+			return &ParseError{Path: fmt.Sprintf("%s.devices[%d]", path, i), Message: err.Error()}
+		}
+	}
+
+	if step.HealthCheck != nil {
+		if err := validateHealthCheck(step); err != nil {
+			return &ParseError{Path: path + ".healthcheck", Message: err.Error()}
+		}
+	}
+
+	if step.Cache != nil {
+		if err := validateCache(step); err != nil {
+			return &ParseError{Path: path + ".cache", Message: err.Error()}
+		}
+	}
+
+	switch {
+	case step.MemLimit < 0:
+		return &ParseError{Path: path + ".mem_limit", Message: "memory limit cannot be negative"}
+	case step.MemLimit > 0 && step.MemLimit < minMemLimit:
+		return &ParseError{Path: path + ".mem_limit", Message: "memory limit must be at least 4MB"}
+	case step.MemSwapLimit < -1:
+		return &ParseError{Path: path + ".memswap_limit", Message: "memory swap limit must be -1 or greater"}
+	case step.MemSwapLimit > 0 && step.MemSwapLimit < step.MemLimit:
+		return &ParseError{Path: path + ".memswap_limit", Message: "memory swap limit must be greater than the memory limit"}
+	case step.MemSwapLimit > 0 && step.MemLimit == 0:
+		return &ParseError{Path: path + ".memswap_limit", Message: "memory swap limit requires a memory limit"}
+	case step.ShmSize < 0:
+		return &ParseError{Path: path + ".shm_size", Message: "shm size cannot be negative"}
+	case step.CPUQuota < 0:
+		return &ParseError{Path: path + ".cpu_quota", Message: "cpu quota cannot be negative"}
+	case step.CPUQuota > 0 && step.CPUQuota < minCPUQuota:
+		return &ParseError{Path: path + ".cpu_quota", Message: "cpu quota must be at least 1000"}
+	case step.CPUShares < 0:
+		return &ParseError{Path: path + ".cpu_shares", Message: "cpu shares cannot be negative"}
+	case step.CPUSet != "" && !cpusetRegexp.MatchString(step.CPUSet):
+		return &ParseError{Path: path + ".cpu_set", Message: fmt.Sprintf("invalid cpu set %q", step.CPUSet)}
+	}
+	return nil
+}
+
+// validateHealthCheck validates the readiness probe of a detached step,
+// which must define exactly one of the tcp, exec and http probes.
+func validateHealthCheck(step *backend.Step) error {
+	check := step.HealthCheck
+	var probes int
+	if check.TCP != 0 {
+		probes++
+	}
+	if len(check.Exec) != 0 {
+		probes++
+	}
+	if check.HTTP != nil {
+		probes++
+	}
+
+	switch {
+	case !step.Detached:
+		return fmt.Errorf("health check requires a detached step")
+	case probes != 1:
+		return fmt.Errorf("health check must define exactly one of tcp, exec and http")
+	case check.TCP < 0 || check.TCP > 65535:
+		return fmt.Errorf("invalid tcp port %d", check.TCP)
+	case check.HTTP != nil && (check.HTTP.Port < 1 || check.HTTP.Port > 65535):
+		return fmt.Errorf("invalid http port %d", check.HTTP.Port)
+	case check.HTTP != nil && check.HTTP.Path != "" && !strings.HasPrefix(check.HTTP.Path, "/"):
+		return fmt.Errorf("invalid http path %q, path must be absolute", check.HTTP.Path)
+	case check.Interval < 0:
+		return fmt.Errorf("interval cannot be negative")
+	case check.Timeout < 0:
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	return nil
+}
+
+// validateCache validates a cache step, which saves paths relative to
+// the step working directory.
+func validateCache(step *backend.Step) error {
+	cache := step.Cache
+	switch {
+	case cache.Action != CacheRestore && cache.Action != CacheSave:
+		return fmt.Errorf("invalid cache action %q, expected restore or save", cache.Action)
+	case cache.Key == "":
+		return fmt.Errorf("cache key is required")
+	case cache.Action == CacheSave && len(cache.Paths) == 0:
+		return fmt.Errorf("cache save requires paths")
+	case step.Detached:
+		return fmt.Errorf("cache step cannot be detached")
+	}
+	for _, p := range cache.Paths {
+		clean := path.Clean(p)
+		if p == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("invalid cache path %q, path must be inside the working directory", p)
+		}
+	}
+	return nil
+}
+
+// validateTmpfs validates a tmpfs mount in the path[:options] format,
+// where options is a comma separated list of mount options.
+func validateTmpfs(tmpfs string) error {
+	parts := strings.SplitN(tmpfs, ":", 2)
+	if !strings.HasPrefix(parts[0], "/") {
+		return fmt.Errorf("invalid tmpfs %q, path must be absolute", tmpfs)
+	}
+	if len(parts) == 2 {
+		for _, opt := range strings.Split(parts[1], ",") {
+			if opt == "" || strings.HasPrefix(opt, "=") {
+				return fmt.Errorf("invalid tmpfs %q, malformed mount option", tmpfs)
+			}
+		}
+	}
+	return nil
+}
+
+// validateDevice validates a device mapping in the
+// host[:container[:permissions]] format.
+func validateDevice(device string) error {
+	parts := strings.Split(device, ":")
+	if len(parts) > 3 {
+		return fmt.Errorf("invalid device %q, expected host[:container[:permissions]]", device)
+	}
+	if !strings.HasPrefix(parts[0], "/") {
+		return fmt.Errorf("invalid device %q, host path must be absolute", device)
+	}
+	// the permissions may follow the host path directly.
+	if len(parts) == 2 && isDevicePermissions(parts[1]) {
+		return nil
+	}
+	if len(parts) > 1 && !strings.HasPrefix(parts[1], "/") {
+		return fmt.Errorf("invalid device %q, container path must be absolute", device)
+	}
+	if len(parts) > 2 && !isDevicePermissions(parts[2]) {
+		return fmt.Errorf("invalid device %q, permissions must be a combination of r, w and m", device)
+	}
+	return nil
+}
+
+func isDevicePermissions(s string) bool {
+	return s != "" && strings.Trim(s, "rwm") == ""
+}
@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSamples(t *testing.T) {
+	files, err := filepath.Glob("../samples/*/pipeline.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// matrix pipelines are compiled to a list of jobs.
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			continue
+		}
+		if _, err := ParseBytes(data); err != nil {
+			t.Errorf("%s: %s", file, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	config, err := ParseString(`{
+  "pipeline": [
+    {
+      "name": "build",
+      "steps": [
+        {
+          "name": "build",
+          "image": "golang:1.9",
+          "volumes": [ "default:/go", "/var/run/docker.sock:/var/run/docker.sock:ro" ],
+          "networks": [ { "name": "default", "aliases": [ "build" ] } ],
+          "tmpfs": [ "/run:rw,noexec,size=65536k" ],
+          "devices": [ "/dev/fuse", "/dev/sda:/dev/xvda:rwm", "/dev/sdb:r" ],
+          "mem_limit": 536870912,
+          "memswap_limit": -1,
+          "cpu_quota": 50000,
+          "cpu_set": "0-2,4",
+          "timeout": 60000000000
+        }
+      ]
+    }
+  ],
+  "networks": [ { "name": "default", "driver": "bridge" } ],
+  "volumes": [ { "name": "default", "driver": "local" } ]
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Stages[0].Steps[0].Image, "golang:1.9"; got != want {
+		t.Errorf("Want image %q, got %q", want, got)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		config string
+		path   string
+	}{
+		{
+			config: `{"pipeline": [], "stages": []}`,
+			path:   "$.stages",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build", "imag": "golang"}]}]}`,
+			path:   "$.pipeline[0].steps[0].imag",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build", "environment": {"GOOS": 1}}]}]}`,
+			path:   `$.pipeline[0].steps[0].environment["GOOS"]`,
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build", "mem_limit": "1gb"}]}]}`,
+			path:   "$.pipeline[0].steps[0].mem_limit",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build"}]}, {"steps": [{"name": "build"}]}]}`,
+			path:   "$.pipeline[1].steps[0].name",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build", "volumes": ["cache:/cache"]}]}]}`,
+			path:   "$.pipeline[0].steps[0].volumes[0]",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build", "networks": [{"name": "default"}]}]}]}`,
+			path:   "$.pipeline[0].steps[0].networks[0].name",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build", "mem_limit": 1024}]}]}`,
+			path:   "$.pipeline[0].steps[0].mem_limit",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build", "mem_limit": 536870912, "memswap_limit": 1024}]}]}`,
+			path:   "$.pipeline[0].steps[0].memswap_limit",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build", "cpu_quota": -1}]}]}`,
+			path:   "$.pipeline[0].steps[0].cpu_quota",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build", "cpu_set": "0-"}]}]}`,
+			path:   "$.pipeline[0].steps[0].cpu_set",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build", "tmpfs": ["run:size=10m"]}]}]}`,
+			path:   "$.pipeline[0].steps[0].tmpfs[0]",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "build", "devices": ["/dev/fuse:/dev/fuse:rwx"]}]}]}`,
+			path:   "$.pipeline[0].steps[0].devices[0]",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "database", "healthcheck": {"tcp": 5432}}]}]}`,
+			path:   "$.pipeline[0].steps[0].healthcheck",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "database", "detach": true, "healthcheck": {"tcp": 5432, "exec": ["pg_isready"]}}]}]}`,
+			path:   "$.pipeline[0].steps[0].healthcheck",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "web", "detach": true, "healthcheck": {"http": {"path": "/health"}}}]}]}`,
+			path:   "$.pipeline[0].steps[0].healthcheck",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "cache", "cache": {"action": "load", "key": "npm"}}]}]}`,
+			path:   "$.pipeline[0].steps[0].cache",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "cache", "cache": {"action": "save", "key": "npm"}}]}]}`,
+			path:   "$.pipeline[0].steps[0].cache",
+		},
+		{
+			config: `{"pipeline": [{"steps": [{"name": "cache", "cache": {"action": "save", "key": "npm", "paths": ["../node_modules"]}}]}]}`,
+			path:   "$.pipeline[0].steps[0].cache",
+		},
+		{
+			config: "{\n  \"pipeline\": [,]\n}",
+			path:   "$",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := ParseString(test.config)
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("Want ParseError for %s, got %v", test.config, err)
+			continue
+		}
+		if perr.Path != test.path {
+			t.Errorf("Want error path %s, got %s", test.path, perr)
+		}
+	}
+}
+
+func TestParseErrorMessage(t *testing.T) {
+	_, err := ParseString("{\n  \"pipeline\": [,]\n}")
+	got, want := err.Error(), "$ : invalid character ',' looking for beginning of value at line 2, column 16"
+	if got != want {
+		t.Errorf("Want error message %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,437 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/cache"
+	"github.com/marjoram/pipeline/pipeline/multipart"
+)
+
+type (
+	// State defines the pipeline and process state.
+	State struct {
+		// Global state of the pipeline.
+		Pipeline struct {
+			// Pipeline time started
+			Time int64 `json:"time"`
+			// Current pipeline step
+			Step *backend.Step `json:"step"`
+			// Current step attempt, starting at 1
+			Attempt int `json:"attempt"`
+			// Current pipeline error state
+			Error error `json:"error"`
+		}
+
+		// Current process state.
+		Process *backend.State
+	}
+)
+
+// Runtime is a configuration runtime.
+type Runtime struct {
+	mu      sync.Mutex
+	err     error
+	failed  map[*backend.Stage]error
+	spec    *backend.Config
+	engine  backend.Engine
+	started int64
+
+	ctx     context.Context
+	stages  map[*backend.Stage]context.Context
+	cancels []context.CancelFunc
+	limiter Limiter
+	limits  map[*backend.Stage]Limiter
+	shared  Limiter
+	tracer  Tracer
+	logger  Logger
+	cache   cache.Store
+}
+
+// New returns a new runtime using the specified runtime
+// configuration and runtime engine.
+func New(spec *backend.Config, opts ...Option) *Runtime {
+	r := new(Runtime)
+	r.spec = spec
+	r.ctx = context.Background()
+	for _, opts := range opts {
+		opts(r)
+	}
+	return r
+}
+
+// Run starts the runtime and waits for it to complete.
+func (r *Runtime) Run() error {
+	defer func() {
+		// the runtime context may already be cancelled, which must
+		// not prevent the pipeline environment from being removed.
+		r.engine.Destroy(context.Background(), r.spec)
+	}()
+	defer func() {
+		for _, cancel := range r.cancels {
+			cancel()
+		}
+	}()
+
+	graph, err := newGraph(r.spec)
+	if err != nil {
+		return err
+	}
+
+	r.started = time.Now().Unix()
+	if err := r.engine.Setup(r.ctx, r.spec); err != nil {
+		return err
+	}
+
+	// the steps return once the runtime context is cancelled, and are
+	// waited for so that the environment is not destroyed under them.
+	<-r.execGraph(graph)
+	if r.ctx.Err() != nil {
+		return ErrCancel
+	}
+	return r.failure()
+}
+
+//
+//
+//
+
+// execGraph starts every step in the graph as soon as the steps it
+// depends on have finished, and signals once all steps are complete.
+func (r *Runtime) execGraph(g *graph) <-chan error {
+	var eg errgroup.Group
+	done := make(chan error, 1)
+
+	finished := map[string]chan struct{}{}
+	for _, step := range g.steps {
+		finished[step.Name] = make(chan struct{})
+	}
+
+	for _, step := range g.steps {
+		step := step
+		eg.Go(func() error {
+			defer close(finished[step.Name])
+
+			for _, dep := range g.deps[step.Name] {
+				select {
+				case <-r.ctx.Done():
+					return ErrCancel
+				case <-finished[dep]:
+				}
+			}
+			if r.ctx.Err() != nil {
+				return ErrCancel
+			}
+
+			err := r.exec(g.stages[step.Name], step)
+			if err != nil {
+				r.fail(g.stages[step.Name], err)
+			}
+			return err
+		})
+	}
+
+	go func() {
+		done <- eg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// fail records the pipeline error, and the first error of the stage.
+func (r *Runtime) fail(stage *backend.Stage, err error) {
+	r.mu.Lock()
+	r.err = err
+	if r.failed == nil {
+		r.failed = map[*backend.Stage]error{}
+	}
+	if _, ok := r.failed[stage]; !ok {
+		r.failed[stage] = err
+	}
+	r.mu.Unlock()
+}
+
+// failure returns the pipeline error, if any.
+func (r *Runtime) failure() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// stepFailure returns the pipeline error that decides whether the step
+// runs. A step that declares depends_on sees the pipeline error when it
+// starts. A step that does not only sees the errors of the previous
+// stages, so that the steps of a stage run together like they did
+// before steps were scheduled from the dependency graph, even when a
+// sibling fails before the step acquires a slot to run.
+func (r *Runtime) stepFailure(stage *backend.Stage, proc *backend.Step) error {
+	if proc.DependsOn != nil {
+		return r.failure()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var err error
+	for _, prev := range r.spec.Stages {
+		if prev == stage {
+			break
+		}
+		if perr, ok := r.failed[prev]; ok {
+			err = perr
+		}
+	}
+	return err
+}
+
+// stageContext returns the context bounding the steps of the stage.
+// The stage timeout starts counting when its first step starts.
+func (r *Runtime) stageContext(stage *backend.Stage) context.Context {
+	if stage.Timeout == 0 {
+		return r.ctx
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stages == nil {
+		r.stages = map[*backend.Stage]context.Context{}
+	}
+	ctx, ok := r.stages[stage]
+	if !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(r.ctx, stage.Timeout)
+		r.stages[stage] = ctx
+		r.cancels = append(r.cancels, cancel)
+	}
+	return ctx
+}
+
+// stageLimiter returns the limiter bounding the concurrently running
+// steps of the stage, which overrides the runtime limiter when the
+// stage defines its own limit.
+func (r *Runtime) stageLimiter(stage *backend.Stage) Limiter {
+	if stage.MaxProcs <= 0 {
+		return r.limiter
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.limits == nil {
+		r.limits = map[*backend.Stage]Limiter{}
+	}
+	limiter, ok := r.limits[stage]
+	if !ok {
+		limiter = NewLimiter(stage.MaxProcs)
+		r.limits[stage] = limiter
+	}
+	return limiter
+}
+
+// acquire blocks until the step may run without exceeding the stage,
+// runtime or shared limits, and returns a function that releases the
+// acquired slots. Limiters are always acquired in the same order to
+// prevent runtimes sharing a limiter from deadlocking.
+func (r *Runtime) acquire(stage *backend.Stage) (func(), error) {
+	var acquired []Limiter
+	release := func() {
+		for _, limiter := range acquired {
+			limiter.Release()
+		}
+	}
+	for _, limiter := range []Limiter{r.stageLimiter(stage), r.shared} {
+		if limiter == nil {
+			continue
+		}
+		if err := limiter.Acquire(r.ctx); err != nil {
+			release()
+			return nil, ErrCancel
+		}
+		acquired = append(acquired, limiter)
+	}
+	return release, nil
+}
+
+//
+//
+//
+
+func (r *Runtime) exec(stage *backend.Stage, proc *backend.Step) error {
+	// cache steps are skipped when the runtime does not have a
+	// cache store.
+	if !shouldRun(proc, r.stepFailure(stage, proc)) || (proc.Cache != nil && r.cache == nil) {
+		return r.skip(proc)
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts(proc); attempt++ {
+		if attempt > 1 {
+			// the previous container must be removed before the
+			// step can be started again under the same name. Only
+			// exited steps are retried, so it is not killed.
+			if rerr := r.engine.Remove(r.ctx, proc); rerr != nil {
+				return rerr
+			}
+
+			select {
+			case <-r.ctx.Done():
+				return ErrCancel
+			case <-time.After(backoff(proc.Retry.Backoff, attempt-1)):
+			}
+		}
+		release, aerr := r.acquire(stage)
+		if aerr != nil {
+			return aerr
+		}
+		err = r.execAttempt(stage, proc, attempt)
+		release()
+		if !retryable(proc, err) {
+			break
+		}
+	}
+	return err
+}
+
+// timeoutError returns the error of the step that exceeded its step or
+// stage timeout.
+func (r *Runtime) timeoutError(stage *backend.Stage, proc *backend.Step, stageCtx context.Context) *TimeoutError {
+	timeout := proc.Timeout
+	if stageCtx.Err() != nil {
+		timeout = stage.Timeout
+	}
+	return &TimeoutError{
+		Name:    proc.Name,
+		Timeout: timeout,
+	}
+}
+
+// skip reports the step as skipped to the tracer.
+func (r *Runtime) skip(proc *backend.Step) error {
+	if r.tracer == nil {
+		return nil
+	}
+	state := new(State)
+	state.Pipeline.Time = r.started
+	state.Pipeline.Error = r.failure()
+	state.Pipeline.Step = proc
+	state.Process = &backend.State{Skipped: true}
+	if err := r.tracer.Trace(state); err != nil && err != ErrSkip {
+		return err
+	}
+	return nil
+}
+
+func (r *Runtime) execAttempt(stage *backend.Stage, proc *backend.Step, attempt int) error {
+	stageCtx := r.stageContext(stage)
+	ctx := stageCtx
+	cancel := func() {}
+	if proc.Timeout != 0 {
+		ctx, cancel = context.WithTimeout(stageCtx, proc.Timeout)
+	}
+	// the step context also bounds the log stream, and is released
+	// once the step is complete and its logs are read.
+	refs := int32(1)
+	release := func() {
+		if atomic.AddInt32(&refs, -1) == 0 {
+			cancel()
+		}
+	}
+	defer release()
+
+	if r.tracer != nil {
+		state := new(State)
+		state.Pipeline.Time = r.started
+		state.Pipeline.Error = r.failure()
+		state.Pipeline.Step = proc
+		state.Pipeline.Attempt = attempt
+		state.Process = new(backend.State) // empty
+		if err := r.tracer.Trace(state); err == ErrSkip {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if proc.Cache != nil {
+		return r.execCache(ctx, proc, attempt)
+	}
+
+	// the step timeout bounds the start of the step, such as a slow
+	// image pull.
+	if err := r.engine.Exec(ctx, proc); err != nil {
+		if r.ctx.Err() == nil && ctx.Err() != nil {
+			return r.timeoutError(stage, proc, stageCtx)
+		}
+		return err
+	}
+
+	if r.logger != nil {
+		rc, err := r.engine.Tail(ctx, proc)
+		if err != nil {
+			return err
+		}
+
+		atomic.AddInt32(&refs, 1)
+		go func() {
+			r.logger.Log(proc, multipart.New(rc))
+			rc.Close()
+			release()
+		}()
+	}
+
+	// the steps depending on a detached step are started once its
+	// health check succeeds.
+	if proc.Detached {
+		if proc.HealthCheck == nil {
+			return nil
+		}
+		return r.waitReady(ctx, proc)
+	}
+
+	var timeout *TimeoutError
+	wait, err := r.engine.Wait(ctx, proc)
+	switch {
+	case err == nil:
+	case r.ctx.Err() != nil:
+		return ErrCancel
+	case ctx.Err() != nil:
+		timeout = r.timeoutError(stage, proc, stageCtx)
+		// kill the step once its timeout expires and wait for it
+		// to exit in order to report its final state.
+		if err := r.engine.Kill(r.ctx, proc); err != nil {
+			return err
+		}
+		if wait, err = r.engine.Wait(r.ctx, proc); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	if r.tracer != nil {
+		state := new(State)
+		state.Pipeline.Time = r.started
+		state.Pipeline.Error = r.failure()
+		state.Pipeline.Step = proc
+		state.Pipeline.Attempt = attempt
+		state.Process = wait
+		if err := r.tracer.Trace(state); err != nil {
+			return err
+		}
+	}
+
+	if timeout != nil {
+		return timeout
+	} else if wait.OOMKilled {
+		return &OomError{
+			Name: proc.Name,
+			Code: wait.ExitCode,
+		}
+	} else if wait.ExitCode != 0 {
+		return &ExitError{
+			Name: proc.Name,
+			Code: wait.ExitCode,
+		}
+	}
+	return nil
+}
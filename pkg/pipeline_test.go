@@ -0,0 +1,577 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/backend/fake"
+	"github.com/marjoram/pipeline/pipeline/multipart"
+)
+
+func TestRun(t *testing.T) {
+	engine := fake.New()
+	spec := testSpec(
+		&backend.Step{Name: "clone", OnSuccess: true},
+		&backend.Step{Name: "build", OnSuccess: true},
+		&backend.Step{Name: "notify", OnSuccess: true, OnFailure: true},
+	)
+	if err := New(spec, WithEngine(engine)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"clone", "build", "notify"}
+	if got := engine.Called("exec"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want steps executed in order %v, got %v", want, got)
+	}
+	calls := engine.Calls()
+	if got := calls[0].Method; got != "setup" {
+		t.Errorf("Want setup called first, got %s", got)
+	}
+	if got := calls[len(calls)-1].Method; got != "destroy" {
+		t.Errorf("Want destroy called last, got %s", got)
+	}
+}
+
+func TestRunExitError(t *testing.T) {
+	engine := fake.New().Script("build", fake.Step{ExitCode: 2})
+	spec := testSpec(
+		&backend.Step{Name: "build", OnSuccess: true},
+		&backend.Step{Name: "deploy", OnSuccess: true},
+		&backend.Step{Name: "notify", OnFailure: true},
+	)
+	err := New(spec, WithEngine(engine)).Run()
+	if xerr, ok := err.(*ExitError); !ok || xerr.Code != 2 || xerr.Name != "build" {
+		t.Errorf("Want exit error with code 2, got %v", err)
+	}
+	want := []string{"build", "notify"}
+	if got := engine.Called("exec"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want steps %v executed, got %v", want, got)
+	}
+}
+
+func TestRunOomError(t *testing.T) {
+	engine := fake.New().Script("build", fake.Step{ExitCode: 137, OOMKilled: true})
+	spec := testSpec(&backend.Step{Name: "build", OnSuccess: true})
+
+	err := New(spec, WithEngine(engine)).Run()
+	if _, ok := err.(*OomError); !ok {
+		t.Errorf("Want oom error, got %v", err)
+	}
+}
+
+func TestRunExecError(t *testing.T) {
+	want := errors.New("image not found")
+	engine := fake.New().Script("build", fake.Step{ExecErr: want})
+	spec := testSpec(
+		&backend.Step{Name: "build", OnSuccess: true},
+		&backend.Step{Name: "deploy", OnSuccess: true},
+	)
+	if err := New(spec, WithEngine(engine)).Run(); err != want {
+		t.Errorf("Want exec error, got %v", err)
+	}
+	if got := engine.Called("exec"); len(got) != 1 {
+		t.Errorf("Want pipeline stopped after exec error, got %v", got)
+	}
+	if got := engine.Called("destroy"); len(got) != 1 {
+		t.Errorf("Want pipeline destroyed after exec error")
+	}
+}
+
+func TestRunSetupError(t *testing.T) {
+	engine := fake.New()
+	engine.SetupErr = errors.New("network exists")
+	spec := testSpec(&backend.Step{Name: "build", OnSuccess: true})
+
+	if err := New(spec, WithEngine(engine)).Run(); err != engine.SetupErr {
+		t.Errorf("Want setup error, got %v", err)
+	}
+	if got := engine.Called("exec"); len(got) != 0 {
+		t.Errorf("Want no steps executed, got %v", got)
+	}
+	if got := engine.Called("destroy"); len(got) != 1 {
+		t.Errorf("Want pipeline destroyed after setup error")
+	}
+}
+
+func TestRunDetached(t *testing.T) {
+	engine := fake.New().Script("redis", fake.Step{Delay: time.Hour})
+	spec := testSpec(
+		&backend.Step{Name: "redis", Detached: true, OnSuccess: true},
+		&backend.Step{Name: "test", OnSuccess: true},
+	)
+	if err := New(spec, WithEngine(engine)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"test"}
+	if got := engine.Called("wait"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want detached step not awaited, got %v", got)
+	}
+}
+
+func TestRunHealthCheck(t *testing.T) {
+	engine := fake.New().Script("database", fake.Step{Delay: time.Hour, Unready: 2})
+	spec := testSpec(
+		&backend.Step{
+			Name:      "database",
+			Detached:  true,
+			OnSuccess: true,
+			HealthCheck: &backend.HealthCheck{
+				TCP:      5432,
+				Interval: 10 * time.Millisecond,
+			},
+		},
+		&backend.Step{Name: "test", OnSuccess: true},
+	)
+	if err := New(spec, WithEngine(engine)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, call := range engine.Calls() {
+		if call.Method == "probe" || call.Method == "exec" {
+			got = append(got, call.String())
+		}
+	}
+	want := []string{"exec:database", "probe:database", "probe:database", "probe:database", "exec:test"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Want test started once the database is ready %v, got %v", want, got)
+	}
+}
+
+func TestRunHealthCheckTimeout(t *testing.T) {
+	engine := fake.New().Script("database", fake.Step{Delay: time.Hour, Unready: -1})
+	spec := testSpec(
+		&backend.Step{
+			Name:      "database",
+			Detached:  true,
+			OnSuccess: true,
+			HealthCheck: &backend.HealthCheck{
+				Exec:     []string{"pg_isready"},
+				Interval: 10 * time.Millisecond,
+				Timeout:  50 * time.Millisecond,
+			},
+		},
+		&backend.Step{Name: "test", OnSuccess: true},
+	)
+	err := New(spec, WithEngine(engine)).Run()
+	if herr, ok := err.(*HealthError); !ok || herr.Name != "database" || herr.Timeout != 50*time.Millisecond {
+		t.Errorf("Want health error, got %v", err)
+	}
+	want := []string{"database"}
+	if got := engine.Called("exec"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want dependent steps skipped, got %v", got)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	engine := fake.New().Script("build", fake.Step{Delay: time.Hour})
+	spec := testSpec(
+		&backend.Step{Name: "build", OnSuccess: true},
+		&backend.Step{Name: "deploy", OnSuccess: true},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err := New(spec, WithEngine(engine), WithContext(ctx)).Run()
+	if err != ErrCancel {
+		t.Errorf("Want cancel error, got %v", err)
+	}
+	if got := engine.Called("exec"); len(got) != 1 {
+		t.Errorf("Want pipeline stopped after cancel, got %v", got)
+	}
+	if got := engine.Called("destroy"); len(got) != 1 {
+		t.Errorf("Want pipeline destroyed after cancel")
+	}
+}
+
+func TestRunTracer(t *testing.T) {
+	engine := fake.New().Script("build", fake.Step{ExitCode: 1})
+	spec := testSpec(
+		&backend.Step{Name: "clone", OnSuccess: true},
+		&backend.Step{Name: "build", OnSuccess: true},
+		&backend.Step{Name: "notify", OnFailure: true},
+	)
+
+	var mu sync.Mutex
+	var states []string
+	tracer := TraceFunc(func(state *State) error {
+		mu.Lock()
+		defer mu.Unlock()
+		name := state.Pipeline.Step.Name
+		if !state.Process.Exited {
+			states = append(states, name+":started")
+		} else {
+			states = append(states, name+":exited")
+		}
+		if name == "notify" {
+			return ErrSkip
+		}
+		return nil
+	})
+
+	New(spec, WithEngine(engine), WithTracer(tracer)).Run()
+
+	want := []string{
+		"clone:started",
+		"clone:exited",
+		"build:started",
+		"build:exited",
+		"notify:started",
+	}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("Want trace states %v, got %v", want, states)
+	}
+	if got := engine.Called("exec"); len(got) != 2 {
+		t.Errorf("Want skipped step not executed, got %v", got)
+	}
+}
+
+func TestRunLogger(t *testing.T) {
+	engine := fake.New().Script("build", fake.Step{Logs: "hello world\n"})
+	spec := testSpec(&backend.Step{Name: "build", OnSuccess: true})
+
+	logs := make(chan string, 1)
+	logger := LogFunc(func(step *backend.Step, r multipart.Reader) error {
+		part, err := r.NextPart()
+		if err != nil {
+			return err
+		}
+		out, _ := ioutil.ReadAll(part)
+		logs <- string(out)
+		return nil
+	})
+	if err := New(spec, WithEngine(engine), WithLogger(logger)).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-logs:
+		if want := "hello world\n"; got != want {
+			t.Errorf("Want logs %q, got %q", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want step logs")
+	}
+}
+
+func TestRunDependsOn(t *testing.T) {
+	engine := fake.New().
+		Script("backend", fake.Step{Delay: 100 * time.Millisecond}).
+		Script("frontend", fake.Step{Delay: 100 * time.Millisecond})
+	spec := &backend.Config{
+		Stages: []*backend.Stage{
+			{Steps: []*backend.Step{{Name: "clone", OnSuccess: true}}},
+			{Steps: []*backend.Step{{Name: "backend", OnSuccess: true, DependsOn: []string{"clone"}}}},
+			{Steps: []*backend.Step{{Name: "frontend", OnSuccess: true, DependsOn: []string{"clone"}}}},
+			{Steps: []*backend.Step{{Name: "deploy", OnSuccess: true, DependsOn: []string{"backend", "frontend"}}}},
+		},
+	}
+	start := time.Now()
+	if err := New(spec, WithEngine(engine)).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the backend and frontend steps run concurrently, so the
+	// pipeline takes less time than running them in sequence.
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("Want independent steps executed concurrently, took %s", elapsed)
+	}
+	order := engine.Called("exec")
+	if got := order[0]; got != "clone" {
+		t.Errorf("Want clone executed first, got %s", got)
+	}
+	if got := order[3]; got != "deploy" {
+		t.Errorf("Want deploy executed last, got %s", got)
+	}
+}
+
+func TestRunStageFailure(t *testing.T) {
+	engine := fake.New().
+		Script("clone", fake.Step{Delay: 50 * time.Millisecond}).
+		Script("lint", fake.Step{ExitCode: 1})
+	spec := &backend.Config{
+		Stages: []*backend.Stage{
+			{Steps: []*backend.Step{{Name: "clone", OnSuccess: true}}},
+			{Steps: []*backend.Step{
+				{Name: "lint", OnSuccess: true, DependsOn: []string{}},
+				{Name: "build", OnSuccess: true},
+			}},
+			{Steps: []*backend.Step{{Name: "deploy", OnSuccess: true}}},
+		},
+	}
+	err := New(spec, WithEngine(engine)).Run()
+	if xerr, ok := err.(*ExitError); !ok || xerr.Name != "lint" {
+		t.Errorf("Want lint exit error, got %v", err)
+	}
+
+	// the lint step fails before the build step starts, which still
+	// runs since the failure applies from the next stage.
+	got := engine.Called("exec")
+	sort.Strings(got)
+	if want := []string{"build", "clone", "lint"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want steps %v executed, got %v", want, got)
+	}
+}
+
+func TestRunRetry(t *testing.T) {
+	engine := fake.New().Script("build",
+		fake.Step{ExitCode: 1},
+		fake.Step{ExitCode: 1},
+		fake.Step{ExitCode: 0},
+	)
+	spec := testSpec(&backend.Step{
+		Name:      "build",
+		OnSuccess: true,
+		Retry:     &backend.Retry{Attempts: 3},
+	})
+
+	var mu sync.Mutex
+	var attempts []int
+	tracer := TraceFunc(func(state *State) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if state.Process.Exited {
+			attempts = append(attempts, state.Pipeline.Attempt)
+		}
+		return nil
+	})
+
+	if err := New(spec, WithEngine(engine), WithTracer(tracer)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := attempts, []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want attempts %v traced, got %v", want, got)
+	}
+	if got := engine.Called("remove"); len(got) != 2 {
+		t.Errorf("Want step removed before each retry, got %d removals", len(got))
+	}
+}
+
+func TestRunRetryExhausted(t *testing.T) {
+	engine := fake.New().Script("build", fake.Step{ExitCode: 1})
+	spec := testSpec(&backend.Step{
+		Name:      "build",
+		OnSuccess: true,
+		Retry:     &backend.Retry{Attempts: 2},
+	})
+	err := New(spec, WithEngine(engine)).Run()
+	if _, ok := err.(*ExitError); !ok {
+		t.Errorf("Want exit error, got %v", err)
+	}
+	if got := engine.Called("exec"); len(got) != 2 {
+		t.Errorf("Want 2 attempts, got %d", len(got))
+	}
+}
+
+func TestRunRetryRemoveError(t *testing.T) {
+	engine := fake.New().Script("build", fake.Step{ExitCode: 1, RemoveErr: errors.New("remove failed")})
+	spec := testSpec(&backend.Step{
+		Name:      "build",
+		OnSuccess: true,
+		Retry:     &backend.Retry{Attempts: 3},
+	})
+	err := New(spec, WithEngine(engine)).Run()
+	if err == nil || err.Error() != "remove failed" {
+		t.Errorf("Want remove error, got %v", err)
+	}
+	if got := engine.Called("exec"); len(got) != 1 {
+		t.Errorf("Want no retry once the step cannot be removed, got %d attempts", len(got))
+	}
+	if got := engine.Called("kill"); len(got) != 0 {
+		t.Errorf("Want exited step not killed before the retry, got %v", got)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	engine := fake.New().Script("build", fake.Step{Delay: time.Hour})
+	spec := testSpec(
+		&backend.Step{Name: "build", OnSuccess: true, Timeout: 50 * time.Millisecond},
+		&backend.Step{Name: "notify", OnFailure: true},
+	)
+	err := New(spec, WithEngine(engine)).Run()
+	if xerr, ok := err.(*TimeoutError); !ok || xerr.Timeout != 50*time.Millisecond {
+		t.Errorf("Want timeout error, got %v", err)
+	}
+	want := []string{"build", "notify"}
+	if got := engine.Called("exec"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want failure step executed after timeout, got %v", got)
+	}
+	if got := engine.Called("kill"); len(got) != 1 {
+		t.Errorf("Want timed out step killed")
+	}
+}
+
+func TestRunTimeoutExec(t *testing.T) {
+	engine := fake.New().Script("build", fake.Step{ExecDelay: time.Hour})
+	spec := testSpec(&backend.Step{Name: "build", OnSuccess: true, Timeout: 50 * time.Millisecond})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- New(spec, WithEngine(engine)).Run()
+	}()
+	select {
+	case err := <-done:
+		if xerr, ok := err.(*TimeoutError); !ok || xerr.Timeout != 50*time.Millisecond {
+			t.Errorf("Want timeout error starting the step, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Want the start of the step bounded by its timeout")
+	}
+}
+
+func TestRunStageTimeout(t *testing.T) {
+	engine := fake.New().Script("build", fake.Step{Delay: time.Hour})
+	spec := &backend.Config{
+		Stages: []*backend.Stage{
+			{
+				Timeout: 50 * time.Millisecond,
+				Steps:   []*backend.Step{{Name: "build", OnSuccess: true}},
+			},
+		},
+	}
+	err := New(spec, WithEngine(engine)).Run()
+	if xerr, ok := err.(*TimeoutError); !ok || xerr.Timeout != 50*time.Millisecond {
+		t.Errorf("Want stage timeout error, got %v", err)
+	}
+}
+
+// helper function returns a pipeline configuration with one stage
+// for each step.
+func testSpec(steps ...*backend.Step) *backend.Config {
+	spec := new(backend.Config)
+	for _, step := range steps {
+		spec.Stages = append(spec.Stages, &backend.Stage{
+			Name:  step.Name,
+			Steps: []*backend.Step{step},
+		})
+	}
+	return spec
+}
+
+func TestRunMaxProcs(t *testing.T) {
+	tests := []struct {
+		opts  []Option
+		stage int
+		want  int
+	}{
+		{want: 3},
+		{opts: []Option{WithMaxProcs(2)}, want: 2},
+		{opts: []Option{WithMaxProcs(2)}, stage: 1, want: 1},
+		{opts: []Option{WithLimiter(NewLimiter(1))}, want: 1},
+	}
+	for _, test := range tests {
+		engine := fake.New()
+		spec := &backend.Config{
+			Stages: []*backend.Stage{{MaxProcs: test.stage}},
+		}
+		for _, name := range []string{"backend", "frontend", "docs"} {
+			engine.Script(name, fake.Step{Delay: 50 * time.Millisecond})
+			spec.Stages[0].Steps = append(spec.Stages[0].Steps, &backend.Step{
+				Name:      name,
+				OnSuccess: true,
+			})
+		}
+
+		var mu sync.Mutex
+		var running, max int
+		tracer := TraceFunc(func(state *State) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if state.Process.Exited {
+				running--
+			} else {
+				running++
+			}
+			if running > max {
+				max = running
+			}
+			return nil
+		})
+
+		opts := append(test.opts, WithEngine(engine), WithTracer(tracer))
+		if err := New(spec, opts...).Run(); err != nil {
+			t.Fatal(err)
+		}
+		if max != test.want {
+			t.Errorf("Want at most %d steps running, got %d", test.want, max)
+		}
+	}
+}
+
+func TestRunSharedLimiter(t *testing.T) {
+	limiter := NewLimiter(1)
+
+	var mu sync.Mutex
+	var running, max int
+	tracer := TraceFunc(func(state *State) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if state.Process.Exited {
+			running--
+		} else {
+			running++
+		}
+		if running > max {
+			max = running
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			engine := fake.New().Script("build", fake.Step{Delay: 20 * time.Millisecond})
+			spec := testSpec(&backend.Step{Name: "build", OnSuccess: true})
+			New(spec, WithEngine(engine), WithTracer(tracer), WithLimiter(limiter)).Run()
+		}()
+	}
+	wg.Wait()
+
+	if max != 1 {
+		t.Errorf("Want at most 1 step running across runtimes, got %d", max)
+	}
+}
+
+func TestRunSkipped(t *testing.T) {
+	engine := fake.New()
+	spec := testSpec(
+		&backend.Step{
+			Name:        "build",
+			OnSuccess:   true,
+			Environment: map[string]string{"CI_COMMIT_BRANCH": "develop"},
+		},
+		&backend.Step{
+			Name:        "deploy",
+			OnSuccess:   true,
+			Environment: map[string]string{"CI_COMMIT_BRANCH": "develop"},
+			When: &backend.When{
+				Branch: backend.Constraint{Include: []string{"master"}},
+			},
+		},
+	)
+
+	var skipped []string
+	tracer := TraceFunc(func(state *State) error {
+		if state.Process.Skipped {
+			skipped = append(skipped, state.Pipeline.Step.Name)
+		}
+		return nil
+	})
+	if err := New(spec, WithEngine(engine), WithTracer(tracer)).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := skipped, []string{"deploy"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want skipped steps %v traced, got %v", want, got)
+	}
+	if got, want := engine.Called("exec"), []string{"build"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want steps %v executed, got %v", want, got)
+	}
+}
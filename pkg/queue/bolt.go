@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var taskBucket = []byte("tasks")
+
+// boltStore is a store saving the tasks in a bolt database.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore returns a store that saves the tasks in the bolt database
+// file, which is created if it does not exist.
+func NewBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(taskBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Tasks() ([]*Task, error) {
+	var tasks []*Task
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(taskBucket).ForEach(func(k, v []byte) error {
+			task := new(Task)
+			if err := json.Unmarshal(v, task); err != nil {
+				return err
+			}
+			tasks = append(tasks, task)
+			return nil
+		})
+	})
+	return tasks, err
+}
+
+func (s *boltStore) Put(task *Task) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(taskBucket).Put([]byte(task.ID), data)
+	})
+}
+
+func (s *boltStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(taskBucket).Delete([]byte(id))
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
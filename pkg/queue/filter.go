@@ -0,0 +1,45 @@
+package queue
+
+import (
+	"sort"
+	"strings"
+)
+
+// matches returns true if the agent labels match the task. Every task
+// label must be set to the same value in the agent labels, and the agent
+// labels must match the task selector. Tasks without labels or selector
+// run on any agent.
+func (e *entry) matches(labels map[string]string) bool {
+	for k, v := range e.task.Labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return e.selector.Matches(labels)
+}
+
+// schedulable returns true if the task is running, or if one of the
+// agents can run it.
+func schedulable(e *entry, agents []map[string]string) bool {
+	switch e.task.Status {
+	case StatusPending, StatusBlocked:
+	default:
+		return true
+	}
+	for _, labels := range agents {
+		if e.matches(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// agentKey returns the canonical form of the agent labels.
+func agentKey(labels map[string]string) string {
+	var pairs []string
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,17 @@
+package queue
+
+import "time"
+
+// Option configures a queue option.
+type Option func(*Queue)
+
+// WithLease returns an option to set the time a task is leased to an
+// agent. The task is redelivered if the agent does not extend the lease
+// in time.
+func WithLease(lease time.Duration) Option {
+	return func(q *Queue) {
+		if lease > 0 {
+			q.lease = lease
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/marjoram/pipeline/pipeline/rpc"
+)
+
+// Reporter receives the progress of the pipelines run by the agents.
+type Reporter interface {
+	Init(c context.Context, id string, state rpc.State) error
+	Done(c context.Context, id string, state rpc.State) error
+	Update(c context.Context, id string, state rpc.State) error
+	Upload(c context.Context, id string, file *rpc.File) error
+	Log(c context.Context, id string, line *rpc.Line) error
+}
+
+// Peer serves the queued pipelines to the agents. The data of the tasks
+// is a json encoded rpc.Pipeline.
+type Peer struct {
+	queue    *Queue
+	reporter Reporter
+}
+
+// NewPeer returns a peer of the queue. The progress of the pipelines is
+// passed to the reporter, which may be nil.
+func NewPeer(queue *Queue, reporter Reporter) *Peer {
+	return &Peer{queue: queue, reporter: reporter}
+}
+
+// Push queues the pipeline. The task labels, selector, dependencies and
+// RunOn are used for scheduling, and the task id and data are set from
+// the pipeline.
+func (p *Peer) Push(c context.Context, pipeline *rpc.Pipeline, task *Task) error {
+	data, err := json.Marshal(pipeline)
+	if err != nil {
+		return err
+	}
+	task.ID = pipeline.ID
+	task.Data = data
+	return p.queue.Push(c, task)
+}
+
+// Next returns the next pipeline the agent labels match.
+func (p *Peer) Next(c context.Context, f rpc.Filter) (*rpc.Pipeline, error) {
+	task, err := p.queue.Poll(c, f.Labels)
+	if err != nil {
+		return nil, err
+	}
+	pipeline := new(rpc.Pipeline)
+	if err := json.Unmarshal(task.Data, pipeline); err != nil {
+		return nil, err
+	}
+	pipeline.ID = task.ID
+	return pipeline, nil
+}
+
+// Wait blocks until the pipeline is complete, or returns an error if it
+// is cancelled.
+func (p *Peer) Wait(c context.Context, id string) error {
+	return p.queue.Wait(c, id)
+}
+
+// Init extends the lease of the started pipeline.
+func (p *Peer) Init(c context.Context, id string, state rpc.State) error {
+	if err := p.queue.Extend(c, id); err != nil {
+		return err
+	}
+	if p.reporter != nil {
+		return p.reporter.Init(c, id, state)
+	}
+	return nil
+}
+
+// Done removes the pipeline from the queue with the status of its exit
+// code.
+func (p *Peer) Done(c context.Context, id string, state rpc.State) error {
+	status := StatusSuccess
+	switch {
+	case state.ExitCode == 130:
+		status = StatusKilled
+	case state.ExitCode != 0 || state.Error != "":
+		status = StatusFailure
+	}
+	if err := p.queue.Done(c, id, status); err != nil {
+		return err
+	}
+	if p.reporter != nil {
+		return p.reporter.Done(c, id, state)
+	}
+	return nil
+}
+
+// Extend extends the lease of the pipeline.
+func (p *Peer) Extend(c context.Context, id string) error {
+	return p.queue.Extend(c, id)
+}
+
+// Update reports the state of a pipeline step.
+func (p *Peer) Update(c context.Context, id string, state rpc.State) error {
+	if p.reporter != nil {
+		return p.reporter.Update(c, id, state)
+	}
+	return nil
+}
+
+// Upload reports a log or artifact file of a pipeline step.
+func (p *Peer) Upload(c context.Context, id string, file *rpc.File) error {
+	if p.reporter != nil {
+		return p.reporter.Upload(c, id, file)
+	}
+	return nil
+}
+
+// Log reports a log line of a pipeline step.
+func (p *Peer) Log(c context.Context, id string, line *rpc.Line) error {
+	if p.reporter != nil {
+		return p.reporter.Log(c, id, line)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/rpc"
+	"github.com/marjoram/pipeline/pipeline/rpc/rpctest"
+)
+
+func TestPeer(t *testing.T) {
+	q := newQueue(t, NewMemoryStore(), WithLease(50*time.Millisecond))
+	defer q.Close()
+	peer := NewPeer(q, nil)
+	server := rpctest.NewServer(peer, rpc.WithPollTimeout(100*time.Millisecond))
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	pipeline := &rpc.Pipeline{
+		ID:      "1",
+		Timeout: 60,
+		Config:  &backend.Config{Stages: []*backend.Stage{{Name: "build"}}},
+	}
+	task := &Task{Labels: map[string]string{"platform": "linux/arm64"}}
+	if err := peer.Push(noContext, pipeline, task); err != nil {
+		t.Fatal(err)
+	}
+
+	amd64 := rpc.Filter{Labels: map[string]string{"platform": "linux/amd64"}}
+	arm64 := rpc.Filter{Labels: map[string]string{"platform": "linux/arm64"}}
+	if work, err := client.Next(noContext, amd64); err != nil || work != nil {
+		t.Fatalf("Want no pipeline for the amd64 agent, got %v, %v", work, err)
+	}
+	work, err := client.Next(noContext, arm64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work == nil || work.ID != "1" || work.Timeout != 60 || work.Config.Stages[0].Name != "build" {
+		t.Fatalf("Want pipeline 1, got %+v", work)
+	}
+
+	// the agent stops extending the lease, and the pipeline is
+	// redelivered to the next agent.
+	work, err = client.Next(noContext, arm64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work == nil || work.ID != "1" {
+		t.Fatalf("Want pipeline 1 redelivered, got %+v", work)
+	}
+	if err := client.Init(noContext, "1", rpc.State{Started: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Done(noContext, "1", rpc.State{Exited: true, ExitCode: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Wait(noContext, "1"); err != nil {
+		t.Errorf("Want wait to return for a complete pipeline, got %s", err)
+	}
+	if err := client.Extend(noContext, "1"); err == nil {
+		t.Errorf("Want error extending a complete pipeline")
+	}
+}
@@ -0,0 +1,604 @@
+// Package queue implements the work queue of the piped server. Tasks are
+// leased to the agents polling the queue, and redelivered to another
+// agent when the lease is not extended, so that a crashed agent does
+// not lose the task.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound indicates the task is not in the queue.
+	ErrNotFound = errors.New("queue: task not found")
+
+	// ErrCancel indicates the task is cancelled.
+	ErrCancel = errors.New("queue: task cancelled")
+)
+
+// Task statuses.
+const (
+	StatusPending = "pending"
+	StatusBlocked = "blocked"
+	StatusRunning = "running"
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusKilled  = "killed"
+	StatusSkipped = "skipped"
+)
+
+// DefaultLease is the default time a task is leased to an agent, unless
+// the agent extends the lease.
+const DefaultLease = 5 * time.Minute
+
+// Task is a unit of work in the queue.
+type Task struct {
+	// ID of the task
+	ID string `json:"id"`
+	// Data of the task, opaque to the queue
+	Data []byte `json:"data"`
+	// Labels the agent must match to run the task
+	Labels map[string]string `json:"labels,omitempty"`
+	// Selector is a label selector expression the agent labels must
+	// match to run the task
+	Selector string `json:"selector,omitempty"`
+	// Dependencies are the ids of the tasks that must complete before
+	// the task runs
+	Dependencies []string `json:"dependencies,omitempty"`
+	// RunOn lists the statuses of the dependencies the task runs on,
+	// which defaults to success. The task is skipped otherwise.
+	RunOn []string `json:"run_on,omitempty"`
+	// DepStatus is the status of the completed dependencies
+	DepStatus map[string]string `json:"dep_status,omitempty"`
+
+	Status   string    `json:"status"`
+	Attempts int       `json:"attempts"`
+	Created  time.Time `json:"created"`
+	Deadline time.Time `json:"deadline,omitempty"`
+}
+
+// Info describes the content of the queue.
+type Info struct {
+	Pending       int `json:"pending"`
+	Blocked       int `json:"blocked"`
+	Running       int `json:"running"`
+	Unschedulable int `json:"unschedulable"`
+	Workers       int `json:"workers"`
+	Agents        int `json:"agents"`
+}
+
+// Queue is a work queue persisted in a store.
+type Queue struct {
+	mu      sync.Mutex
+	store   Store
+	lease   time.Duration
+	now     func() time.Time
+	tasks   map[string]*entry
+	workers map[*worker]struct{}
+	agents  map[string]*agent
+	closed  chan struct{}
+}
+
+type entry struct {
+	task     *Task
+	selector Selector
+	agent    string // agent running the task
+	reported bool   // unschedulable task was logged
+	done     chan struct{}
+	cancel   chan struct{}
+	err      error
+}
+
+type worker struct {
+	agent  string
+	labels map[string]string
+	ch     chan *Task
+}
+
+// agent is a set of agent labels seen by the queue. Agents with the same
+// labels are interchangeable for scheduling.
+type agent struct {
+	labels map[string]string
+	seen   time.Time
+}
+
+// New returns a queue of the tasks in the store. Tasks running when the
+// queue was closed are redelivered once their lease expires.
+func New(store Store, opts ...Option) (*Queue, error) {
+	q := &Queue{
+		store:   store,
+		lease:   DefaultLease,
+		now:     time.Now,
+		tasks:   map[string]*entry{},
+		workers: map[*worker]struct{}{},
+		agents:  map[string]*agent{},
+		closed:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	tasks, err := store.Tasks()
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		if task.DepStatus == nil {
+			task.DepStatus = map[string]string{}
+		}
+		e, err := newEntry(task)
+		if err != nil {
+			return nil, err
+		}
+		if task.Status == StatusKilled {
+			close(e.cancel)
+		}
+		q.tasks[task.ID] = e
+	}
+	// dependencies removed before their status was saved in the blocked
+	// tasks are considered complete.
+	for _, e := range q.tasks {
+		if e.task.Status != StatusBlocked {
+			continue
+		}
+		for _, dep := range e.task.Dependencies {
+			if _, ok := e.task.DepStatus[dep]; !ok && q.tasks[dep] == nil {
+				e.task.DepStatus[dep] = StatusSuccess
+			}
+		}
+		q.resolve(e)
+	}
+	go q.reap()
+	return q, nil
+}
+
+func newEntry(task *Task) (*entry, error) {
+	selector, err := ParseSelector(task.Selector)
+	if err != nil {
+		return nil, err
+	}
+	return &entry{
+		task:     task,
+		selector: selector,
+		done:     make(chan struct{}),
+		cancel:   make(chan struct{}),
+	}, nil
+}
+
+// Push adds the task to the queue. The task is blocked until its
+// dependencies in the queue are complete. Dependencies that are not in
+// the queue are considered complete. An invalid task selector is an
+// error.
+func (q *Queue) Push(ctx context.Context, task *Task) error {
+	if task.ID == "" {
+		return errors.New("queue: task id is required")
+	}
+	e, err := newEntry(clone(task))
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tasks[task.ID]; ok {
+		return fmt.Errorf("queue: task %s is already queued", task.ID)
+	}
+	t := e.task
+	t.Status = StatusBlocked
+	t.Attempts = 0
+	t.Created = q.now()
+	t.Deadline = time.Time{}
+	t.DepStatus = map[string]string{}
+	for _, dep := range t.Dependencies {
+		if _, ok := q.tasks[dep]; !ok {
+			t.DepStatus[dep] = StatusSuccess
+		}
+	}
+	if err := q.store.Put(t); err != nil {
+		return err
+	}
+	q.tasks[t.ID] = e
+	q.resolve(e)
+	q.process()
+	return nil
+}
+
+// Poll blocks until a task the agent labels match is pending, and leases
+// it to the caller.
+func (q *Queue) Poll(ctx context.Context, labels map[string]string) (*Task, error) {
+	w := &worker{agent: agentKey(labels), labels: copyMap(labels), ch: make(chan *Task, 1)}
+	q.mu.Lock()
+	q.workers[w] = struct{}{}
+	q.seen(w)
+	q.process()
+	q.mu.Unlock()
+
+	select {
+	case t := <-w.ch:
+		q.mu.Lock()
+		q.seen(w)
+		q.mu.Unlock()
+		return t, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		delete(q.workers, w)
+		q.seen(w)
+		// a task leased while the poll was cancelled is released.
+		select {
+		case t := <-w.ch:
+			if e, ok := q.tasks[t.ID]; ok && e.task.Status == StatusRunning {
+				e.task.Status = StatusPending
+				e.task.Deadline = time.Time{}
+				q.put(e.task)
+				q.process()
+			}
+		default:
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Extend extends the lease of the running task.
+func (q *Queue) Extend(ctx context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.tasks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	switch e.task.Status {
+	case StatusRunning:
+	case StatusKilled:
+		return ErrCancel
+	default:
+		return ErrNotFound
+	}
+	e.task.Deadline = q.now().Add(q.lease)
+	if a, ok := q.agents[e.agent]; ok {
+		a.seen = q.now()
+	}
+	return q.store.Put(e.task)
+}
+
+// Done removes the task from the queue with the status, and unblocks
+// the tasks depending on it.
+func (q *Queue) Done(ctx context.Context, id string, status string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.tasks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if e.task.Status == StatusKilled {
+		status = StatusKilled
+	}
+	if err := q.complete(e, status); err != nil {
+		return err
+	}
+	q.process()
+	return nil
+}
+
+// Cancel cancels the task. A queued task is removed from the queue, and
+// the agent running a task is notified through Wait.
+func (q *Queue) Cancel(ctx context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.tasks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	switch e.task.Status {
+	case StatusKilled:
+		return nil
+	case StatusRunning:
+		e.task.Status = StatusKilled
+		close(e.cancel)
+		return q.store.Put(e.task)
+	}
+	close(e.cancel)
+	if err := q.complete(e, StatusKilled); err != nil {
+		return err
+	}
+	q.process()
+	return nil
+}
+
+// Wait blocks until the task is complete. It returns ErrCancel if the
+// task is cancelled.
+func (q *Queue) Wait(ctx context.Context, id string) error {
+	q.mu.Lock()
+	e, ok := q.tasks[id]
+	q.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	select {
+	case <-e.cancel:
+		return ErrCancel
+	case <-e.done:
+		return e.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Info returns the number of tasks, of waiting workers and of connected
+// agents.
+func (q *Queue) Info(ctx context.Context) Info {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	agents := q.connected()
+	info := Info{Workers: len(q.workers), Agents: len(agents)}
+	for _, e := range q.tasks {
+		switch e.task.Status {
+		case StatusPending:
+			info.Pending++
+		case StatusBlocked:
+			info.Blocked++
+		case StatusRunning, StatusKilled:
+			info.Running++
+		}
+		if !schedulable(e, agents) {
+			info.Unschedulable++
+		}
+	}
+	return info
+}
+
+// Unschedulable returns the pending and blocked tasks that no connected
+// agent can run, oldest first. An agent is connected while it polls the
+// queue or runs a task, and for the lease duration after.
+func (q *Queue) Unschedulable(ctx context.Context) []*Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var tasks []*Task
+	agents := q.connected()
+	for _, e := range q.tasks {
+		if !schedulable(e, agents) {
+			tasks = append(tasks, clone(e.task))
+		}
+	}
+	sortTasks(tasks)
+	return tasks
+}
+
+// Close stops the redelivery of the expired tasks and closes the store.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case <-q.closed:
+		return nil
+	default:
+	}
+	close(q.closed)
+	return q.store.Close()
+}
+
+// process leases the pending tasks, oldest first, to the waiting
+// workers.
+func (q *Queue) process() {
+	var pending []*Task
+	for _, e := range q.tasks {
+		if e.task.Status == StatusPending {
+			pending = append(pending, e.task)
+		}
+	}
+	sortTasks(pending)
+
+	for _, t := range pending {
+		if len(q.workers) == 0 {
+			return
+		}
+		e := q.tasks[t.ID]
+		for w := range q.workers {
+			if !e.matches(w.labels) {
+				continue
+			}
+			t.Status = StatusRunning
+			t.Attempts++
+			t.Deadline = q.now().Add(q.lease)
+			q.put(t)
+			e.agent = w.agent
+			delete(q.workers, w)
+			w.ch <- clone(t)
+			break
+		}
+	}
+}
+
+// complete removes the task from the queue, and records its status in
+// the tasks depending on it.
+func (q *Queue) complete(e *entry, status string) error {
+	if err := q.store.Delete(e.task.ID); err != nil {
+		return err
+	}
+	delete(q.tasks, e.task.ID)
+	e.task.Status = status
+	if status == StatusKilled {
+		e.err = ErrCancel
+	}
+	close(e.done)
+
+	for _, dep := range q.tasks {
+		if dep.task.Status != StatusBlocked || !dependsOn(dep.task, e.task.ID) {
+			continue
+		}
+		dep.task.DepStatus[e.task.ID] = status
+		q.put(dep.task)
+		q.resolve(dep)
+	}
+	return nil
+}
+
+// resolve makes the blocked task pending once its dependencies are
+// complete, or skips it if a dependency status is not in RunOn.
+func (q *Queue) resolve(e *entry) {
+	t := e.task
+	for _, dep := range t.Dependencies {
+		if _, ok := t.DepStatus[dep]; !ok {
+			return
+		}
+	}
+	runOn := t.RunOn
+	if len(runOn) == 0 {
+		runOn = []string{StatusSuccess}
+	}
+	for _, status := range t.DepStatus {
+		if !contains(runOn, status) {
+			if err := q.complete(e, StatusSkipped); err != nil {
+				log.Printf("queue: cannot skip task %s: %s", t.ID, err)
+			}
+			return
+		}
+	}
+	t.Status = StatusPending
+	q.put(t)
+}
+
+// seen records the agent of the worker as connected.
+func (q *Queue) seen(w *worker) {
+	a, ok := q.agents[w.agent]
+	if !ok {
+		a = &agent{labels: w.labels}
+		q.agents[w.agent] = a
+	}
+	a.seen = q.now()
+}
+
+// connected returns the labels of the connected agents.
+func (q *Queue) connected() []map[string]string {
+	var labels []map[string]string
+	now := q.now()
+	for key, a := range q.agents {
+		if now.Sub(a.seen) < q.lease || q.waiting(key) {
+			labels = append(labels, a.labels)
+		}
+	}
+	return labels
+}
+
+// waiting returns true if an agent with the key is polling the queue.
+func (q *Queue) waiting(key string) bool {
+	for w := range q.workers {
+		if w.agent == key {
+			return true
+		}
+	}
+	return false
+}
+
+// reap redelivers the running tasks whose lease expired, removes the
+// cancelled tasks whose agent is gone, and logs the tasks no connected
+// agent can run.
+func (q *Queue) reap() {
+	interval := q.lease / 4
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.closed:
+			return
+		case <-ticker.C:
+		}
+
+		q.mu.Lock()
+		now := q.now()
+		for _, e := range q.tasks {
+			if e.task.Deadline.IsZero() || now.Before(e.task.Deadline) {
+				continue
+			}
+			switch e.task.Status {
+			case StatusRunning:
+				log.Printf("queue: lease of task %s expired, redelivering", e.task.ID)
+				e.task.Status = StatusPending
+				e.task.Deadline = time.Time{}
+				q.put(e.task)
+			case StatusKilled:
+				if err := q.complete(e, StatusKilled); err != nil {
+					log.Printf("queue: cannot remove task %s: %s", e.task.ID, err)
+				}
+			}
+		}
+		q.process()
+
+		agents := q.connected()
+		for key, a := range q.agents {
+			if now.Sub(a.seen) >= q.lease && !q.waiting(key) {
+				delete(q.agents, key)
+			}
+		}
+		for _, e := range q.tasks {
+			switch {
+			case schedulable(e, agents):
+				e.reported = false
+			case !e.reported:
+				log.Printf("queue: no connected agent matches task %s", e.task.ID)
+				e.reported = true
+			}
+		}
+		q.mu.Unlock()
+	}
+}
+
+// put saves the task. The queue state in memory remains authoritative
+// if the store fails.
+func (q *Queue) put(t *Task) {
+	if err := q.store.Put(t); err != nil {
+		log.Printf("queue: cannot save task %s: %s", t.ID, err)
+	}
+}
+
+func sortTasks(tasks []*Task) {
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].Created.Equal(tasks[j].Created) {
+			return tasks[i].Created.Before(tasks[j].Created)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+}
+
+func dependsOn(t *Task, id string) bool {
+	return contains(t.Dependencies, id)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func clone(t *Task) *Task {
+	c := *t
+	c.Labels = copyMap(t.Labels)
+	c.DepStatus = copyMap(t.DepStatus)
+	c.Dependencies = append([]string(nil), t.Dependencies...)
+	c.RunOn = append([]string(nil), t.RunOn...)
+	return &c
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
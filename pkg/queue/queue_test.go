@@ -0,0 +1,294 @@
+package queue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	q := newQueue(t, NewMemoryStore())
+	defer q.Close()
+
+	push(t, q, &Task{ID: "1"}, &Task{ID: "2"})
+	if info := q.Info(noContext); info.Pending != 2 {
+		t.Errorf("Want 2 pending tasks, got %+v", info)
+	}
+	for _, id := range []string{"1", "2"} {
+		task := poll(t, q, nil)
+		if task == nil || task.ID != id {
+			t.Fatalf("Want task %s, got %+v", id, task)
+		}
+		if task.Status != StatusRunning || task.Attempts != 1 {
+			t.Errorf("Want task leased, got status %s and %d attempts", task.Status, task.Attempts)
+		}
+	}
+	if err := q.Done(noContext, "1", StatusSuccess); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Done(noContext, "1", StatusSuccess); err != ErrNotFound {
+		t.Errorf("Want ErrNotFound for a completed task, got %v", err)
+	}
+	if err := q.Push(noContext, &Task{ID: "2"}); err == nil {
+		t.Errorf("Want error pushing a queued task")
+	}
+	if info := q.Info(noContext); info.Running != 1 || info.Pending != 0 {
+		t.Errorf("Want 1 running task, got %+v", info)
+	}
+}
+
+func TestQueueLabels(t *testing.T) {
+	q := newQueue(t, NewMemoryStore())
+	defer q.Close()
+
+	push(t, q, &Task{ID: "1", Labels: map[string]string{"platform": "linux/arm64"}})
+	if task := poll(t, q, map[string]string{"platform": "linux/amd64"}); task != nil {
+		t.Errorf("Want no task for the amd64 agent, got %s", task.ID)
+	}
+	if task := poll(t, q, map[string]string{"platform": "linux/arm64", "zone": "a"}); task == nil {
+		t.Errorf("Want task for the arm64 agent")
+	}
+}
+
+func TestQueueSelector(t *testing.T) {
+	q := newQueue(t, NewMemoryStore())
+	defer q.Close()
+
+	if err := q.Push(noContext, &Task{ID: "0", Selector: "region in (us-east"}); err == nil {
+		t.Errorf("Want error pushing a task with an invalid selector")
+	}
+	push(t, q, &Task{ID: "1", Selector: "region in (us-east, us-west), !gpu"})
+	if task := poll(t, q, map[string]string{"region": "eu-west"}); task != nil {
+		t.Errorf("Want no task for the eu-west agent, got %s", task.ID)
+	}
+	if task := poll(t, q, map[string]string{"region": "us-west", "gpu": "true"}); task != nil {
+		t.Errorf("Want no task for the gpu agent, got %s", task.ID)
+	}
+	if task := poll(t, q, map[string]string{"region": "us-west"}); task == nil || task.ID != "1" {
+		t.Errorf("Want task for the us-west agent, got %+v", task)
+	}
+}
+
+func TestQueueUnschedulable(t *testing.T) {
+	q := newQueue(t, NewMemoryStore())
+	defer q.Close()
+
+	push(t, q,
+		&Task{ID: "1", Selector: "pool=large"},
+		&Task{ID: "2", Labels: map[string]string{"platform": "linux/arm64"}},
+		&Task{ID: "3", Dependencies: []string{"1"}, Selector: "gpu"},
+	)
+	if tasks := q.Unschedulable(noContext); len(tasks) != 3 {
+		t.Errorf("Want every task unschedulable without agents, got %d", len(tasks))
+	}
+
+	poll(t, q, map[string]string{"platform": "linux/amd64", "pool": "small"})
+	poll(t, q, map[string]string{"platform": "linux/arm64", "gpu": "true"})
+	tasks := q.Unschedulable(noContext)
+	if len(tasks) != 1 || tasks[0].ID != "1" {
+		t.Fatalf("Want task 1 unschedulable, got %+v", tasks)
+	}
+	if info := q.Info(noContext); info.Unschedulable != 1 || info.Agents != 2 {
+		t.Errorf("Want 1 unschedulable task and 2 agents, got %+v", info)
+	}
+
+	// the agent is disconnected once the lease expires.
+	q.mu.Lock()
+	q.now = func() time.Time { return time.Now().Add(q.lease) }
+	q.mu.Unlock()
+	if info := q.Info(noContext); info.Unschedulable != 2 || info.Agents != 0 {
+		t.Errorf("Want 2 unschedulable tasks and no agents, got %+v", info)
+	}
+}
+
+func TestQueueRedeliver(t *testing.T) {
+	q := newQueue(t, NewMemoryStore(), WithLease(50*time.Millisecond))
+	defer q.Close()
+
+	push(t, q, &Task{ID: "1"})
+	task := poll(t, q, nil)
+	if task == nil {
+		t.Fatal("Want task")
+	}
+	// the lease is kept while it is extended.
+	for i := 0; i < 4; i++ {
+		time.Sleep(25 * time.Millisecond)
+		if err := q.Extend(noContext, "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info := q.Info(noContext); info.Running != 1 {
+		t.Errorf("Want extended task running, got %+v", info)
+	}
+
+	task = poll(t, q, nil)
+	if task == nil || task.ID != "1" || task.Attempts != 2 {
+		t.Fatalf("Want task redelivered after the lease expired, got %+v", task)
+	}
+	if err := q.Extend(noContext, "2"); err != ErrNotFound {
+		t.Errorf("Want ErrNotFound extending an unknown task, got %v", err)
+	}
+}
+
+func TestQueueDependencies(t *testing.T) {
+	q := newQueue(t, NewMemoryStore())
+	defer q.Close()
+
+	push(t, q,
+		&Task{ID: "build"},
+		&Task{ID: "deploy", Dependencies: []string{"build"}},
+		&Task{ID: "notify", Dependencies: []string{"build"}, RunOn: []string{StatusSuccess, StatusFailure}},
+		&Task{ID: "cleanup", Dependencies: []string{"deploy"}, RunOn: []string{StatusSkipped}},
+		&Task{ID: "lint", Dependencies: []string{"unknown"}},
+	)
+	if info := q.Info(noContext); info.Pending != 2 || info.Blocked != 3 {
+		t.Errorf("Want 2 pending and 3 blocked tasks, got %+v", info)
+	}
+	for _, id := range []string{"build", "lint"} {
+		if task := poll(t, q, nil); task == nil || task.ID != id {
+			t.Fatalf("Want task %s, got %+v", id, task)
+		}
+	}
+	if task := poll(t, q, nil); task != nil {
+		t.Fatalf("Want blocked tasks, got %s", task.ID)
+	}
+
+	if err := q.Done(noContext, "build", StatusFailure); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Wait(noContext, "deploy"); err != nil {
+		t.Errorf("Want deploy skipped, got %s", err)
+	}
+	task := poll(t, q, nil)
+	if task == nil || task.ID != "notify" {
+		t.Fatalf("Want notify task running on failure, got %+v", task)
+	}
+	if task.DepStatus["build"] != StatusFailure {
+		t.Errorf("Want dependency status, got %v", task.DepStatus)
+	}
+	if task := poll(t, q, nil); task == nil || task.ID != "cleanup" {
+		t.Fatalf("Want cleanup task running when deploy is skipped, got %+v", task)
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	q := newQueue(t, NewMemoryStore())
+	defer q.Close()
+
+	push(t, q, &Task{ID: "1"}, &Task{ID: "2"})
+	if err := q.Cancel(noContext, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Wait(noContext, "2"); err != nil {
+		t.Errorf("Want nil waiting for a removed task, got %v", err)
+	}
+
+	task := poll(t, q, nil)
+	if task == nil || task.ID != "1" {
+		t.Fatalf("Want task 1, got %+v", task)
+	}
+	waited := make(chan error)
+	go func() {
+		waited <- q.Wait(noContext, "1")
+	}()
+	if err := q.Cancel(noContext, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-waited; err != ErrCancel {
+		t.Errorf("Want ErrCancel waiting for a cancelled task, got %v", err)
+	}
+	if err := q.Extend(noContext, "1"); err != ErrCancel {
+		t.Errorf("Want ErrCancel extending a cancelled task, got %v", err)
+	}
+	if err := q.Done(noContext, "1", StatusSuccess); err != nil {
+		t.Fatal(err)
+	}
+	if info := q.Info(noContext); info != (Info{Agents: 1}) {
+		t.Errorf("Want empty queue, got %+v", info)
+	}
+}
+
+func TestQueueRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queue.db")
+
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := newQueue(t, store)
+	push(t, q,
+		&Task{ID: "1", Data: []byte("{}"), Labels: map[string]string{"platform": "linux/amd64"}},
+		&Task{ID: "2", Dependencies: []string{"1"}},
+	)
+	amd64 := map[string]string{"platform": "linux/amd64"}
+	if task := poll(t, q, amd64); task == nil {
+		t.Fatal("Want task")
+	}
+	q.Close()
+
+	// the agent running task 1 is gone after the restart.
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q = newQueue(t, store, WithLease(20*time.Millisecond))
+	defer q.Close()
+	if info := q.Info(noContext); info.Running != 1 || info.Blocked != 1 {
+		t.Errorf("Want running and blocked tasks restored, got %+v", info)
+	}
+	q.mu.Lock()
+	q.tasks["1"].task.Deadline = time.Now()
+	q.mu.Unlock()
+
+	task := poll(t, q, amd64)
+	if task == nil || task.ID != "1" || string(task.Data) != "{}" || task.Labels["platform"] != "linux/amd64" {
+		t.Fatalf("Want task 1 redelivered, got %+v", task)
+	}
+	if err := q.Done(noContext, "1", StatusSuccess); err != nil {
+		t.Fatal(err)
+	}
+	if task := poll(t, q, nil); task == nil || task.ID != "2" {
+		t.Fatalf("Want dependent task 2, got %+v", task)
+	}
+}
+
+var noContext = context.Background()
+
+func newQueue(t *testing.T, store Store, opts ...Option) *Queue {
+	q, err := New(store, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func push(t *testing.T, q *Queue, tasks ...*Task) {
+	for _, task := range tasks {
+		if err := q.Push(noContext, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// poll returns the next task, or nil if no task is leased within a
+// short timeout.
+func poll(t *testing.T, q *Queue, labels map[string]string) *Task {
+	ctx, cancel := context.WithTimeout(noContext, 200*time.Millisecond)
+	defer cancel()
+	task, err := q.Poll(ctx, labels)
+	if err == context.DeadlineExceeded {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
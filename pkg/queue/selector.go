@@ -0,0 +1,161 @@
+package queue
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Selector operators.
+const (
+	opExists    = "exists"
+	opNotExists = "!"
+	opEquals    = "="
+	opNotEquals = "!="
+	opIn        = "in"
+	opNotIn     = "notin"
+)
+
+var (
+	keyRegexp   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+	valueRegexp = regexp.MustCompile(`^[A-Za-z0-9._/:-]*$`)
+	setRegexp   = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+// Selector is a label selector, a comma separated list of requirements
+// the agent labels must all match:
+//
+//	gpu=true, region in (us-east, us-west), pool notin (legacy), arch, !spot
+//
+// A bare key requires the label to exist, and a key prefixed with ! to
+// not exist. The != and notin requirements match agents without the
+// label.
+type Selector []Requirement
+
+// Requirement is a requirement of a label selector.
+type Requirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// ParseSelector parses the selector expression. An empty expression
+// matches every agent.
+func ParseSelector(expr string) (Selector, error) {
+	var selector Selector
+	if strings.TrimSpace(expr) == "" {
+		return selector, nil
+	}
+	for _, part := range splitRequirements(expr) {
+		req, err := parseRequirement(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("queue: invalid selector %q: %s", expr, err)
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// splitRequirements splits the expression on the commas outside of the
+// value sets.
+func splitRequirements(expr string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range expr {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, expr[start:])
+}
+
+func parseRequirement(s string) (Requirement, error) {
+	var req Requirement
+	switch {
+	case s == "":
+		return req, fmt.Errorf("empty requirement")
+	case setRegexp.MatchString(s):
+		m := setRegexp.FindStringSubmatch(s)
+		req = Requirement{Key: m[1], Operator: m[2]}
+		for _, v := range strings.Split(m[3], ",") {
+			req.Values = append(req.Values, strings.TrimSpace(v))
+		}
+	case strings.HasPrefix(s, "!") && !strings.Contains(s, "="):
+		req = Requirement{Key: strings.TrimSpace(s[1:]), Operator: opNotExists}
+	case strings.Contains(s, "!="):
+		kv := strings.SplitN(s, "!=", 2)
+		req = Requirement{Key: strings.TrimSpace(kv[0]), Operator: opNotEquals, Values: []string{strings.TrimSpace(kv[1])}}
+	case strings.Contains(s, "="):
+		kv := strings.SplitN(strings.Replace(s, "==", "=", 1), "=", 2)
+		req = Requirement{Key: strings.TrimSpace(kv[0]), Operator: opEquals, Values: []string{strings.TrimSpace(kv[1])}}
+	default:
+		req = Requirement{Key: s, Operator: opExists}
+	}
+
+	if !keyRegexp.MatchString(req.Key) {
+		return req, fmt.Errorf("invalid label key %q", req.Key)
+	}
+	for _, v := range req.Values {
+		if !valueRegexp.MatchString(v) {
+			return req, fmt.Errorf("invalid label value %q", v)
+		}
+	}
+	if (req.Operator == opIn || req.Operator == opNotIn) && len(req.Values) == 1 && req.Values[0] == "" {
+		return req, fmt.Errorf("empty set of %s values", req.Key)
+	}
+	return req, nil
+}
+
+// Matches returns true if the labels match every requirement.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		if !req.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches returns true if the labels match the requirement.
+func (r Requirement) Matches(labels map[string]string) bool {
+	v, ok := labels[r.Key]
+	switch r.Operator {
+	case opExists:
+		return ok
+	case opNotExists:
+		return !ok
+	case opEquals, opIn:
+		return ok && contains(r.Values, v)
+	case opNotEquals, opNotIn:
+		return !ok || !contains(r.Values, v)
+	}
+	return false
+}
+
+func (s Selector) String() string {
+	var parts []string
+	for _, req := range s {
+		parts = append(parts, req.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r Requirement) String() string {
+	switch r.Operator {
+	case opExists:
+		return r.Key
+	case opNotExists:
+		return "!" + r.Key
+	case opIn, opNotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ", "))
+	}
+	return r.Key + r.Operator + strings.Join(r.Values, "")
+}
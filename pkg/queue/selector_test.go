@@ -0,0 +1,67 @@
+package queue
+
+import "testing"
+
+func TestSelector(t *testing.T) {
+	labels := map[string]string{
+		"platform": "linux/amd64",
+		"region":   "us-east",
+		"gpu":      "false",
+	}
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{"", true},
+		{"gpu", true},
+		{"!gpu", false},
+		{"!spot", true},
+		{"gpu=false", true},
+		{"gpu==true", false},
+		{"gpu!=true", true},
+		{"pool!=legacy", true},
+		{"region in (us-east, us-west)", true},
+		{"region in (eu-west)", false},
+		{"region notin (us-east)", false},
+		{"pool notin (legacy)", true},
+		{"platform=linux/amd64, region in (us-east,us-west), !spot", true},
+		{"platform=linux/amd64, arch", false},
+	}
+	for _, test := range tests {
+		s, err := ParseSelector(test.expr)
+		if err != nil {
+			t.Errorf("Want selector %q parsed, got %s", test.expr, err)
+			continue
+		}
+		if got := s.Matches(labels); got != test.match {
+			t.Errorf("Want selector %q match %v, got %v", test.expr, test.match, got)
+		}
+	}
+}
+
+func TestSelectorString(t *testing.T) {
+	s, err := ParseSelector("gpu,!spot,region in (us-east,us-west),pool notin(legacy), arch==arm64,zone != a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "gpu, !spot, region in (us-east, us-west), pool notin (legacy), arch=arm64, zone!=a"
+	if got := s.String(); got != want {
+		t.Errorf("Want selector %q, got %q", want, got)
+	}
+}
+
+func TestSelectorInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"gpu,",
+		"region in (us-east",
+		"region in ()",
+		"=true",
+		"gpu=tr ue",
+		"!",
+		"region exists",
+	} {
+		if _, err := ParseSelector(expr); err == nil {
+			t.Errorf("Want error parsing selector %q", expr)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package queue
+
+import "sync"
+
+// Store persists the tasks of the queue, so that the queued and the
+// running tasks survive a restart of the server.
+type Store interface {
+	// Tasks returns the saved tasks.
+	Tasks() ([]*Task, error)
+
+	// Put saves the task.
+	Put(task *Task) error
+
+	// Delete removes the task.
+	Delete(id string) error
+
+	// Close closes the store.
+	Close() error
+}
+
+// memoryStore is a store keeping the tasks in memory.
+type memoryStore struct {
+	sync.Mutex
+	tasks map[string]*Task
+}
+
+// NewMemoryStore returns a store that keeps the tasks in memory. The
+// tasks are lost when the server stops.
+func NewMemoryStore() Store {
+	return &memoryStore{tasks: map[string]*Task{}}
+}
+
+func (s *memoryStore) Tasks() ([]*Task, error) {
+	s.Lock()
+	defer s.Unlock()
+	var tasks []*Task
+	for _, t := range s.tasks {
+		tasks = append(tasks, clone(t))
+	}
+	return tasks, nil
+}
+
+func (s *memoryStore) Put(task *Task) error {
+	s.Lock()
+	s.tasks[task.ID] = clone(task)
+	s.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	s.Lock()
+	delete(s.tasks, id)
+	s.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+// Backoff strategies.
+const (
+	BackoffConstant    = "constant"
+	BackoffLinear      = "linear"
+	BackoffExponential = "exponential"
+)
+
+// attempts returns the maximum number of attempts for the step.
+func attempts(proc *backend.Step) int {
+	if proc.Retry == nil || proc.Retry.Attempts < 1 {
+		return 1
+	}
+	return proc.Retry.Attempts
+}
+
+// retryable returns true if the step error should be retried
+// according to the step retry policy.
+func retryable(proc *backend.Step, err error) bool {
+	if proc.Retry == nil {
+		return false
+	}
+	var code int
+	switch xerr := err.(type) {
+	case *ExitError:
+		code = xerr.Code
+	case *OomError:
+		code = xerr.Code
+	default:
+		return false
+	}
+	if len(proc.Retry.ExitCodes) == 0 {
+		return true
+	}
+	for _, c := range proc.Retry.ExitCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry attempt, where
+// the first retry is attempt 1.
+func backoff(policy backend.Backoff, attempt int) time.Duration {
+	var delay time.Duration
+	switch policy.Strategy {
+	case BackoffLinear:
+		delay = policy.Delay * time.Duration(attempt)
+	case BackoffExponential:
+		delay = policy.Delay
+		for i := 1; i < attempt; i++ {
+			delay *= 2
+			if policy.MaxDelay != 0 && delay > policy.MaxDelay {
+				break
+			}
+		}
+	default:
+		delay = policy.Delay
+	}
+	if policy.MaxDelay != 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,277 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	oldcontext "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/rpc/proto"
+)
+
+// Default client settings.
+const (
+	DefaultRetryLimit = math.MaxInt32
+	DefaultBackoff    = 15 * time.Second
+
+	// MaxMessageSize is the maximum size of the messages sent between
+	// the client and the server, which bounds the size of the uploads.
+	MaxMessageSize = 16 << 20
+)
+
+// Client is a gRPC client of the piped rpc server. Calls failing with a
+// transient error are retried after the backoff, until the retry limit
+// is reached.
+type Client struct {
+	conn    *grpc.ClientConn
+	client  proto.PipedClient
+	retry   int
+	backoff time.Duration
+	token   string
+
+	connected int32 // set while the calls reach the server
+}
+
+// NewClient returns a client of the server at the endpoint, either a
+// host:port address or a grpc:// url. A grpcs:// or https:// url
+// connects with tls.
+func NewClient(endpoint string, opts ...Option) (*Client, error) {
+	c := &Client{
+		retry:   DefaultRetryLimit,
+		backoff: DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	target := endpoint
+	secure := false
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		target = u.Host
+		secure = u.Scheme == "grpcs" || u.Scheme == "https"
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    time.Minute,
+			Timeout: 20 * time.Second,
+		}),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(MaxMessageSize),
+			grpc.MaxCallSendMsgSize(MaxMessageSize),
+		),
+	}
+	if secure {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, "")))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	}
+	if c.token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(tokenCredentials{token: c.token, secure: secure}))
+	}
+
+	conn, err := grpc.Dial(target, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	c.client = proto.NewPipedClient(conn)
+	// the dial does not block, so the client is connected until a call
+	// fails to reach the server. The first call is a long poll of Next,
+	// which may not return for the poll timeout of the server.
+	c.connected = 1
+	return c, nil
+}
+
+// Close closes the client connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Next returns the next pipeline in the queue, or nil when the server
+// poll times out.
+func (c *Client) Next(ctx context.Context, f Filter) (*Pipeline, error) {
+	req := &proto.NextRequest{
+		Filter: &proto.Filter{Labels: f.Labels},
+	}
+	var res *proto.NextReply
+	err := c.call(ctx, func() (err error) {
+		res, err = c.client.Next(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.GetPipeline() == nil {
+		return nil, nil
+	}
+	p := &Pipeline{
+		ID:      res.Pipeline.Id,
+		Timeout: res.Pipeline.Timeout,
+		Config:  new(backend.Config),
+	}
+	if err := json.Unmarshal(res.Pipeline.Payload, p.Config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Wait blocks until the pipeline is complete. It returns an error if the
+// pipeline is cancelled.
+func (c *Client) Wait(ctx context.Context, id string) error {
+	req := &proto.WaitRequest{Id: id}
+	return c.call(ctx, func() error {
+		_, err := c.client.Wait(ctx, req)
+		return err
+	})
+}
+
+// Init signals the pipeline is initialized.
+func (c *Client) Init(ctx context.Context, id string, state State) error {
+	req := &proto.InitRequest{Id: id, State: toProtoState(state)}
+	return c.call(ctx, func() error {
+		_, err := c.client.Init(ctx, req)
+		return err
+	})
+}
+
+// Done signals the pipeline is complete.
+func (c *Client) Done(ctx context.Context, id string, state State) error {
+	req := &proto.DoneRequest{Id: id, State: toProtoState(state)}
+	return c.call(ctx, func() error {
+		_, err := c.client.Done(ctx, req)
+		return err
+	})
+}
+
+// Extend extends the pipeline deadline.
+func (c *Client) Extend(ctx context.Context, id string) error {
+	req := &proto.ExtendRequest{Id: id}
+	return c.call(ctx, func() error {
+		_, err := c.client.Extend(ctx, req)
+		return err
+	})
+}
+
+// Update updates the pipeline step state.
+func (c *Client) Update(ctx context.Context, id string, state State) error {
+	req := &proto.UpdateRequest{Id: id, State: toProtoState(state)}
+	return c.call(ctx, func() error {
+		_, err := c.client.Update(ctx, req)
+		return err
+	})
+}
+
+// Upload uploads the pipeline artifact.
+func (c *Client) Upload(ctx context.Context, id string, file *File) error {
+	req := &proto.UploadRequest{
+		Id: id,
+		File: &proto.File{
+			Name: file.Name,
+			Proc: file.Proc,
+			Mime: file.Mime,
+			Time: file.Time,
+			Size: int32(file.Size),
+			Data: file.Data,
+		},
+	}
+	return c.call(ctx, func() error {
+		_, err := c.client.Upload(ctx, req)
+		return err
+	})
+}
+
+// Log writes the pipeline log entry.
+func (c *Client) Log(ctx context.Context, id string, line *Line) error {
+	req := &proto.LogRequest{
+		Id: id,
+		Line: &proto.Line{
+			Proc: line.Proc,
+			Time: line.Time,
+			Pos:  int32(line.Pos),
+			Out:  line.Out,
+		},
+	}
+	return c.call(ctx, func() error {
+		_, err := c.client.Log(ctx, req)
+		return err
+	})
+}
+
+// Connected returns true if the last call reached the server, or no
+// call has failed since the client was created.
+func (c *Client) Connected() bool {
+	return atomic.LoadInt32(&c.connected) == 1
+}
+
+// call calls fn until it succeeds, fails with a permanent error, or the
+// retry limit is reached, waiting for the backoff between the attempts.
+func (c *Client) call(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		switch grpc.Code(err) {
+		case codes.Canceled:
+		case codes.Unavailable, codes.Unauthenticated, codes.DeadlineExceeded:
+			atomic.StoreInt32(&c.connected, 0)
+		default:
+			atomic.StoreInt32(&c.connected, 1)
+		}
+		if err == nil {
+			return nil
+		}
+		switch grpc.Code(err) {
+		case codes.Aborted, codes.DataLoss, codes.DeadlineExceeded, codes.Internal, codes.Unavailable:
+			// transient errors are retried.
+		default:
+			return err
+		}
+		if attempt >= c.retry {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.backoff):
+		}
+	}
+}
+
+func toProtoState(state State) *proto.State {
+	return &proto.State{
+		Proc:     state.Proc,
+		Exited:   state.Exited,
+		Skipped:  state.Skipped,
+		ExitCode: int32(state.ExitCode),
+		Started:  state.Started,
+		Finished: state.Finished,
+		Error:    state.Error,
+	}
+}
+
+// tokenCredentials sends the token with every call.
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (t tokenCredentials) GetRequestMetadata(ctx oldcontext.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return t.secure
+}
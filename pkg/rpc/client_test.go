@@ -0,0 +1,283 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+	"github.com/marjoram/pipeline/pipeline/rpc"
+	"github.com/marjoram/pipeline/pipeline/rpc/rpctest"
+)
+
+func TestClient(t *testing.T) {
+	work := &rpc.Pipeline{
+		ID:      "1",
+		Timeout: 60,
+		Config: &backend.Config{
+			Stages: []*backend.Stage{{Name: "build"}},
+		},
+	}
+	peer := newPeer(work)
+	server := rpctest.NewServer(peer)
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	filter := rpc.Filter{Labels: map[string]string{"platform": "linux/amd64"}}
+	got, err := client.Next(ctx, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, work) {
+		t.Errorf("Want pipeline %+v, got %+v", work, got)
+	}
+	if !reflect.DeepEqual(peer.filter, filter) {
+		t.Errorf("Want filter %v, got %v", filter, peer.filter)
+	}
+
+	waited := make(chan error)
+	go func() {
+		waited <- client.Wait(ctx, "1")
+	}()
+
+	state := rpc.State{Proc: "build", Exited: true, ExitCode: 2, Started: 1, Finished: 2, Error: "failed"}
+	file := &rpc.File{Name: "logs.json", Proc: "build", Mime: "application/json+logs", Time: 3, Size: 2, Data: []byte("[]")}
+	line := &rpc.Line{Proc: "build", Time: 1, Pos: 4, Out: "go build\n"}
+	for _, err := range []error{
+		client.Init(ctx, "1", rpc.State{Started: 1}),
+		client.Extend(ctx, "1"),
+		client.Update(ctx, "1", state),
+		client.Upload(ctx, "1", file),
+		client.Log(ctx, "1", line),
+		client.Done(ctx, "1", state),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-waited; err != nil {
+		t.Errorf("Want wait to return when the pipeline is done, got %s", err)
+	}
+
+	want := []string{"next", "init", "extend", "update", "upload", "log", "done"}
+	if !reflect.DeepEqual(peer.calls, want) {
+		t.Errorf("Want calls %v, got %v", want, peer.calls)
+	}
+	if !reflect.DeepEqual(peer.state, state) {
+		t.Errorf("Want state %+v, got %+v", state, peer.state)
+	}
+	if !reflect.DeepEqual(peer.file, file) {
+		t.Errorf("Want file %+v, got %+v", file, peer.file)
+	}
+	if !reflect.DeepEqual(peer.line, line) {
+		t.Errorf("Want line %+v, got %+v", line, peer.line)
+	}
+}
+
+func TestClientWaitCancel(t *testing.T) {
+	peer := newPeer()
+	server := rpctest.NewServer(peer)
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	peer.done <- errors.New("cancelled")
+	if err := client.Wait(context.Background(), "1"); err == nil {
+		t.Errorf("Want error waiting for a cancelled pipeline")
+	}
+}
+
+func TestClientNextTimeout(t *testing.T) {
+	server := rpctest.NewServer(newPeer(), rpc.WithPollTimeout(10*time.Millisecond))
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	work, err := client.Next(context.Background(), rpc.NoFilter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work != nil {
+		t.Errorf("Want no pipeline when the poll times out, got %+v", work)
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	server := rpctest.NewServer(newPeer(), rpc.WithServerToken("secret"))
+	defer server.Close()
+
+	for token, code := range map[string]codes.Code{
+		"":       codes.Unauthenticated,
+		"guess":  codes.Unauthenticated,
+		"secret": codes.OK,
+	} {
+		client, err := server.Client(rpc.WithToken(token), rpc.WithRetryLimit(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = client.Extend(context.Background(), "1")
+		if got := grpc.Code(err); got != code {
+			t.Errorf("Want code %s with token %q, got %s", code, token, got)
+		}
+		client.Close()
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	peer := newPeer()
+	server := rpctest.NewServer(peer)
+	defer server.Close()
+
+	peer.failures = 2
+	client, err := server.Client(rpc.WithRetryLimit(1), rpc.WithBackoff(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !client.Connected() {
+		t.Errorf("Want client connected before the first call")
+	}
+	if err := client.Extend(context.Background(), "1"); grpc.Code(err) != codes.Unavailable {
+		t.Errorf("Want unavailable error when the retry limit is reached, got %v", err)
+	}
+	if client.Connected() {
+		t.Errorf("Want client disconnected after an unavailable error")
+	}
+
+	peer.failures = 2
+	client, err = server.Client(rpc.WithRetryLimit(2), rpc.WithBackoff(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Extend(context.Background(), "1"); err != nil {
+		t.Errorf("Want transient errors retried, got %s", err)
+	}
+	if !client.Connected() {
+		t.Errorf("Want client connected after a successful call")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	client, err = server.Client(rpc.WithRetryLimit(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Extend(ctx, "1"); grpc.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Want deadline exceeded error, got %v", err)
+	}
+	if client.Connected() {
+		t.Errorf("Want client disconnected after a deadline exceeded error")
+	}
+}
+
+// peer is a Peer that hands out the queued pipelines, and records the
+// last call of each kind.
+type peer struct {
+	sync.Mutex
+	queue    chan *rpc.Pipeline
+	done     chan error
+	failures int
+
+	calls  []string
+	filter rpc.Filter
+	state  rpc.State
+	file   *rpc.File
+	line   *rpc.Line
+}
+
+func newPeer(work ...*rpc.Pipeline) *peer {
+	p := &peer{
+		queue: make(chan *rpc.Pipeline, len(work)),
+		done:  make(chan error, 1),
+	}
+	for _, w := range work {
+		p.queue <- w
+	}
+	return p
+}
+
+func (p *peer) record(call string) {
+	p.Lock()
+	p.calls = append(p.calls, call)
+	p.Unlock()
+}
+
+func (p *peer) Next(c context.Context, f rpc.Filter) (*rpc.Pipeline, error) {
+	select {
+	case work := <-p.queue:
+		p.record("next")
+		p.filter = f
+		return work, nil
+	case <-c.Done():
+		return nil, c.Err()
+	}
+}
+
+func (p *peer) Wait(c context.Context, id string) error {
+	select {
+	case err := <-p.done:
+		return err
+	case <-c.Done():
+		return c.Err()
+	}
+}
+
+func (p *peer) Init(c context.Context, id string, state rpc.State) error {
+	p.record("init")
+	return nil
+}
+
+func (p *peer) Done(c context.Context, id string, state rpc.State) error {
+	p.record("done")
+	p.done <- nil
+	return nil
+}
+
+func (p *peer) Extend(c context.Context, id string) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return grpc.Errorf(codes.Unavailable, "queue unavailable")
+	}
+	p.calls = append(p.calls, "extend")
+	return nil
+}
+
+func (p *peer) Update(c context.Context, id string, state rpc.State) error {
+	p.record("update")
+	p.state = state
+	return nil
+}
+
+func (p *peer) Upload(c context.Context, id string, file *rpc.File) error {
+	p.record("upload")
+	p.file = file
+	return nil
+}
+
+func (p *peer) Log(c context.Context, id string, line *rpc.Line) error {
+	p.record("log")
+	p.line = line
+	return nil
+}
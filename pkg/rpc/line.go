@@ -0,0 +1,94 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Line is a line of console output.
+type Line struct {
+	Proc string `json:"proc,omitempty"`
+	Time int64  `json:"time,omitempty"`
+	Pos  int    `json:"pos,omitempty"`
+	Out  string `json:"out,omitempty"`
+}
+
+func (l *Line) String() string {
+	return fmt.Sprintf("[%s:L%v:%vs] %s", l.Proc, l.Pos, l.Time, l.Out)
+}
+
+// LineWriter sends the log lines of a pipeline step to the peer, and
+// keeps the lines for the upload of the complete logs.
+type LineWriter struct {
+	peer  Peer
+	id    string
+	name  string
+	num   int
+	now   time.Time
+	rep   *strings.Replacer
+	buf   string
+	lines []*Line
+}
+
+// NewLineWriter returns a new line writer. The secret values are
+// masked in the output.
+func NewLineWriter(peer Peer, id, name string, secret ...string) *LineWriter {
+	var oldnew []string
+	for _, old := range secret {
+		if old == "" {
+			continue
+		}
+		oldnew = append(oldnew, old, "********")
+	}
+	return &LineWriter{
+		peer: peer,
+		id:   id,
+		name: name,
+		now:  time.Now().UTC(),
+		rep:  strings.NewReplacer(oldnew...),
+	}
+}
+
+// Write sends each complete line of p to the peer. An incomplete line
+// is buffered until the next write, or until Flush is called.
+func (w *LineWriter) Write(p []byte) (n int, err error) {
+	w.buf += string(p)
+	for {
+		i := strings.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.send(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush sends the buffered incomplete line to the peer.
+func (w *LineWriter) Flush() {
+	if w.buf != "" {
+		w.send(w.buf)
+		w.buf = ""
+	}
+}
+
+// Lines returns the lines written, including the buffered incomplete
+// line.
+func (w *LineWriter) Lines() []*Line {
+	w.Flush()
+	return w.lines
+}
+
+func (w *LineWriter) send(out string) {
+	line := &Line{
+		Out:  w.rep.Replace(out),
+		Proc: w.name,
+		Pos:  w.num,
+		Time: int64(time.Since(w.now).Seconds()),
+	}
+	w.peer.Log(context.Background(), w.id, line)
+	w.lines = append(w.lines, line)
+	w.num++
+}
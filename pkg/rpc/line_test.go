@@ -0,0 +1,35 @@
+package rpc_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/marjoram/pipeline/pipeline/rpc"
+)
+
+func TestLineWriter(t *testing.T) {
+	peer := newPeer()
+	w := rpc.NewLineWriter(peer, "1", "build", "hunter2", "")
+
+	io.WriteString(w, "password is hun")
+	if len(peer.calls) != 0 {
+		t.Errorf("Want incomplete line buffered, got %d lines sent", len(peer.calls))
+	}
+	io.WriteString(w, "ter2\nok\nno newline")
+
+	lines := w.Lines()
+	var out []string
+	for i, line := range lines {
+		if line.Pos != i || line.Proc != "build" {
+			t.Errorf("Want line %d of build, got line %d of %s", i, line.Pos, line.Proc)
+		}
+		out = append(out, line.Out)
+	}
+	if got, want := strings.Join(out, "|"), "password is ********\n|ok\n|no newline"; got != want {
+		t.Errorf("Want lines %q, got %q", want, got)
+	}
+	if len(peer.calls) != 3 || peer.line.Out != "no newline" {
+		t.Errorf("Want every line sent to the peer, got %v", peer.calls)
+	}
+}
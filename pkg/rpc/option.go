@@ -0,0 +1,50 @@
+package rpc
+
+import "time"
+
+// Option configures a client option.
+type Option func(*Client)
+
+// WithRetryLimit returns an option to set the number of times a call
+// failing with a transient error is retried.
+func WithRetryLimit(limit int) Option {
+	return func(c *Client) {
+		c.retry = limit
+	}
+}
+
+// WithBackoff returns an option to set the delay between the retries of
+// a failed call.
+func WithBackoff(backoff time.Duration) Option {
+	return func(c *Client) {
+		c.backoff = backoff
+	}
+}
+
+// WithToken returns an option to set the token authenticating the
+// client with the server.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// ServerOption configures a server option.
+type ServerOption func(*Server)
+
+// WithServerToken returns an option to set the token the clients must
+// authenticate with. Clients are not authenticated if the token is
+// empty.
+func WithServerToken(token string) ServerOption {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// WithPollTimeout returns an option to set the time a Next call waits
+// for a pipeline before returning an empty reply.
+func WithPollTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.poll = timeout
+	}
+}
@@ -0,0 +1,814 @@
+// Code generated by protoc-gen-go.
+// source: piped.proto
+// DO NOT EDIT!
+
+/*
+Package proto is a generated protocol buffer package.
+
+It is generated from these files:
+	piped.proto
+
+It has these top-level messages:
+	File
+	State
+	Line
+	Filter
+	Pipeline
+	NextRequest
+	NextReply
+	InitRequest
+	WaitRequest
+	DoneRequest
+	ExtendRequest
+	UpdateRequest
+	UploadRequest
+	LogRequest
+	Empty
+*/
+package proto
+
+import proto1 "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto1.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto1.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type File struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Proc string `protobuf:"bytes,2,opt,name=proc" json:"proc,omitempty"`
+	Mime string `protobuf:"bytes,3,opt,name=mime" json:"mime,omitempty"`
+	Time int64  `protobuf:"varint,4,opt,name=time" json:"time,omitempty"`
+	Size int32  `protobuf:"varint,5,opt,name=size" json:"size,omitempty"`
+	Data []byte `protobuf:"bytes,6,opt,name=data" json:"data,omitempty"`
+}
+
+func (m *File) Reset()                    { *m = File{} }
+func (m *File) String() string            { return proto1.CompactTextString(m) }
+func (*File) ProtoMessage()               {}
+func (*File) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *File) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *File) GetProc() string {
+	if m != nil {
+		return m.Proc
+	}
+	return ""
+}
+
+func (m *File) GetMime() string {
+	if m != nil {
+		return m.Mime
+	}
+	return ""
+}
+
+func (m *File) GetTime() int64 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
+func (m *File) GetSize() int32 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *File) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+type State struct {
+	Proc     string `protobuf:"bytes,1,opt,name=proc" json:"proc,omitempty"`
+	Exited   bool   `protobuf:"varint,2,opt,name=exited" json:"exited,omitempty"`
+	Skipped  bool   `protobuf:"varint,3,opt,name=skipped" json:"skipped,omitempty"`
+	ExitCode int32  `protobuf:"varint,4,opt,name=exit_code,json=exitCode" json:"exit_code,omitempty"`
+	Started  int64  `protobuf:"varint,5,opt,name=started" json:"started,omitempty"`
+	Finished int64  `protobuf:"varint,6,opt,name=finished" json:"finished,omitempty"`
+	Error    string `protobuf:"bytes,7,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *State) Reset()                    { *m = State{} }
+func (m *State) String() string            { return proto1.CompactTextString(m) }
+func (*State) ProtoMessage()               {}
+func (*State) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *State) GetProc() string {
+	if m != nil {
+		return m.Proc
+	}
+	return ""
+}
+
+func (m *State) GetExited() bool {
+	if m != nil {
+		return m.Exited
+	}
+	return false
+}
+
+func (m *State) GetSkipped() bool {
+	if m != nil {
+		return m.Skipped
+	}
+	return false
+}
+
+func (m *State) GetExitCode() int32 {
+	if m != nil {
+		return m.ExitCode
+	}
+	return 0
+}
+
+func (m *State) GetStarted() int64 {
+	if m != nil {
+		return m.Started
+	}
+	return 0
+}
+
+func (m *State) GetFinished() int64 {
+	if m != nil {
+		return m.Finished
+	}
+	return 0
+}
+
+func (m *State) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type Line struct {
+	Proc string `protobuf:"bytes,1,opt,name=proc" json:"proc,omitempty"`
+	Time int64  `protobuf:"varint,2,opt,name=time" json:"time,omitempty"`
+	Pos  int32  `protobuf:"varint,3,opt,name=pos" json:"pos,omitempty"`
+	Out  string `protobuf:"bytes,4,opt,name=out" json:"out,omitempty"`
+}
+
+func (m *Line) Reset()                    { *m = Line{} }
+func (m *Line) String() string            { return proto1.CompactTextString(m) }
+func (*Line) ProtoMessage()               {}
+func (*Line) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *Line) GetProc() string {
+	if m != nil {
+		return m.Proc
+	}
+	return ""
+}
+
+func (m *Line) GetTime() int64 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
+func (m *Line) GetPos() int32 {
+	if m != nil {
+		return m.Pos
+	}
+	return 0
+}
+
+func (m *Line) GetOut() string {
+	if m != nil {
+		return m.Out
+	}
+	return ""
+}
+
+type Filter struct {
+	Labels map[string]string `protobuf:"bytes,1,rep,name=labels" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *Filter) Reset()                    { *m = Filter{} }
+func (m *Filter) String() string            { return proto1.CompactTextString(m) }
+func (*Filter) ProtoMessage()               {}
+func (*Filter) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *Filter) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+type Pipeline struct {
+	Id      string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Timeout int64  `protobuf:"varint,2,opt,name=timeout" json:"timeout,omitempty"`
+	Payload []byte `protobuf:"bytes,3,opt,name=payload" json:"payload,omitempty"`
+}
+
+func (m *Pipeline) Reset()                    { *m = Pipeline{} }
+func (m *Pipeline) String() string            { return proto1.CompactTextString(m) }
+func (*Pipeline) ProtoMessage()               {}
+func (*Pipeline) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *Pipeline) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *Pipeline) GetTimeout() int64 {
+	if m != nil {
+		return m.Timeout
+	}
+	return 0
+}
+
+func (m *Pipeline) GetPayload() []byte {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+type NextRequest struct {
+	Filter *Filter `protobuf:"bytes,1,opt,name=filter" json:"filter,omitempty"`
+}
+
+func (m *NextRequest) Reset()                    { *m = NextRequest{} }
+func (m *NextRequest) String() string            { return proto1.CompactTextString(m) }
+func (*NextRequest) ProtoMessage()               {}
+func (*NextRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *NextRequest) GetFilter() *Filter {
+	if m != nil {
+		return m.Filter
+	}
+	return nil
+}
+
+type NextReply struct {
+	Pipeline *Pipeline `protobuf:"bytes,1,opt,name=pipeline" json:"pipeline,omitempty"`
+}
+
+func (m *NextReply) Reset()                    { *m = NextReply{} }
+func (m *NextReply) String() string            { return proto1.CompactTextString(m) }
+func (*NextReply) ProtoMessage()               {}
+func (*NextReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *NextReply) GetPipeline() *Pipeline {
+	if m != nil {
+		return m.Pipeline
+	}
+	return nil
+}
+
+type InitRequest struct {
+	Id    string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	State *State `protobuf:"bytes,2,opt,name=state" json:"state,omitempty"`
+}
+
+func (m *InitRequest) Reset()                    { *m = InitRequest{} }
+func (m *InitRequest) String() string            { return proto1.CompactTextString(m) }
+func (*InitRequest) ProtoMessage()               {}
+func (*InitRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *InitRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *InitRequest) GetState() *State {
+	if m != nil {
+		return m.State
+	}
+	return nil
+}
+
+type WaitRequest struct {
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *WaitRequest) Reset()                    { *m = WaitRequest{} }
+func (m *WaitRequest) String() string            { return proto1.CompactTextString(m) }
+func (*WaitRequest) ProtoMessage()               {}
+func (*WaitRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *WaitRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+type DoneRequest struct {
+	Id    string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	State *State `protobuf:"bytes,2,opt,name=state" json:"state,omitempty"`
+}
+
+func (m *DoneRequest) Reset()                    { *m = DoneRequest{} }
+func (m *DoneRequest) String() string            { return proto1.CompactTextString(m) }
+func (*DoneRequest) ProtoMessage()               {}
+func (*DoneRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *DoneRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *DoneRequest) GetState() *State {
+	if m != nil {
+		return m.State
+	}
+	return nil
+}
+
+type ExtendRequest struct {
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *ExtendRequest) Reset()                    { *m = ExtendRequest{} }
+func (m *ExtendRequest) String() string            { return proto1.CompactTextString(m) }
+func (*ExtendRequest) ProtoMessage()               {}
+func (*ExtendRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *ExtendRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+type UpdateRequest struct {
+	Id    string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	State *State `protobuf:"bytes,2,opt,name=state" json:"state,omitempty"`
+}
+
+func (m *UpdateRequest) Reset()                    { *m = UpdateRequest{} }
+func (m *UpdateRequest) String() string            { return proto1.CompactTextString(m) }
+func (*UpdateRequest) ProtoMessage()               {}
+func (*UpdateRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *UpdateRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *UpdateRequest) GetState() *State {
+	if m != nil {
+		return m.State
+	}
+	return nil
+}
+
+type UploadRequest struct {
+	Id   string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	File *File  `protobuf:"bytes,2,opt,name=file" json:"file,omitempty"`
+}
+
+func (m *UploadRequest) Reset()                    { *m = UploadRequest{} }
+func (m *UploadRequest) String() string            { return proto1.CompactTextString(m) }
+func (*UploadRequest) ProtoMessage()               {}
+func (*UploadRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *UploadRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *UploadRequest) GetFile() *File {
+	if m != nil {
+		return m.File
+	}
+	return nil
+}
+
+type LogRequest struct {
+	Id   string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Line *Line  `protobuf:"bytes,2,opt,name=line" json:"line,omitempty"`
+}
+
+func (m *LogRequest) Reset()                    { *m = LogRequest{} }
+func (m *LogRequest) String() string            { return proto1.CompactTextString(m) }
+func (*LogRequest) ProtoMessage()               {}
+func (*LogRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *LogRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *LogRequest) GetLine() *Line {
+	if m != nil {
+		return m.Line
+	}
+	return nil
+}
+
+type Empty struct {
+}
+
+func (m *Empty) Reset()                    { *m = Empty{} }
+func (m *Empty) String() string            { return proto1.CompactTextString(m) }
+func (*Empty) ProtoMessage()               {}
+func (*Empty) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func init() {
+	proto1.RegisterType((*File)(nil), "proto.File")
+	proto1.RegisterType((*State)(nil), "proto.State")
+	proto1.RegisterType((*Line)(nil), "proto.Line")
+	proto1.RegisterType((*Filter)(nil), "proto.Filter")
+	proto1.RegisterType((*Pipeline)(nil), "proto.Pipeline")
+	proto1.RegisterType((*NextRequest)(nil), "proto.NextRequest")
+	proto1.RegisterType((*NextReply)(nil), "proto.NextReply")
+	proto1.RegisterType((*InitRequest)(nil), "proto.InitRequest")
+	proto1.RegisterType((*WaitRequest)(nil), "proto.WaitRequest")
+	proto1.RegisterType((*DoneRequest)(nil), "proto.DoneRequest")
+	proto1.RegisterType((*ExtendRequest)(nil), "proto.ExtendRequest")
+	proto1.RegisterType((*UpdateRequest)(nil), "proto.UpdateRequest")
+	proto1.RegisterType((*UploadRequest)(nil), "proto.UploadRequest")
+	proto1.RegisterType((*LogRequest)(nil), "proto.LogRequest")
+	proto1.RegisterType((*Empty)(nil), "proto.Empty")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for Piped service
+
+type PipedClient interface {
+	Next(ctx context.Context, in *NextRequest, opts ...grpc.CallOption) (*NextReply, error)
+	Init(ctx context.Context, in *InitRequest, opts ...grpc.CallOption) (*Empty, error)
+	Wait(ctx context.Context, in *WaitRequest, opts ...grpc.CallOption) (*Empty, error)
+	Done(ctx context.Context, in *DoneRequest, opts ...grpc.CallOption) (*Empty, error)
+	Extend(ctx context.Context, in *ExtendRequest, opts ...grpc.CallOption) (*Empty, error)
+	Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*Empty, error)
+	Upload(ctx context.Context, in *UploadRequest, opts ...grpc.CallOption) (*Empty, error)
+	Log(ctx context.Context, in *LogRequest, opts ...grpc.CallOption) (*Empty, error)
+}
+
+type pipedClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewPipedClient(cc *grpc.ClientConn) PipedClient {
+	return &pipedClient{cc}
+}
+
+func (c *pipedClient) Next(ctx context.Context, in *NextRequest, opts ...grpc.CallOption) (*NextReply, error) {
+	out := new(NextReply)
+	err := grpc.Invoke(ctx, "/proto.Piped/Next", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipedClient) Init(ctx context.Context, in *InitRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/proto.Piped/Init", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipedClient) Wait(ctx context.Context, in *WaitRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/proto.Piped/Wait", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipedClient) Done(ctx context.Context, in *DoneRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/proto.Piped/Done", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipedClient) Extend(ctx context.Context, in *ExtendRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/proto.Piped/Extend", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipedClient) Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/proto.Piped/Update", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipedClient) Upload(ctx context.Context, in *UploadRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/proto.Piped/Upload", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pipedClient) Log(ctx context.Context, in *LogRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/proto.Piped/Log", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Piped service
+
+type PipedServer interface {
+	Next(context.Context, *NextRequest) (*NextReply, error)
+	Init(context.Context, *InitRequest) (*Empty, error)
+	Wait(context.Context, *WaitRequest) (*Empty, error)
+	Done(context.Context, *DoneRequest) (*Empty, error)
+	Extend(context.Context, *ExtendRequest) (*Empty, error)
+	Update(context.Context, *UpdateRequest) (*Empty, error)
+	Upload(context.Context, *UploadRequest) (*Empty, error)
+	Log(context.Context, *LogRequest) (*Empty, error)
+}
+
+func RegisterPipedServer(s *grpc.Server, srv PipedServer) {
+	s.RegisterService(&_Piped_serviceDesc, srv)
+}
+
+func _Piped_Next_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NextRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipedServer).Next(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Piped/Next",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipedServer).Next(ctx, req.(*NextRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Piped_Init_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipedServer).Init(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Piped/Init",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipedServer).Init(ctx, req.(*InitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Piped_Wait_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WaitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipedServer).Wait(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Piped/Wait",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipedServer).Wait(ctx, req.(*WaitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Piped_Done_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DoneRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipedServer).Done(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Piped/Done",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipedServer).Done(ctx, req.(*DoneRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Piped_Extend_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipedServer).Extend(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Piped/Extend",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipedServer).Extend(ctx, req.(*ExtendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Piped_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipedServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Piped/Update",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipedServer).Update(ctx, req.(*UpdateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Piped_Upload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipedServer).Upload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Piped/Upload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipedServer).Upload(ctx, req.(*UploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Piped_Log_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipedServer).Log(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Piped/Log",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipedServer).Log(ctx, req.(*LogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Piped_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Piped",
+	HandlerType: (*PipedServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Next",
+			Handler:    _Piped_Next_Handler,
+		},
+		{
+			MethodName: "Init",
+			Handler:    _Piped_Init_Handler,
+		},
+		{
+			MethodName: "Wait",
+			Handler:    _Piped_Wait_Handler,
+		},
+		{
+			MethodName: "Done",
+			Handler:    _Piped_Done_Handler,
+		},
+		{
+			MethodName: "Extend",
+			Handler:    _Piped_Extend_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _Piped_Update_Handler,
+		},
+		{
+			MethodName: "Upload",
+			Handler:    _Piped_Upload_Handler,
+		},
+		{
+			MethodName: "Log",
+			Handler:    _Piped_Log_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "piped.proto",
+}
+
+func init() { proto1.RegisterFile("piped.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 624 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x53, 0xd1, 0x6b, 0x13, 0x4f,
+	0x10, 0xfe, 0x5d, 0x72, 0x77, 0x4d, 0xe6, 0xda, 0x9f, 0x75, 0x29, 0x72, 0x46, 0xa4, 0x61, 0x41,
+	0x08, 0x0a, 0x01, 0xa3, 0x0f, 0x55, 0x10, 0x94, 0xda, 0x82, 0x10, 0x8a, 0xac, 0x48, 0x1f, 0x65,
+	0xdb, 0xdb, 0xd6, 0xa5, 0x97, 0xdb, 0xf5, 0x6e, 0x5b, 0x7a, 0x3e, 0xf8, 0x67, 0xf9, 0x9f, 0xf9,
+	0x2e, 0xb3, 0xbb, 0x77, 0xbd, 0xd8, 0xa4, 0x2f, 0x7d, 0xca, 0xcc, 0x37, 0xdf, 0xcc, 0x7c, 0xb3,
+	0xdf, 0x05, 0x12, 0x2d, 0xb5, 0xc8, 0xa6, 0xba, 0x54, 0x46, 0x91, 0xc8, 0xfe, 0xd0, 0x5f, 0x10,
+	0x1e, 0xca, 0x5c, 0x10, 0x02, 0x61, 0xc1, 0x17, 0x22, 0x0d, 0xc6, 0xc1, 0x64, 0xc8, 0x6c, 0x8c,
+	0x98, 0x2e, 0xd5, 0x69, 0xda, 0x73, 0x18, 0xc6, 0x88, 0x2d, 0xe4, 0x42, 0xa4, 0x7d, 0x87, 0x61,
+	0x8c, 0x98, 0x41, 0x2c, 0x1c, 0x07, 0x93, 0x3e, 0x0b, 0x8d, 0xc7, 0x2a, 0xf9, 0x53, 0xa4, 0xd1,
+	0x38, 0x98, 0x44, 0xcc, 0xc6, 0x88, 0x65, 0xdc, 0xf0, 0x34, 0x1e, 0x07, 0x93, 0x4d, 0x66, 0x63,
+	0xfa, 0x3b, 0x80, 0xe8, 0x8b, 0xe1, 0xe6, 0x66, 0x5b, 0xd0, 0xd9, 0xf6, 0x08, 0x62, 0x71, 0x2d,
+	0x8d, 0xc8, 0xac, 0x86, 0x01, 0xf3, 0x19, 0x49, 0x61, 0xa3, 0xba, 0x90, 0x5a, 0x8b, 0xcc, 0x0a,
+	0x19, 0xb0, 0x26, 0x25, 0x4f, 0x60, 0x88, 0x9c, 0x6f, 0xa7, 0x2a, 0x73, 0x82, 0x22, 0x36, 0x40,
+	0x60, 0x5f, 0x65, 0xc2, 0xb6, 0x19, 0x5e, 0xe2, 0xbc, 0xc8, 0x6a, 0x6d, 0x52, 0x32, 0x82, 0xc1,
+	0x99, 0x2c, 0x64, 0xf5, 0x5d, 0x64, 0x56, 0x5e, 0x9f, 0xb5, 0x39, 0xd9, 0x81, 0x48, 0x94, 0xa5,
+	0x2a, 0xd3, 0x0d, 0xab, 0xcc, 0x25, 0x94, 0x41, 0x38, 0x97, 0xc5, 0x6a, 0xd9, 0xcd, 0x83, 0xf4,
+	0x3a, 0x0f, 0xb2, 0x0d, 0x7d, 0xad, 0x2a, 0x2b, 0x37, 0x62, 0x18, 0x22, 0xa2, 0x2e, 0x8d, 0x15,
+	0x39, 0x64, 0x18, 0xd2, 0x2b, 0x88, 0x0f, 0x65, 0x6e, 0x44, 0x49, 0x5e, 0x42, 0x9c, 0xf3, 0x13,
+	0x91, 0x57, 0x69, 0x30, 0xee, 0x4f, 0x92, 0xd9, 0x63, 0xe7, 0xda, 0xd4, 0x95, 0xa7, 0x73, 0x5b,
+	0x3b, 0x28, 0x4c, 0x59, 0x33, 0x4f, 0x1c, 0xbd, 0x81, 0xa4, 0x03, 0xe3, 0xf4, 0x0b, 0x51, 0x7b,
+	0x59, 0x18, 0xe2, 0x1d, 0x57, 0x3c, 0xbf, 0x14, 0xde, 0x4f, 0x97, 0xbc, 0xed, 0xed, 0x05, 0xf4,
+	0x08, 0x06, 0x9f, 0xa5, 0x16, 0x39, 0xde, 0xf3, 0x3f, 0xf4, 0x64, 0xe6, 0xdb, 0x7a, 0xd2, 0x3e,
+	0x35, 0xea, 0x47, 0xa5, 0xee, 0x9c, 0x26, 0xc5, 0x8a, 0xe6, 0x75, 0xae, 0xb8, 0x33, 0x61, 0x93,
+	0x35, 0x29, 0x7d, 0x0d, 0xc9, 0x91, 0xb8, 0x36, 0x4c, 0xfc, 0xb8, 0x14, 0x95, 0x21, 0xcf, 0x20,
+	0x3e, 0xb3, 0xba, 0xed, 0xd8, 0x64, 0xb6, 0xb5, 0x74, 0x0c, 0xf3, 0x45, 0xba, 0x07, 0x43, 0xd7,
+	0xa5, 0xf3, 0x9a, 0xbc, 0x80, 0x81, 0xf6, 0x92, 0x7c, 0xd7, 0x03, 0xdf, 0xd5, 0x28, 0x65, 0x2d,
+	0x81, 0x7e, 0x80, 0xe4, 0x53, 0x21, 0xdb, 0x7d, 0xff, 0x9e, 0x40, 0x21, 0xaa, 0xf0, 0x13, 0xb3,
+	0x07, 0x24, 0xb3, 0x4d, 0x3f, 0xc8, 0x7e, 0x76, 0xcc, 0x95, 0xe8, 0x53, 0x48, 0x8e, 0xf9, 0xda,
+	0x11, 0xb8, 0xe1, 0xa3, 0x2a, 0xc4, 0x7d, 0x36, 0xec, 0xc2, 0xd6, 0xc1, 0xb5, 0x11, 0x45, 0xb6,
+	0x6e, 0xc7, 0x3e, 0x6c, 0x7d, 0xd5, 0x19, 0x76, 0xdc, 0x63, 0xcb, 0x7b, 0x1c, 0x82, 0x26, 0xac,
+	0x1b, 0xb2, 0x0b, 0xe1, 0x99, 0xcc, 0x9b, 0x19, 0xc9, 0x8d, 0x15, 0x82, 0xd9, 0x02, 0x7d, 0x07,
+	0x30, 0x57, 0xe7, 0x77, 0xb4, 0x5b, 0x4f, 0x96, 0xdb, 0xf1, 0x9f, 0xc0, 0x6c, 0x81, 0x6e, 0x40,
+	0x74, 0xb0, 0xd0, 0xa6, 0x9e, 0xfd, 0xe9, 0x41, 0x84, 0x5e, 0x65, 0x64, 0x0a, 0x21, 0x1a, 0x4b,
+	0x88, 0x67, 0x77, 0xbe, 0x8d, 0xd1, 0xf6, 0x12, 0xa6, 0xf3, 0x9a, 0xfe, 0x47, 0x9e, 0x43, 0x88,
+	0x76, 0xb6, 0xfc, 0x8e, 0xb7, 0xa3, 0xe6, 0x68, 0xbb, 0xc3, 0x71, 0x8f, 0x79, 0x87, 0x7b, 0xcc,
+	0xef, 0xe4, 0xa2, 0x89, 0x2d, 0xb7, 0xe3, 0xe8, 0x2d, 0xee, 0x14, 0x62, 0xe7, 0x16, 0xd9, 0x69,
+	0x2a, 0x5d, 0xf3, 0x56, 0xf1, 0x9d, 0x79, 0x2d, 0x7f, 0xc9, 0xcb, 0xd5, 0x7c, 0xf4, 0xa9, 0xc3,
+	0xef, 0xd8, 0x76, 0x8b, 0x3f, 0x81, 0xfe, 0x5c, 0x9d, 0x93, 0x87, 0xcd, 0x83, 0xab, 0xf3, 0x35,
+	0xcc, 0x93, 0xd8, 0xa6, 0xaf, 0xfe, 0x0e, 0x00, 0x57, 0x5e, 0x26, 0x54, 0xee, 0x05, 0x00, 0x00,
+}
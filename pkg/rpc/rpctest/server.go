@@ -0,0 +1,41 @@
+// Package rpctest runs a piped rpc server in the test process.
+package rpctest
+
+import (
+	"net"
+
+	"github.com/marjoram/pipeline/pipeline/rpc"
+)
+
+// Server is a piped rpc server listening on a local port.
+type Server struct {
+	// Addr is the host:port address of the server.
+	Addr string
+
+	server *rpc.Server
+}
+
+// NewServer starts and returns a server of the peer. The caller should
+// call Close when finished, to shut it down.
+func NewServer(peer rpc.Peer, opts ...rpc.ServerOption) *Server {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("rpctest: failed to listen on a port: " + err.Error())
+	}
+	s := &Server{
+		Addr:   lis.Addr().String(),
+		server: rpc.NewServer(peer, opts...),
+	}
+	go s.server.Serve(lis)
+	return s
+}
+
+// Client returns a client connected to the server.
+func (s *Server) Client(opts ...rpc.Option) (*rpc.Client, error) {
+	return rpc.NewClient(s.Addr, opts...)
+}
+
+// Close stops the server and closes the client connections.
+func (s *Server) Close() {
+	s.server.Stop()
+}
@@ -0,0 +1,196 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+
+	oldcontext "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/marjoram/pipeline/pipeline/rpc/proto"
+)
+
+// DefaultPollTimeout is the default time a Next call waits for a
+// pipeline before returning an empty reply.
+const DefaultPollTimeout = 30 * time.Second
+
+// Server serves a peer, usually backed by the job queue, to the piped
+// agents over gRPC.
+type Server struct {
+	grpc  *grpc.Server
+	peer  Peer
+	token string
+	poll  time.Duration
+}
+
+// NewServer returns a server of the peer.
+func NewServer(peer Peer, opts ...ServerOption) *Server {
+	s := &Server{
+		peer: peer,
+		poll: DefaultPollTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.grpc = grpc.NewServer(
+		grpc.MaxRecvMsgSize(MaxMessageSize),
+		grpc.MaxSendMsgSize(MaxMessageSize),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime: 30 * time.Second,
+		}),
+	)
+	proto.RegisterPipedServer(s.grpc, &handler{s})
+	return s
+}
+
+// Serve accepts the client connections on the listener. It returns
+// when the listener fails or the server is stopped.
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpc.Serve(lis)
+}
+
+// Stop closes the listeners and the client connections.
+func (s *Server) Stop() {
+	s.grpc.Stop()
+}
+
+// GracefulStop closes the listeners, and waits for the pending calls to
+// complete before closing the client connections.
+func (s *Server) GracefulStop() {
+	s.grpc.GracefulStop()
+}
+
+// authorize returns an error if the call is not authenticated with the
+// server token.
+func (s *Server) authorize(ctx context.Context) error {
+	if s.token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md["authorization"] {
+		token := strings.TrimPrefix(v, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+			return nil
+		}
+	}
+	return grpc.Errorf(codes.Unauthenticated, "invalid or missing token")
+}
+
+// handler implements the gRPC service on top of the peer.
+type handler struct {
+	*Server
+}
+
+func (h *handler) Next(c oldcontext.Context, req *proto.NextRequest) (*proto.NextReply, error) {
+	if err := h.authorize(c); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(c, h.poll)
+	defer cancel()
+
+	filter := Filter{Labels: req.GetFilter().GetLabels()}
+	p, err := h.peer.Next(ctx, filter)
+	if err != nil {
+		// the poll timed out, the client calls again.
+		if ctx.Err() != nil && c.Err() == nil {
+			return new(proto.NextReply), nil
+		}
+		return nil, err
+	}
+	if p == nil {
+		return new(proto.NextReply), nil
+	}
+	payload, err := json.Marshal(p.Config)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.NextReply{
+		Pipeline: &proto.Pipeline{
+			Id:      p.ID,
+			Timeout: p.Timeout,
+			Payload: payload,
+		},
+	}, nil
+}
+
+func (h *handler) Wait(c oldcontext.Context, req *proto.WaitRequest) (*proto.Empty, error) {
+	if err := h.authorize(c); err != nil {
+		return nil, err
+	}
+	return new(proto.Empty), h.peer.Wait(c, req.GetId())
+}
+
+func (h *handler) Init(c oldcontext.Context, req *proto.InitRequest) (*proto.Empty, error) {
+	if err := h.authorize(c); err != nil {
+		return nil, err
+	}
+	return new(proto.Empty), h.peer.Init(c, req.GetId(), fromProtoState(req.GetState()))
+}
+
+func (h *handler) Done(c oldcontext.Context, req *proto.DoneRequest) (*proto.Empty, error) {
+	if err := h.authorize(c); err != nil {
+		return nil, err
+	}
+	return new(proto.Empty), h.peer.Done(c, req.GetId(), fromProtoState(req.GetState()))
+}
+
+func (h *handler) Extend(c oldcontext.Context, req *proto.ExtendRequest) (*proto.Empty, error) {
+	if err := h.authorize(c); err != nil {
+		return nil, err
+	}
+	return new(proto.Empty), h.peer.Extend(c, req.GetId())
+}
+
+func (h *handler) Update(c oldcontext.Context, req *proto.UpdateRequest) (*proto.Empty, error) {
+	if err := h.authorize(c); err != nil {
+		return nil, err
+	}
+	return new(proto.Empty), h.peer.Update(c, req.GetId(), fromProtoState(req.GetState()))
+}
+
+func (h *handler) Upload(c oldcontext.Context, req *proto.UploadRequest) (*proto.Empty, error) {
+	if err := h.authorize(c); err != nil {
+		return nil, err
+	}
+	file := req.GetFile()
+	return new(proto.Empty), h.peer.Upload(c, req.GetId(), &File{
+		Name: file.GetName(),
+		Proc: file.GetProc(),
+		Mime: file.GetMime(),
+		Time: file.GetTime(),
+		Size: int(file.GetSize()),
+		Data: file.GetData(),
+	})
+}
+
+func (h *handler) Log(c oldcontext.Context, req *proto.LogRequest) (*proto.Empty, error) {
+	if err := h.authorize(c); err != nil {
+		return nil, err
+	}
+	line := req.GetLine()
+	return new(proto.Empty), h.peer.Log(c, req.GetId(), &Line{
+		Proc: line.GetProc(),
+		Time: line.GetTime(),
+		Pos:  int(line.GetPos()),
+		Out:  line.GetOut(),
+	})
+}
+
+func fromProtoState(state *proto.State) State {
+	return State{
+		Proc:     state.GetProc(),
+		Exited:   state.GetExited(),
+		Skipped:  state.GetSkipped(),
+		ExitCode: int(state.GetExitCode()),
+		Started:  state.GetStarted(),
+		Finished: state.GetFinished(),
+		Error:    state.GetError(),
+	}
+}
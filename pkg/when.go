@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+// Pipeline status values matched by the status constraint.
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// shouldRun returns true if the step should be executed given the
+// current pipeline status. A status constraint takes precedence over
+// the step OnSuccess and OnFailure flags.
+func shouldRun(proc *backend.Step, perr error) bool {
+	status := StatusSuccess
+	if perr != nil {
+		status = StatusFailure
+	}
+
+	when := proc.When
+	if when == nil || isEmpty(when.Status) {
+		switch {
+		case status == StatusFailure && proc.OnFailure == false:
+			return false
+		case status == StatusSuccess && proc.OnSuccess == false:
+			return false
+		}
+	}
+	if when == nil {
+		return true
+	}
+	return matchWhen(when, proc.Environment, status)
+}
+
+// matchWhen returns true if the step environment and pipeline status
+// satisfy all the step conditions.
+func matchWhen(when *backend.When, env map[string]string, status string) bool {
+	switch {
+	case !match(when.Status, status):
+		return false
+	case !match(when.Branch, env["CI_COMMIT_BRANCH"]):
+		return false
+	case !match(when.Event, env["CI_BUILD_EVENT"]):
+		return false
+	case !match(when.Ref, env["CI_COMMIT_REF"]):
+		return false
+	case !matchPaths(when.Paths, env["CI_COMMIT_FILES"]):
+		return false
+	}
+	for name, constraint := range when.Environment {
+		if !match(constraint, env[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns true if the value matches at least one included pattern,
+// and none of the excluded patterns.
+func match(c backend.Constraint, value string) bool {
+	if len(c.Include) != 0 && !matchAny(c.Include, value) {
+		return false
+	}
+	return !matchAny(c.Exclude, value)
+}
+
+// matchPaths returns true if at least one of the newline separated
+// changed files matches the constraint. The constraint is ignored if
+// the changed files are unknown.
+func matchPaths(c backend.Constraint, files string) bool {
+	if isEmpty(c) || strings.TrimSpace(files) == "" {
+		return true
+	}
+	for _, file := range strings.Split(files, "\n") {
+		if file = strings.TrimSpace(file); file != "" && match(c, file) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if glob(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// glob reports whether the name matches the shell pattern, where **
+// also matches path separators.
+func glob(pattern, name string) bool {
+	if !strings.Contains(pattern, "**") {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	ok, _ := regexp.MatchString(expr.String(), name)
+	return ok
+}
+
+func isEmpty(c backend.Constraint) bool {
+	return len(c.Include) == 0 && len(c.Exclude) == 0
+}
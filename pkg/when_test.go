@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/marjoram/pipeline/pipeline/backend"
+)
+
+func TestShouldRun(t *testing.T) {
+	env := map[string]string{
+		"CI_COMMIT_BRANCH": "feature/login",
+		"CI_BUILD_EVENT":   "push",
+		"CI_COMMIT_REF":    "refs/heads/feature/login",
+		"CI_COMMIT_FILES":  "docs/README.md\npkg/backend/docker/docker.go",
+		"CI_REPO":          "marjoram/pipeline",
+	}
+	failed := errors.New("exit code 1")
+
+	tests := []struct {
+		step *backend.Step
+		perr error
+		want bool
+	}{
+		{step: &backend.Step{OnSuccess: true}, want: true},
+		{step: &backend.Step{OnSuccess: true}, perr: failed, want: false},
+		{step: &backend.Step{OnFailure: true}, perr: failed, want: true},
+		{
+			step: &backend.Step{OnSuccess: true, When: &backend.When{
+				Branch: backend.Constraint{Include: []string{"feature/*"}},
+			}},
+			want: true,
+		},
+		{
+			step: &backend.Step{OnSuccess: true, When: &backend.When{
+				Branch: backend.Constraint{Include: []string{"master"}},
+			}},
+			want: false,
+		},
+		{
+			step: &backend.Step{OnSuccess: true, When: &backend.When{
+				Event: backend.Constraint{Exclude: []string{"push"}},
+			}},
+			want: false,
+		},
+		{
+			step: &backend.Step{OnSuccess: true, When: &backend.When{
+				Ref: backend.Constraint{Include: []string{"refs/heads/**"}},
+			}},
+			want: true,
+		},
+		{
+			step: &backend.Step{OnSuccess: true, When: &backend.When{
+				Paths: backend.Constraint{Include: []string{"pkg/**/*.go"}},
+			}},
+			want: true,
+		},
+		{
+			step: &backend.Step{OnSuccess: true, When: &backend.When{
+				Paths: backend.Constraint{Include: []string{"cmd/**"}},
+			}},
+			want: false,
+		},
+		{
+			step: &backend.Step{OnSuccess: true, When: &backend.When{
+				Environment: map[string]backend.Constraint{
+					"CI_REPO": {Include: []string{"marjoram/*"}},
+				},
+			}},
+			want: true,
+		},
+		{
+			step: &backend.Step{When: &backend.When{
+				Status: backend.Constraint{Include: []string{StatusSuccess, StatusFailure}},
+			}},
+			perr: failed,
+			want: true,
+		},
+		{
+			step: &backend.Step{OnSuccess: true, OnFailure: true, When: &backend.When{
+				Status: backend.Constraint{Include: []string{StatusFailure}},
+			}},
+			want: false,
+		},
+	}
+	for i, test := range tests {
+		test.step.Environment = env
+		if got := shouldRun(test.step, test.perr); got != test.want {
+			t.Errorf("Want test %d to return %v, got %v", i, test.want, got)
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"master", "master", true},
+		{"release/*", "release/1.0", true},
+		{"release/*", "release/1.0/hotfix", false},
+		{"release/**", "release/1.0/hotfix", true},
+		{"**/*.md", "docs/api/README.md", true},
+		{"*.md", "docs/README.md", false},
+		{"v?.0", "v1.0", true},
+	}
+	for _, test := range tests {
+		if got := glob(test.pattern, test.name); got != test.want {
+			t.Errorf("Want glob %q match %q %v, got %v", test.pattern, test.name, test.want, got)
+		}
+	}
+}